@@ -0,0 +1,147 @@
+// Package admin provides an HTTP API for managing the VWAP calculation engine
+// at runtime.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Name of the form field holding the comma separated list of trading pairs.
+const productIDsField string = "product_ids"
+
+// PairManager is implemented by engines whose trading pairs can be changed at
+// runtime.
+type PairManager interface {
+	TradingPairs() []string
+	ValidateTradingPairs(pairs []string) error
+	AddTradingPairs(pairs []string) error
+	RemoveTradingPairs(pairs []string) error
+}
+
+// pairsResponse is the body of every successful response, holding the trading
+// pairs tracked after the request was handled.
+type pairsResponse struct {
+	ProductIDs []string `json:"product_ids"`
+}
+
+// errorResponse is the body of every failed response.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler creates an HTTP handler for the admin API, which manages the
+// trading pairs of the given engine. The following endpoints are provided:
+//   - GET /pairs: lists the current trading pairs.
+//   - POST /subscribe: adds the trading pairs in the product_ids field, which
+//     are validated first.
+//   - POST /unsubscribe: removes the trading pairs in the product_ids field.
+//
+// If the given token is not empty, every request must carry it in an
+// "Authorization: Bearer <token>" header. The API has no other access
+// control, so without a token it must only listen on a loopback address.
+func NewHandler(manager PairManager, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/pairs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed,
+				errors.New("method not allowed"))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, pairsResponse{manager.TradingPairs()})
+	})
+
+	mux.HandleFunc("/subscribe", newUpdateHandler(manager,
+		manager.ValidateTradingPairs, manager.AddTradingPairs))
+	mux.HandleFunc("/unsubscribe", newUpdateHandler(manager, nil,
+		manager.RemoveTradingPairs))
+
+	if token == "" {
+		return mux
+	}
+	return requireToken(mux, token)
+}
+
+// requireToken returns a handler that passes requests on to the given handler
+// only if they carry the given bearer token.
+func requireToken(handler http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(authorization, expected) != 1 {
+			writeError(w, http.StatusUnauthorized,
+				errors.New("unauthorized"))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newUpdateHandler returns a handler function that parses the trading pairs in
+// a POST request and passes them to the given update function. Pairs rejected
+// by the given validation function, if any, are a bad request.
+func newUpdateHandler(
+	manager PairManager, validate, update func([]string) error,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed,
+				errors.New("method not allowed"))
+			return
+		}
+
+		pairs := parseProductIDs(r.FormValue(productIDsField))
+		if len(pairs) == 0 {
+			writeError(w, http.StatusBadRequest,
+				errors.New("no trading pairs in field "+productIDsField))
+			return
+		}
+
+		if validate != nil {
+			if err := validate(pairs); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if err := update(pairs); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("Trading pairs updated through %s: %v", r.URL.Path,
+			manager.TradingPairs())
+		writeJSON(w, http.StatusOK, pairsResponse{manager.TradingPairs()})
+	}
+}
+
+// parseProductIDs splits a comma separated list of product IDs, ignoring empty
+// entries.
+func parseProductIDs(value string) []string {
+	var productIDs []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			productIDs = append(productIDs, id)
+		}
+	}
+	return productIDs
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, errorResponse{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing admin API response: %v", err)
+	}
+}
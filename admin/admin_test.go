@@ -0,0 +1,243 @@
+// +build unit
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePairManager keeps trading pairs in memory, failing updates with err if it
+// is set, and rejecting the pairs in invalid.
+type fakePairManager struct {
+	pairs   []string
+	invalid []string
+	err     error
+}
+
+func (m *fakePairManager) TradingPairs() []string {
+	return m.pairs
+}
+
+func (m *fakePairManager) ValidateTradingPairs(pairs []string) error {
+	for _, pair := range pairs {
+		for _, invalid := range m.invalid {
+			if pair == invalid {
+				return errors.New("invalid trading pair " + pair)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *fakePairManager) AddTradingPairs(pairs []string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.pairs = append(m.pairs, pairs...)
+	return nil
+}
+
+func (m *fakePairManager) RemoveTradingPairs(pairs []string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	removed := make(map[string]bool)
+	for _, pair := range pairs {
+		removed[pair] = true
+	}
+
+	var remaining []string
+	for _, pair := range m.pairs {
+		if !removed[pair] {
+			remaining = append(remaining, pair)
+		}
+	}
+	m.pairs = remaining
+	return nil
+}
+
+func Test_NewHandler(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		method             string
+		path               string
+		productIDs         string
+		managerErr         error
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			desc:               "list pairs",
+			method:             http.MethodGet,
+			path:               "/pairs",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       `{"product_ids":["pair1","pair2"]}`,
+		},
+		{
+			desc:               "list pairs, wrong method",
+			method:             http.MethodPost,
+			path:               "/pairs",
+			expectedStatusCode: http.StatusMethodNotAllowed,
+			expectedBody:       `{"error":"method not allowed"}`,
+		},
+		{
+			desc:               "subscribe, wrong method",
+			method:             http.MethodGet,
+			path:               "/subscribe",
+			expectedStatusCode: http.StatusMethodNotAllowed,
+			expectedBody:       `{"error":"method not allowed"}`,
+		},
+		{
+			desc:               "subscribe, no pairs",
+			method:             http.MethodPost,
+			path:               "/subscribe",
+			productIDs:         " , ",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `{"error":"no trading pairs in field product_ids"}`,
+		},
+		{
+			desc:               "subscribe, invalid pair",
+			method:             http.MethodPost,
+			path:               "/subscribe",
+			productIDs:         "pair3, pair5",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `{"error":"invalid trading pair pair5"}`,
+		},
+		{
+			desc:               "subscribe, manager error",
+			method:             http.MethodPost,
+			path:               "/subscribe",
+			productIDs:         "pair3",
+			managerErr:         errors.New("some error"),
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedBody:       `{"error":"some error"}`,
+		},
+		{
+			desc:               "subscribe",
+			method:             http.MethodPost,
+			path:               "/subscribe",
+			productIDs:         "pair3, pair4",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       `{"product_ids":["pair1","pair2","pair3","pair4"]}`,
+		},
+		{
+			desc:               "unsubscribe",
+			method:             http.MethodPost,
+			path:               "/unsubscribe",
+			productIDs:         "pair1",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       `{"product_ids":["pair2"]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		manager := &fakePairManager{
+			pairs:   []string{"pair1", "pair2"},
+			invalid: []string{"pair5"},
+			err:     tc.managerErr,
+		}
+		handler := NewHandler(manager, "")
+
+		form := url.Values{productIDsField: []string{tc.productIDs}}
+		request := httptest.NewRequest(tc.method, tc.path,
+			strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, tc.expectedStatusCode, recorder.Code,
+			"For test %q, got unexpected status code", tc.desc)
+		assert.True(t, json.Valid(recorder.Body.Bytes()),
+			"For test %q, got invalid JSON body", tc.desc)
+		assert.Equal(t, tc.expectedBody,
+			strings.TrimSpace(recorder.Body.String()),
+			"For test %q, got unexpected body", tc.desc)
+	}
+}
+
+func Test_NewHandlerToken(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		authorization      string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			desc:               "no token",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":"unauthorized"}`,
+		},
+		{
+			desc:               "wrong token",
+			authorization:      "Bearer other",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":"unauthorized"}`,
+		},
+		{
+			desc:               "valid token",
+			authorization:      "Bearer secret",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       `{"product_ids":["pair1"]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		manager := &fakePairManager{pairs: []string{"pair1"}}
+		handler := NewHandler(manager, "secret")
+
+		request := httptest.NewRequest(http.MethodGet, "/pairs", nil)
+		if tc.authorization != "" {
+			request.Header.Set("Authorization", tc.authorization)
+		}
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, tc.expectedStatusCode, recorder.Code,
+			"For test %q, got unexpected status code", tc.desc)
+		assert.Equal(t, tc.expectedBody,
+			strings.TrimSpace(recorder.Body.String()),
+			"For test %q, got unexpected body", tc.desc)
+	}
+}
+
+func Test_parseProductIDs(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		value          string
+		expectedOutput []string
+	}{
+		{
+			desc:           "empty value",
+			value:          "",
+			expectedOutput: nil,
+		},
+		{
+			desc:           "only separators and spaces",
+			value:          " ,, ",
+			expectedOutput: nil,
+		},
+		{
+			desc:           "multiple pairs",
+			value:          "pair1, pair2,,pair3 ",
+			expectedOutput: []string{"pair1", "pair2", "pair3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		output := parseProductIDs(tc.value)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
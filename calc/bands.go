@@ -0,0 +1,82 @@
+package calc
+
+import "math"
+
+// Multiples of the standard deviation the VWAP bands are logged at, from the
+// lowest band to the highest.
+var bandDeviations = []float64{-2, -1, 1, 2}
+
+// Number of values logged for the bands of each trading pair: the standard
+// deviation, followed by each band.
+var bandValues = 1 + len(bandDeviations)
+
+// varianceSums holds the sums used in the variance calculation, over the
+// prices shifted by a reference price close to them. Summing price^2 * size
+// directly would lose most of its precision at high prices, e.g. around 60000,
+// when the square of the VWAP is subtracted from it.
+type varianceSums struct {
+	// Reference price subtracted from the prices.
+	shift float64
+
+	// Sums of (price - shift) * size and (price - shift)^2 * size.
+	sum, squareSum float64
+}
+
+func (s *varianceSums) add(price, size float64) {
+	deviation := price - s.shift
+	s.sum += deviation * size
+	s.squareSum += deviation * deviation * size
+}
+
+func (s *varianceSums) subtract(price, size float64) {
+	deviation := price - s.shift
+	s.sum -= deviation * size
+	s.squareSum -= deviation * deviation * size
+}
+
+// decay returns the sums multiplied by the given factor.
+func (s varianceSums) decay(factor float64) varianceSums {
+	return varianceSums{
+		shift:     s.shift,
+		sum:       s.sum * factor,
+		squareSum: s.squareSum * factor,
+	}
+}
+
+// rebase changes the reference price of the sums to the given one, with the
+// given sum of sizes.
+func (s *varianceSums) rebase(shift, denominator float64) {
+	delta := s.shift - shift
+	s.squareSum += delta * (2*s.sum + delta*denominator)
+	s.sum += delta * denominator
+	s.shift = shift
+}
+
+// getVariance returns the volume-weighted variance of the prices around their
+// mean, given the sum of sizes, or 0 if there is no volume. Rounding errors
+// may make it slightly negative, in which case 0 is returned too.
+func (s *varianceSums) getVariance(denominator float64) float64 {
+	if denominator <= 0 {
+		return 0
+	}
+
+	mean := s.sum / denominator
+	return math.Max(s.squareSum/denominator-mean*mean, 0)
+}
+
+// getBandsLogFormat returns the format string to use when printing the bands
+// of a trading pair.
+func getBandsLogFormat() string {
+	return ", std_dev: %f, bands: [%f, %f, %f, %f]"
+}
+
+// setBandValues sets the logged standard deviation and bands of the given
+// reported pair in the given slice.
+func (e *Engine) setBandValues(
+	pair string, stats pairStats, values []interface{},
+) {
+	values[0] = stats.stdDev
+	for i, deviation := range bandDeviations {
+		values[i+1] = e.getLoggedPrice(pair, stats.vwap+deviation*stats.stdDev)
+	}
+}
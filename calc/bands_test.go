@@ -0,0 +1,192 @@
+// +build unit
+
+package calc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_varianceSums(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		sums           varianceSums
+		denominator    float64
+		rebaseShift    float64
+		expectedOutput float64
+	}{
+		{
+			desc:           "no volume",
+			expectedOutput: 0,
+		},
+		{
+			desc:           "positive variance",
+			sums:           varianceSums{sum: 30, squareSum: 500},
+			denominator:    2,
+			expectedOutput: 25,
+		},
+		{
+			desc:           "positive variance after rebase",
+			sums:           varianceSums{sum: 30, squareSum: 500},
+			denominator:    2,
+			rebaseShift:    60000,
+			expectedOutput: 25,
+		},
+		{
+			desc: "negative rounding error",
+			sums: varianceSums{shift: 10, sum: 10,
+				squareSum: 49.99999},
+			denominator:    2,
+			expectedOutput: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.rebaseShift != 0 {
+			tc.sums.rebase(tc.rebaseShift, tc.denominator)
+		}
+
+		output := tc.sums.getVariance(tc.denominator)
+		assert.InDelta(t, tc.expectedOutput, output, 1e-6,
+			"For test %q, got wrong variance", tc.desc)
+	}
+}
+
+func Test_windowGetVariance(t *testing.T) {
+	matches := []feed.Match{
+		{Price: 10, Size: 2},
+		{Price: 20, Size: 1},
+		{Price: 30, Size: 2},
+	}
+
+	testCases := []struct {
+		desc             string
+		window           window
+		matches          []feed.Match
+		expectedVariance float64
+	}{
+		{
+			desc:             "sliding window",
+			window:           newSlidingWindow(10),
+			matches:          matches[:2],
+			expectedVariance: 200.0 / 9,
+		},
+		{
+			desc:             "sliding window with evicted match",
+			window:           newSlidingWindow(2),
+			matches:          matches,
+			expectedVariance: 200.0 / 9,
+		},
+		{
+			desc:             "EWMA window",
+			window:           newEWMAWindow(0, 1),
+			matches:          matches[:2],
+			expectedVariance: 25,
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, 0.0, tc.window.getVariance(),
+			"For test %q, got variance with no matches", tc.desc)
+
+		for _, match := range tc.matches {
+			assert.Nil(t, tc.window.addMatch(match),
+				"For test %q, got error adding match", tc.desc)
+		}
+
+		assert.InDelta(t, tc.expectedVariance, tc.window.getVariance(), 1e-9,
+			"For test %q, got wrong variance", tc.desc)
+	}
+}
+
+func Test_EngineVWAPBandsLongRun(t *testing.T) {
+	const windowSize = 50
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD"},
+		WindowSize:   windowSize,
+		VWAPBands:    true,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	// Prices around 60000 with a spread of a few cents, so that the variance
+	// is far smaller than the rounding errors of sums of price^2 * size.
+	random := rand.New(rand.NewSource(1))
+	var matches []feed.Match
+	for i := 0; i < 100000; i++ {
+		match := feed.Match{
+			Price:     60000 + float64(i/1000) + random.Float64()*0.05,
+			ProductID: "BTC-USD",
+			Side:      feed.SellSide,
+			Size:      0.001 + random.Float64()*10,
+		}
+		engine.handleMatch(match)
+		matches = append(matches, match)
+
+		if (i+1)%9973 != 0 {
+			continue
+		}
+
+		var numerator, denominator float64
+		for _, m := range matches[len(matches)-windowSize:] {
+			numerator += m.Price * m.Size
+			denominator += m.Size
+		}
+		expectedVWAP := numerator / denominator
+
+		var squareSum float64
+		for _, m := range matches[len(matches)-windowSize:] {
+			squareSum += (m.Price - expectedVWAP) *
+				(m.Price - expectedVWAP) * m.Size
+		}
+		expectedStdDev := math.Sqrt(squareSum / denominator)
+
+		stats := engine.getPairStats("BTC-USD",
+			engine.getPairSummary("BTC-USD"))
+		values := make([]interface{}, bandValues)
+		engine.setBandValues("BTC-USD", stats, values)
+		assert.InEpsilon(t, expectedStdDev, values[0], 1e-6,
+			"Got wrong standard deviation after %d matches", i+1)
+		for j, deviation := range bandDeviations {
+			assert.InDelta(t, expectedVWAP+deviation*expectedStdDev,
+				values[j+1], 1e-6,
+				"Got wrong band %d after %d matches", j, i+1)
+		}
+	}
+}
+
+func Test_EngineVWAPBands(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD"},
+		WindowSize:   10,
+		VWAPBands:    true,
+		Series: []Series{
+			{Name: "width", Expression: "4 * BTC-USD.std_dev"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide,
+			Size: 1, Time: start},
+		{Price: 40400, ProductID: "BTC-USD", Side: feed.SellSide,
+			Size: 1, Time: start.Add(5 * time.Second)},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	expectedOutput := "\"BTC-USD\": 40200.000000 (buy: 40200.000000, " +
+		"sell: 0.000000, imbalance: 1.000000, last_match: 0.000000, " +
+		"std_dev: 200.000000, bands: [39800.000000, 40000.000000, " +
+		"40400.000000, 40600.000000]), \"width\": 800.000000"
+	assert.Equal(t, expectedOutput, engine.getVWAPLog(),
+		"Got unexpected VWAP log")
+	assert.Equal(t, 200.0, engine.Snapshot().Pairs["BTC-USD"].StdDev,
+		"Got wrong standard deviation in snapshot")
+}
@@ -0,0 +1,332 @@
+package calc
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// Maximum number of empty time bars emitted at once, when the exchange time
+// jumps forward. Beyond it, the gap is skipped, e.g. after a long outage or a
+// wrong timestamp.
+const maxEmptyBars int = 1000
+
+// BarKind defines when an OHLCV bar closes.
+type BarKind string
+
+// Supported bar kinds.
+const (
+	// BarTime bars close on time boundaries, every interval.
+	BarTime BarKind = "time"
+
+	// BarVolume bars close once their volume reaches a threshold.
+	BarVolume BarKind = "volume"
+
+	// BarDollar bars close once their notional, SUM(price * size), reaches a
+	// threshold.
+	BarDollar BarKind = "dollar"
+)
+
+// BarSpec defines a series of OHLCV bars to build for every reported pair.
+type BarSpec struct {
+	Kind BarKind
+
+	// Duration of BarTime bars, e.g. time.Minute.
+	Interval time.Duration
+
+	// Volume or notional at which BarVolume and BarDollar bars close.
+	Threshold float64
+}
+
+// String returns the spec as given to the --bars flag, e.g. "1m0s" or
+// "volume=10".
+func (s BarSpec) String() string {
+	if s.Kind == BarTime {
+		return s.Interval.String()
+	}
+	return fmt.Sprintf("%s=%v", s.Kind, s.Threshold)
+}
+
+// validate checks that the spec has the parameter its kind requires.
+func (s BarSpec) validate() error {
+	switch s.Kind {
+	case BarTime:
+		if s.Interval <= 0 {
+			return fmt.Errorf("invalid interval %v for time bars, must be "+
+				"positive", s.Interval)
+		}
+
+	case BarVolume, BarDollar:
+		if s.Threshold <= 0 {
+			return fmt.Errorf("invalid threshold %v for %s bars, must be "+
+				"positive", s.Threshold, s.Kind)
+		}
+
+	default:
+		return fmt.Errorf("invalid bar kind %q", s.Kind)
+	}
+	return nil
+}
+
+// validateBarSpecs checks the given bar specs, which must be unique.
+func validateBarSpecs(specs []BarSpec) error {
+	isSpec := make(map[BarSpec]bool, len(specs))
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return err
+		}
+
+		if isSpec[spec] {
+			return fmt.Errorf("duplicate bars %q", spec)
+		}
+		isSpec[spec] = true
+	}
+	return nil
+}
+
+// Bar is an OHLCV bar of a reported pair, built from the matches of the
+// included venues.
+type Bar struct {
+	ProductID string
+	Spec      BarSpec
+
+	// Exchange time span of the bar. Time bars span [Start, End), aligned to
+	// their interval. Volume and dollar bars span from their first match to
+	// the match that closed them.
+	Start, End time.Time
+
+	// Prices of the bar. An empty time bar holds the close of the previous
+	// bar in all of them.
+	Open, High, Low, Close float64
+
+	// Volume of the bar, and its VWAP, which is 0 if there is no volume.
+	Volume float64
+	VWAP   float64
+
+	// Number of matches in the bar.
+	Trades int
+}
+
+// String returns the bar in the format it is logged with.
+func (b Bar) String() string {
+	return fmt.Sprintf("Bar %q %s (start: %s, end: %s): open: %f, high: %f, "+
+		"low: %f, close: %f, volume: %f, vwap: %f, trades: %d", b.ProductID,
+		b.Spec, b.Start.Format(time.RFC3339Nano),
+		b.End.Format(time.RFC3339Nano), b.Open, b.High, b.Low, b.Close,
+		b.Volume, b.VWAP, b.Trades)
+}
+
+// barBuilder builds the bars of a reported pair for a single spec, one bar at
+// a time.
+type barBuilder struct {
+	spec BarSpec
+
+	// Bar currently open, if any, and the sums of its VWAP.
+	bar    Bar
+	sums   vwapSums
+	isOpen bool
+}
+
+func newBarBuilder(pair string, spec BarSpec) *barBuilder {
+	return &barBuilder{spec: spec, bar: Bar{ProductID: pair, Spec: spec}}
+}
+
+// open opens a new empty bar at the given time, with all its prices set to
+// the given one.
+func (b *barBuilder) open(at time.Time, price float64) {
+	b.bar = Bar{
+		ProductID: b.bar.ProductID,
+		Spec:      b.spec,
+		Start:     at,
+		End:       at,
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+	}
+	if b.spec.Kind == BarTime {
+		b.bar.End = at.Add(b.spec.Interval)
+	}
+	b.sums = vwapSums{}
+	b.isOpen = true
+}
+
+// addMatch adds the given match to the open bar, and returns the bars closed
+// before or by it. Time bars are aligned to their interval, and matches older
+// than the open bar are added to it, since the bars before it were already
+// closed.
+func (b *barBuilder) addMatch(match feed.Match) []Bar {
+	t := getMatchTime(match)
+
+	var closed []Bar
+	if !b.isOpen {
+		if b.spec.Kind == BarTime {
+			b.open(t.Truncate(b.spec.Interval), 0)
+		} else {
+			b.open(t, 0)
+		}
+	} else {
+		closed = b.advance(t)
+	}
+
+	if b.bar.Trades == 0 {
+		b.bar.Open = match.Price
+		b.bar.High = match.Price
+		b.bar.Low = match.Price
+	}
+	b.bar.High = math.Max(b.bar.High, match.Price)
+	b.bar.Low = math.Min(b.bar.Low, match.Price)
+	b.bar.Close = match.Price
+	b.bar.Trades++
+
+	b.sums.add(getVWAPPartialDataFromMatch(match))
+	b.bar.Volume = b.sums.denominator
+	b.bar.VWAP = b.sums.getVWAP()
+
+	if b.spec.Kind == BarTime {
+		return closed
+	}
+
+	if t.After(b.bar.End) {
+		b.bar.End = t
+	}
+
+	progress := b.sums.denominator
+	if b.spec.Kind == BarDollar {
+		progress = b.sums.numerator
+	}
+	if progress >= b.spec.Threshold {
+		closed = append(closed, b.bar)
+		b.isOpen = false
+	}
+	return closed
+}
+
+// advance closes the open time bar if the given exchange time is past its
+// end, and returns it along with the empty bars up to the given time. Other
+// bars do not close with time.
+func (b *barBuilder) advance(t time.Time) []Bar {
+	if b.spec.Kind != BarTime || !b.isOpen || t.Before(b.bar.End) {
+		return nil
+	}
+
+	closed := []Bar{b.bar}
+	gap := int(t.Sub(b.bar.End) / b.spec.Interval)
+	if gap > maxEmptyBars {
+		log.Printf("Skipped %d empty %s bars for %q", gap, b.spec,
+			b.bar.ProductID)
+		b.open(t.Truncate(b.spec.Interval), b.bar.Close)
+		return closed
+	}
+
+	b.open(b.bar.End, b.bar.Close)
+	for !t.Before(b.bar.End) {
+		closed = append(closed, b.bar)
+		b.open(b.bar.End, b.bar.Close)
+	}
+	return closed
+}
+
+// getMatchTime returns the exchange timestamp of the given match, or the
+// local time it was received at if it has none.
+func getMatchTime(match feed.Match) time.Time {
+	if match.Time.IsZero() {
+		return match.ReceivedAt
+	}
+	return match.Time
+}
+
+// getBarBuilders returns the bar builders of the given reported pair, one for
+// each of the engine's bar specs. If none are found, they are created and
+// stored in the engine.
+func (e *Engine) getBarBuilders(pair string) []*barBuilder {
+	builders, hasBuilders := e.bars[pair]
+	if !hasBuilders {
+		for _, spec := range e.barSpecs {
+			builders = append(builders, newBarBuilder(pair, spec))
+		}
+		e.bars[pair] = builders
+	}
+	return builders
+}
+
+// addBarMatch adds the given match, already mapped to its reported pair, to
+// the bars of the pair, and returns the bars it closed. Matches from excluded
+// venues and past trades reported by last_match messages are not part of any
+// bar.
+func (e *Engine) addBarMatch(match feed.Match) []Bar {
+	if match.IsLast || e.isExcludedVenue(match.Venue) {
+		return nil
+	}
+
+	var closed []Bar
+	for _, builder := range e.getBarBuilders(match.ProductID) {
+		closed = append(closed, builder.addMatch(match)...)
+	}
+	return closed
+}
+
+// advanceBars closes the time bars of the given reported pair that ended
+// before the given exchange time, and returns them.
+func (e *Engine) advanceBars(pair string, t time.Time) []Bar {
+	var closed []Bar
+	for _, builder := range e.bars[pair] {
+		closed = append(closed, builder.advance(t)...)
+	}
+	return closed
+}
+
+// raiseBars logs the given closed bars and passes them to the engine's bar
+// callback, if any.
+func (e *Engine) raiseBars(bars []Bar) {
+	for _, bar := range bars {
+		log.Print(bar)
+
+		if e.onBar != nil {
+			e.onBar(bar)
+		}
+	}
+}
+
+// isExcludedVenue indicates if the venue with the given name is left out of
+// the consolidated calculations.
+func (e *Engine) isExcludedVenue(name string) bool {
+	for _, venue := range e.venues {
+		if venue.name == name {
+			return venue.excluded
+		}
+	}
+	return false
+}
+
+// ParseBarSpec parses a bar spec given either as a duration for time bars,
+// e.g. "1m", or as "volume=threshold" or "dollar=threshold".
+func ParseBarSpec(value string) (BarSpec, error) {
+	var spec BarSpec
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return BarSpec{}, fmt.Errorf("invalid threshold in bar spec %q",
+				value)
+		}
+		spec = BarSpec{Kind: BarKind(parts[0]), Threshold: threshold}
+	} else {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return BarSpec{}, fmt.Errorf("invalid bar spec %q, expected a "+
+				"duration, \"volume=threshold\" or \"dollar=threshold\"",
+				value)
+		}
+		spec = BarSpec{Kind: BarTime, Interval: interval}
+	}
+
+	if err := spec.validate(); err != nil {
+		return BarSpec{}, err
+	}
+	return spec, nil
+}
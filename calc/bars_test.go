@@ -0,0 +1,300 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseBarSpec(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		value          string
+		expectedOutput BarSpec
+		expectedError  error
+	}{
+		{
+			desc:           "time bars",
+			value:          "5m",
+			expectedOutput: BarSpec{Kind: BarTime, Interval: 5 * time.Minute},
+		},
+		{
+			desc:           "volume bars",
+			value:          "volume=10",
+			expectedOutput: BarSpec{Kind: BarVolume, Threshold: 10},
+		},
+		{
+			desc:           "dollar bars",
+			value:          "dollar=1e6",
+			expectedOutput: BarSpec{Kind: BarDollar, Threshold: 1e6},
+		},
+		{
+			desc:  "invalid duration",
+			value: "1x",
+			expectedError: errors.New("invalid bar spec \"1x\", expected a " +
+				"duration, \"volume=threshold\" or \"dollar=threshold\""),
+		},
+		{
+			desc:  "invalid threshold",
+			value: "volume=ten",
+			expectedError: errors.New(
+				"invalid threshold in bar spec \"volume=ten\""),
+		},
+		{
+			desc:          "unknown kind",
+			value:         "tick=100",
+			expectedError: errors.New("invalid bar kind \"tick\""),
+		},
+		{
+			desc:  "zero interval",
+			value: "0s",
+			expectedError: errors.New("invalid interval 0s for time bars, " +
+				"must be positive"),
+		},
+		{
+			desc:  "negative threshold",
+			value: "dollar=-1",
+			expectedError: errors.New("invalid threshold -1 for dollar " +
+				"bars, must be positive"),
+		},
+	}
+
+	for _, tc := range testCases {
+		output, err := ParseBarSpec(tc.value)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong spec", tc.desc)
+	}
+}
+
+func Test_barBuilderAddMatch(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	minute := BarSpec{Kind: BarTime, Interval: time.Minute}
+	volume := BarSpec{Kind: BarVolume, Threshold: 3}
+	dollar := BarSpec{Kind: BarDollar, Threshold: 38}
+
+	matches := []feed.Match{
+		{Price: 10, Size: 1, Time: start.Add(10 * time.Second)},
+		{Price: 12, Size: 1, Time: start.Add(20 * time.Second)},
+		{Price: 8, Size: 2, Time: start.Add(30 * time.Second)},
+		{Price: 20, Size: 1, Time: start.Add(150 * time.Second)},
+	}
+
+	testCases := []struct {
+		desc           string
+		spec           BarSpec
+		expectedOutput []Bar
+	}{
+		{
+			desc: "time bars",
+			spec: minute,
+			expectedOutput: []Bar{
+				{ProductID: "BTC-USD", Spec: minute, Start: start,
+					End: start.Add(time.Minute), Open: 10, High: 12,
+					Low: 8, Close: 8, Volume: 4, VWAP: 9.5, Trades: 3},
+				{ProductID: "BTC-USD", Spec: minute,
+					Start: start.Add(time.Minute),
+					End:   start.Add(2 * time.Minute), Open: 8, High: 8,
+					Low: 8, Close: 8},
+			},
+		},
+		{
+			desc: "volume bars",
+			spec: volume,
+			expectedOutput: []Bar{
+				{ProductID: "BTC-USD", Spec: volume,
+					Start: start.Add(10 * time.Second),
+					End:   start.Add(30 * time.Second), Open: 10, High: 12,
+					Low: 8, Close: 8, Volume: 4, VWAP: 9.5, Trades: 3},
+			},
+		},
+		{
+			desc: "dollar bars",
+			spec: dollar,
+			expectedOutput: []Bar{
+				{ProductID: "BTC-USD", Spec: dollar,
+					Start: start.Add(10 * time.Second),
+					End:   start.Add(30 * time.Second), Open: 10, High: 12,
+					Low: 8, Close: 8, Volume: 4, VWAP: 9.5, Trades: 3},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		builder := newBarBuilder("BTC-USD", tc.spec)
+
+		var output []Bar
+		for _, match := range matches {
+			output = append(output, builder.addMatch(match)...)
+		}
+
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong bars", tc.desc)
+	}
+}
+
+func Test_barBuilderAdvance(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	spec := BarSpec{Kind: BarTime, Interval: time.Second}
+	builder := newBarBuilder("BTC-USD", spec)
+
+	assert.Nil(t, builder.advance(start.Add(time.Hour)),
+		"Got bars before the first match")
+
+	builder.addMatch(feed.Match{Price: 10, Size: 1, Time: start})
+
+	// Matches older than the open bar are added to it.
+	builder.addMatch(feed.Match{Price: 12, Size: 1,
+		Time: start.Add(-time.Hour)})
+	assert.Nil(t, builder.advance(start.Add(time.Second/2)),
+		"Got bars before the end of the open bar")
+	assert.Equal(t, 2, builder.bar.Trades, "Got wrong number of trades")
+
+	assert.Equal(t, 3, len(builder.advance(start.Add(3*time.Second))),
+		"Got wrong number of bars up to a heartbeat")
+	assert.Equal(t, start.Add(3*time.Second), builder.bar.Start,
+		"Got wrong start of the open bar")
+
+	// Long gaps are skipped, keeping the close.
+	skipped := builder.advance(start.Add(time.Hour))
+	assert.Equal(t, 1, len(skipped), "Got empty bars over a long gap")
+	assert.Equal(t, start.Add(time.Hour), builder.bar.Start,
+		"Got wrong start of the open bar after a long gap")
+	assert.Equal(t, 12.0, builder.bar.Close, "Got wrong close after a gap")
+}
+
+func Test_EngineBars(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	spec := BarSpec{Kind: BarTime, Interval: time.Second}
+
+	testCases := []struct {
+		desc          string
+		bars          []BarSpec
+		expectedError error
+	}{
+		{
+			desc: "invalid bars",
+			bars: []BarSpec{{Kind: BarVolume}},
+			expectedError: errors.New("invalid threshold 0 for volume " +
+				"bars, must be positive"),
+		},
+		{
+			desc:          "duplicate bars",
+			bars:          []BarSpec{spec, spec},
+			expectedError: errors.New("duplicate bars \"1s\""),
+		},
+		{
+			desc: "valid bars",
+			bars: []BarSpec{spec},
+		},
+	}
+
+	for _, tc := range testCases {
+		var output []Bar
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs: []string{"BTC-USD", "BTC-USDT"},
+			WindowSize:   10,
+			SymbolMapping: SymbolMapping{
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+			Bars:  tc.bars,
+			OnBar: func(bar Bar) { output = append(output, bar) },
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		matches := []feed.Match{
+			{Price: 39000, ProductID: "BTC-USD", Size: 1, IsLast: true,
+				Time: start.Add(-time.Hour)},
+			{Price: 40000, ProductID: "BTC-USD", Size: 1, Time: start},
+			{Price: 40200, ProductID: "BTC-USDT", Size: 3,
+				Time: start.Add(time.Second / 2)},
+		}
+		for _, match := range matches {
+			engine.handleMatch(match)
+		}
+		assert.Nil(t, output, "For test %q, got bars before the end of the "+
+			"first one", tc.desc)
+
+		engine.handleUpdate(feed.Update{Heartbeats: []feed.Heartbeat{
+			{ProductID: "BTC-USDT", Time: start.Add(2 * time.Second)},
+		}})
+
+		assert.Equal(t, []Bar{
+			{ProductID: "BTC-USD", Spec: spec, Start: start,
+				End: start.Add(time.Second), Open: 40000, High: 40200,
+				Low: 40000, Close: 40200, Volume: 4, VWAP: 40150,
+				Trades: 2},
+			{ProductID: "BTC-USD", Spec: spec, Start: start.Add(time.Second),
+				End: start.Add(2 * time.Second), Open: 40200, High: 40200,
+				Low: 40200, Close: 40200},
+		}, output, "For test %q, got wrong bars", tc.desc)
+	}
+}
+
+func Test_addBarMatchExcludedVenue(t *testing.T) {
+	spec := BarSpec{Kind: BarVolume, Threshold: 1}
+	e := &Engine{
+		venues: []*venueFeed{
+			{name: "venue1"}, {name: "venue2", excluded: true},
+		},
+		barSpecs: []BarSpec{spec},
+		bars:     make(map[string][]*barBuilder),
+	}
+
+	assert.Nil(t, e.addBarMatch(feed.Match{Price: 10, ProductID: "BTC-USD",
+		Size: 1, Venue: "venue2"}), "Got bars from an excluded venue")
+	assert.Equal(t, 1, len(e.addBarMatch(feed.Match{Price: 10,
+		ProductID: "BTC-USD", Size: 1, Venue: "venue1"})),
+		"Got wrong number of bars from an included venue")
+}
+
+func Test_handleUpdatesBars(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	spec := BarSpec{Kind: BarTime, Interval: time.Second}
+
+	var output []Bar
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD"},
+		WindowSize:   10,
+		Bars:         []BarSpec{spec},
+		OnBar:        func(bar Bar) { output = append(output, bar) },
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	// The heartbeat is queued behind matches of the bar it closes, which
+	// must be added to it before it closes.
+	updateCh := make(chan feed.Update, bufferedChannelSize)
+	updateCh <- feed.Update{Matches: []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Size: 1, Time: start},
+	}}
+	updateCh <- feed.Update{Matches: []feed.Match{
+		{Price: 40200, ProductID: "BTC-USD", Size: 1,
+			Time: start.Add(time.Second / 2)},
+	}}
+	updateCh <- feed.Update{Heartbeats: []feed.Heartbeat{
+		{ProductID: "BTC-USD", Time: start.Add(time.Second)},
+	}}
+	updateCh <- feed.Update{Matches: []feed.Match{
+		{Price: 40400, ProductID: "BTC-USD", Size: 1,
+			Time: start.Add(3 * time.Second / 2)},
+	}}
+	close(updateCh)
+	engine.handleUpdates(updateCh, make(chan struct{}))
+
+	assert.Equal(t, []Bar{
+		{ProductID: "BTC-USD", Spec: spec, Start: start,
+			End: start.Add(time.Second), Open: 40000, High: 40200,
+			Low: 40000, Close: 40200, Volume: 2, VWAP: 40100, Trades: 2},
+	}, output, "Got wrong bars")
+}
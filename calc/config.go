@@ -0,0 +1,130 @@
+package calc
+
+import "time"
+
+// LastMatchPolicy defines how the engine handles last_match messages, which
+// report the most recent trade that happened before the subscription.
+type LastMatchPolicy string
+
+// Supported last_match policies.
+const (
+	// LastMatchInclude adds last_match data to the window like any other
+	// match.
+	LastMatchInclude LastMatchPolicy = "include"
+
+	// LastMatchExclude never adds last_match data to the window.
+	LastMatchExclude LastMatchPolicy = "exclude"
+
+	// LastMatchSeed adds last_match data to the window only until the first
+	// live match for the same product arrives, at which point it is dropped.
+	LastMatchSeed LastMatchPolicy = "seed"
+)
+
+// isValid indicates if the policy is one of the supported ones.
+func (p LastMatchPolicy) isValid() bool {
+	switch p {
+	case LastMatchInclude, LastMatchExclude, LastMatchSeed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Config holds the parameters for a VWAP calculation engine.
+type Config struct {
+	// Trading pairs to calculate VWAP for.
+	TradingPairs []string
+
+	// Trading pairs that cannot be removed at runtime, e.g. the constituents
+	// of the indexes computed from the engine snapshots.
+	PinnedPairs []string
+
+	// Size of the sliding window to use for the algorithm. Only used with
+	// WindowSliding windows.
+	WindowSize int
+
+	// Type of the windows to use for the algorithm. Defaults to
+	// WindowSliding if empty.
+	WindowType WindowType
+
+	// Half-life of the weights of matches in WindowEWMA windows, either in
+	// time, measured with the exchange timestamps of matches, or in number of
+	// matches. Exactly one of them must be set for WindowEWMA windows.
+	HalfLife       time.Duration
+	HalfLifeTrades float64
+
+	// Policy for handling last_match messages. Defaults to LastMatchInclude
+	// if empty.
+	LastMatchPolicy LastMatchPolicy
+
+	// Mapping applied to the product IDs of matches before they are added to
+	// the windows. Products are reported as they are named by the venues if
+	// the mapping is empty.
+	SymbolMapping SymbolMapping
+
+	// Cross rates to imply from the VWAPs of the trading pairs, logged along
+	// with their deviation from the directly traded pairs.
+	CrossRates []CrossRate
+
+	// Derived series to evaluate over the statistics of the reported pairs,
+	// logged along with them.
+	Series []Series
+
+	// Time horizons to also calculate the VWAP of every reported pair over,
+	// from the exchange timestamps of the matches of the included venues,
+	// e.g. 5 minutes. The VWAP over each horizon is available to derived
+	// series as a statistic named after it, e.g. "vwap5m", and in the engine
+	// snapshots. Must be whole seconds.
+	VWAPHorizons []time.Duration
+
+	// Reported pairs to log the TWAP of, over the same windows as the VWAP.
+	TWAPPairs []string
+
+	// Indicates if the volume-weighted standard deviation of the prices of
+	// each reported pair around its VWAP is logged with it, along with the
+	// bands at 1 and 2 standard deviations below and above the VWAP.
+	VWAPBands bool
+
+	// Indicates if the highest and lowest prices in the windows of each
+	// reported pair, and their volume-weighted median, are logged with its
+	// VWAP. Requires WindowSliding windows.
+	PriceStats bool
+
+	// Width of the price buckets of the volume profile of each reported pair
+	// to keep one for, e.g. {"BTC-USD": 10}. Volume profiles are available in
+	// the engine snapshots. Requires WindowSliding windows.
+	VolumeProfiles map[string]float64
+
+	// OHLCV bars to build for every reported pair, from the matches of the
+	// included venues. Each closed bar is logged.
+	Bars []BarSpec
+
+	// Function called for every closed bar, in addition to logging it. It is
+	// called with the engine unlocked.
+	OnBar func(Bar)
+
+	// Currency to express the VWAP of every trading pair in, e.g. "USD". The
+	// pairs needed to convert from other quote currencies are added to the
+	// trading pairs. No conversion is made if empty.
+	ReportingCurrency string
+
+	// Function used to round the logged prices for each product, e.g. to the
+	// product's quote increment. Prices are not rounded if nil.
+	RoundPrice func(productID string, price float64) float64
+
+	// Maximum time without heartbeats for a trading pair, after which it is
+	// considered stale. Disabled if zero.
+	HeartbeatTimeout time.Duration
+
+	// Maximum time without matches for a trading pair, after which it is
+	// considered silent. Disabled if zero.
+	TradeTimeout time.Duration
+
+	// Function called for every staleness event, in addition to logging it.
+	OnStale func(StalenessEvent)
+
+	// Indicates if the engine should force a reconnect to the feeds when a
+	// trading pair goes stale. Requires every venue to have a reconnect
+	// function.
+	ReconnectOnStale bool
+}
@@ -0,0 +1,176 @@
+package calc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Number of values logged for each converted pair: the VWAP in the reporting
+// currency, the conversion rate used, and the age of the rate.
+const conversionValues int = 3
+
+// conversion expresses the VWAP of a reported pair in the reporting currency,
+// using the VWAP of a conversion pair.
+type conversion struct {
+	pair, conversionPair string
+
+	// Indicates if the conversion pair is quoted in the quote currency of the
+	// pair, so that prices are divided by its VWAP instead.
+	inverted bool
+}
+
+// getConversionPair returns the reported pair whose VWAP converts prices
+// quoted in the quote currency of the given reported pair to the reporting
+// currency, which is the QUOTE-REPORTING pair. If only the inverted
+// REPORTING-QUOTE pair is among the given reported pairs, e.g. EUR-USD for USD
+// quoted pairs when reporting in EUR, it is returned instead, along with true.
+// An empty string is returned if the pair is already quoted in the reporting
+// currency, or if it is not a BASE-QUOTE product ID.
+func getConversionPair(
+	pair, currency string, reportedPairs []string,
+) (string, bool) {
+	parts := strings.SplitN(pair, "-", 2)
+	if currency == "" || len(parts) != 2 || parts[1] == currency {
+		return "", false
+	}
+
+	directPair := parts[1] + "-" + currency
+	invertedPair := currency + "-" + parts[1]
+	if !containsString(reportedPairs, directPair) &&
+		containsString(reportedPairs, invertedPair) {
+		return invertedPair, true
+	}
+	return directPair, false
+}
+
+// WithConversionPairs returns the given trading pairs, followed by the pairs
+// needed to convert their VWAPs to the given reporting currency which are not
+// reported by them already. Conversion pairs are chosen after the product IDs
+// are renamed by the given symbol mapping, and are subscribed to under a
+// product ID reported as them, preferring the symbols of the trading pairs.
+// If a validation function is given, only product IDs it accepts are
+// subscribed to, trying the inverted pair if the direct one is not listed,
+// and conversions with no listed pair are left out. Otherwise, the direct
+// pair is subscribed to.
+func WithConversionPairs(
+	tradingPairs []string,
+	currency string,
+	symbols SymbolMapping,
+	validate func(tradingPairs []string) error,
+) []string {
+	pairs := append([]string(nil), tradingPairs...)
+	reportedPairs := symbols.getReportedPairs(tradingPairs)
+	for _, pair := range reportedPairs {
+		conversionPair, _ := getConversionPair(pair, currency, reportedPairs)
+		if conversionPair == "" ||
+			containsString(reportedPairs, conversionPair) {
+			continue
+		}
+
+		candidates := symbols.getRawPairs(conversionPair, tradingPairs)
+		if validate != nil {
+			invertedPair := getInvertedPair(conversionPair)
+			candidates = append(candidates,
+				symbols.getRawPairs(invertedPair, tradingPairs)...)
+		}
+
+		rawPair := ""
+		for _, candidate := range candidates {
+			if validate == nil || validate([]string{candidate}) == nil {
+				rawPair = candidate
+				break
+			}
+		}
+
+		if rawPair == "" {
+			log.Printf("Warning: no listed pair to convert %q to %q", pair,
+				currency)
+			continue
+		}
+
+		pairs = append(pairs, rawPair)
+		reportedPairs = append(reportedPairs, symbols.mapProduct(rawPair))
+	}
+	return pairs
+}
+
+// getInvertedPair returns the given BASE-QUOTE product ID as QUOTE-BASE.
+func getInvertedPair(pair string) string {
+	parts := strings.SplitN(pair, "-", 2)
+	if len(parts) != 2 {
+		return pair
+	}
+	return parts[1] + "-" + parts[0]
+}
+
+// getConversions returns the conversions needed to express the VWAPs of the
+// given reported pairs in the reporting currency. Pairs whose conversion pair
+// is not tracked, since no venue lists it, are left out.
+func (e *Engine) getConversions(reportedPairs []string) []conversion {
+	var conversions []conversion
+	for _, pair := range reportedPairs {
+		conversionPair, inverted := getConversionPair(pair,
+			e.reportingCurrency, reportedPairs)
+		if conversionPair == "" ||
+			!containsString(reportedPairs, conversionPair) {
+			continue
+		}
+
+		conversions = append(conversions, conversion{
+			pair:           pair,
+			conversionPair: conversionPair,
+			inverted:       inverted,
+		})
+	}
+	return conversions
+}
+
+// getConversionLogFormat returns the format string to use when printing the
+// given conversions to the given reporting currency. Rates of inverted
+// conversion pairs are labeled as 1/PAIR.
+func getConversionLogFormat(conversions []conversion, currency string) string {
+	formatString := ""
+	for _, conversion := range conversions {
+		rateLabel := conversion.conversionPair
+		if conversion.inverted {
+			rateLabel = "1/" + rateLabel
+		}
+
+		formatString += fmt.Sprintf(", %q: %%f (rate: %q %%f, age: %%s)",
+			conversion.pair+" in "+currency, rateLabel)
+	}
+	return formatString
+}
+
+// setConversionValues sets the logged values of each conversion in the given
+// slice, using the VWAPs of the reported pairs in pairStats. The age of a rate
+// is the time since the last match added to the conversion pair windows. The
+// rate of an inverted conversion pair is the inverse of its VWAP, and is zero
+// until the pair has a VWAP. Since it is not a price of the pair, it is not
+// rounded, and neither are the prices converted with it.
+func (e *Engine) setConversionValues(values []interface{}) {
+	for i, conversion := range e.conversions {
+		rate := e.pairStats[conversion.conversionPair].vwap
+		if conversion.inverted && rate != 0 {
+			rate = 1 / rate
+		}
+
+		age := "unknown"
+		if updatedAt, ok := e.lastUpdates[conversion.conversionPair]; ok {
+			age = now().Sub(updatedAt).Round(time.Millisecond).String()
+		}
+
+		price := e.pairStats[conversion.pair].vwap * rate
+		if !conversion.inverted {
+			price = e.getLoggedPrice(conversion.conversionPair, price)
+			rate = e.getLoggedPrice(conversion.conversionPair, rate)
+		}
+
+		pairValues := values[i*conversionValues : (i+1)*conversionValues]
+		pairValues[0] = price
+		pairValues[1] = rate
+		pairValues[2] = age
+	}
+}
@@ -0,0 +1,254 @@
+// +build unit
+
+package calc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getConversionPair(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		pair             string
+		currency         string
+		reportedPairs    []string
+		expectedOutput   string
+		expectedInverted bool
+	}{
+		{
+			desc:           "no reporting currency",
+			pair:           "ETH-BTC",
+			currency:       "",
+			expectedOutput: "",
+		},
+		{
+			desc:           "already in reporting currency",
+			pair:           "BTC-USD",
+			currency:       "USD",
+			expectedOutput: "",
+		},
+		{
+			desc:           "not a pair",
+			pair:           "BTC",
+			currency:       "USD",
+			expectedOutput: "",
+		},
+		{
+			desc:           "other quote currency",
+			pair:           "ETH-BTC",
+			currency:       "USD",
+			expectedOutput: "BTC-USD",
+		},
+		{
+			desc:             "inverted pair tracked",
+			pair:             "ETH-USD",
+			currency:         "BTC",
+			reportedPairs:    []string{"ETH-USD", "BTC-USD"},
+			expectedOutput:   "BTC-USD",
+			expectedInverted: true,
+		},
+		{
+			desc:           "both pairs tracked",
+			pair:           "ETH-USD",
+			currency:       "EUR",
+			reportedPairs:  []string{"ETH-USD", "EUR-USD", "USD-EUR"},
+			expectedOutput: "USD-EUR",
+		},
+	}
+
+	for _, tc := range testCases {
+		output, inverted := getConversionPair(tc.pair, tc.currency,
+			tc.reportedPairs)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+		assert.Equal(t, tc.expectedInverted, inverted,
+			"For test %q, got wrong inverted value", tc.desc)
+	}
+}
+
+func Test_WithConversionPairs(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		tradingPairs   []string
+		currency       string
+		symbols        SymbolMapping
+		listed         []string
+		expectedOutput []string
+	}{
+		{
+			desc:           "no reporting currency",
+			tradingPairs:   []string{"ETH-BTC"},
+			expectedOutput: []string{"ETH-BTC"},
+		},
+		{
+			desc:           "conversion pairs already tracked",
+			tradingPairs:   []string{"BTC-USD", "ETH-BTC"},
+			currency:       "USD",
+			expectedOutput: []string{"BTC-USD", "ETH-BTC"},
+		},
+		{
+			desc:           "missing conversion pairs",
+			tradingPairs:   []string{"ETH-BTC", "SOL-ETH", "LTC-BTC"},
+			currency:       "USD",
+			expectedOutput: []string{"ETH-BTC", "SOL-ETH", "LTC-BTC", "BTC-USD", "ETH-USD"},
+		},
+		{
+			desc:         "mapped quote currencies",
+			tradingPairs: []string{"ETH-XBT", "BTC-USDT"},
+			currency:     "USD",
+			symbols: SymbolMapping{
+				Assets:           map[string]string{"XBT": "BTC"},
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+			expectedOutput: []string{"ETH-XBT", "BTC-USDT"},
+		},
+		{
+			desc:         "conversion pair under venue symbols",
+			tradingPairs: []string{"ETH-XBT"},
+			currency:     "USD",
+			symbols: SymbolMapping{
+				Assets: map[string]string{"XBT": "BTC"},
+			},
+			expectedOutput: []string{"ETH-XBT", "XBT-USD"},
+		},
+		{
+			desc:         "conversion pair listed under equivalent quote",
+			tradingPairs: []string{"ETH-XBT"},
+			currency:     "USD",
+			symbols: SymbolMapping{
+				Assets:           map[string]string{"XBT": "BTC"},
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+			listed:         []string{"ETH-XBT", "BTC-USDT"},
+			expectedOutput: []string{"ETH-XBT", "BTC-USDT"},
+		},
+		{
+			desc:           "only inverted conversion pair listed",
+			tradingPairs:   []string{"BTC-USD"},
+			currency:       "EUR",
+			listed:         []string{"BTC-USD", "EUR-USD"},
+			expectedOutput: []string{"BTC-USD", "EUR-USD"},
+		},
+		{
+			desc:           "no conversion pair listed",
+			tradingPairs:   []string{"BTC-USD"},
+			currency:       "EUR",
+			listed:         []string{"BTC-USD"},
+			expectedOutput: []string{"BTC-USD"},
+		},
+		{
+			desc:           "inverted conversion pairs tracked",
+			tradingPairs:   []string{"BTC-USD", "ETH-USD", "EUR-USD"},
+			currency:       "EUR",
+			expectedOutput: []string{"BTC-USD", "ETH-USD", "EUR-USD"},
+		},
+	}
+
+	for _, tc := range testCases {
+		var validate func([]string) error
+		if tc.listed != nil {
+			listed := tc.listed
+			validate = func(pairs []string) error {
+				for _, pair := range pairs {
+					if !containsString(listed, pair) {
+						return fmt.Errorf("unknown product %q", pair)
+					}
+				}
+				return nil
+			}
+		}
+
+		output := WithConversionPairs(tc.tradingPairs, tc.currency, tc.symbols,
+			validate)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_EngineConversion(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:      []string{"ETH-BTC", "SOL-ETH"},
+		WindowSize:        10,
+		ReportingCurrency: "USD",
+	})
+	assert.Nil(t, err, "Got error creating engine")
+	assert.Equal(t, []string{"ETH-BTC", "SOL-ETH", "BTC-USD", "ETH-USD"},
+		engine.TradingPairs(), "Got unexpected trading pairs")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 0.05, ProductID: "ETH-BTC", Side: feed.SellSide, Size: 1},
+		{Price: 0.02, ProductID: "SOL-ETH", Side: feed.SellSide, Size: 1},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	now = func() time.Time { return start.Add(1500 * time.Millisecond) }
+	assert.Equal(t,
+		"\"ETH-BTC\": 0.050000 (buy: 0.050000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"SOL-ETH\": 0.020000 (buy: 0.020000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"BTC-USD\": 40000.000000 (buy: 40000.000000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"ETH-USD\": 0.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000), "+
+			"\"ETH-BTC in USD\": 2000.000000 (rate: \"BTC-USD\" 40000.000000, age: 1.5s), "+
+			"\"SOL-ETH in USD\": 0.000000 (rate: \"ETH-USD\" 0.000000, age: unknown)",
+		engine.getVWAPLog(), "Got unexpected VWAP log")
+}
+
+func Test_EngineInvertedConversion(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:      []string{"BTC-USD", "ETH-BTC", "EUR-USD"},
+		WindowSize:        10,
+		ReportingCurrency: "EUR",
+	})
+	assert.Nil(t, err, "Got error creating engine")
+	assert.Equal(t, []string{"BTC-USD", "ETH-BTC", "EUR-USD", "BTC-EUR"},
+		engine.TradingPairs(), "Got unexpected trading pairs")
+
+	// Pairs added at runtime also use the tracked inverted pair.
+	newPairs, err := engine.getNewTradingPairs([]string{"ETH-USD"})
+	assert.Nil(t, err, "Got error getting new trading pairs")
+	assert.Equal(t, []string{"ETH-USD"}, newPairs,
+		"Got unexpected new trading pairs")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 1.25, ProductID: "EUR-USD", Side: feed.SellSide, Size: 1},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	vwapLog := engine.getVWAPLog()
+	assert.Contains(t, vwapLog,
+		"\"BTC-USD in EUR\": 32000.000000 (rate: \"1/EUR-USD\" 0.800000, ",
+		"Got unexpected inverted conversion")
+	assert.Contains(t, vwapLog,
+		"\"ETH-BTC in EUR\": 0.000000 (rate: \"BTC-EUR\" 0.000000, ",
+		"Got unexpected direct conversion")
+}
+
+func Test_EngineMappedConversion(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:      []string{"ETH-XBT"},
+		WindowSize:        10,
+		ReportingCurrency: "USD",
+		SymbolMapping: SymbolMapping{
+			Assets: map[string]string{"XBT": "BTC"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+	assert.Equal(t, []string{"ETH-XBT", "XBT-USD"}, engine.TradingPairs(),
+		"Got unexpected trading pairs")
+	assert.Equal(t, []conversion{{pair: "ETH-BTC", conversionPair: "BTC-USD"}},
+		engine.conversions, "Got unexpected conversions")
+}
@@ -0,0 +1,115 @@
+package calc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number of values logged for each cross rate: the implied rate, the VWAP of
+// the directly traded pair, and the deviation between them in basis points.
+const crossRateValues int = 3
+
+// CrossRate is a synthetic rate for a trading pair, implied by the VWAPs of its
+// base and quote currencies against a common currency. For instance, ETH-BTC
+// via USD is implied by the ETH-USD and BTC-USD VWAPs.
+type CrossRate struct {
+	// Pair to imply the rate for, e.g. "ETH-BTC".
+	Pair string
+
+	// Currency both legs are quoted in, e.g. "USD".
+	Via string
+}
+
+// crossRate holds a cross rate along with its legs.
+type crossRate struct {
+	CrossRate
+	basePair, quotePair string
+}
+
+// newCrossRate validates the given cross rate, and returns it along with its
+// legs.
+func newCrossRate(rate CrossRate) (crossRate, error) {
+	parts := strings.SplitN(rate.Pair, "-", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return crossRate{}, fmt.Errorf("invalid cross rate pair %q, "+
+			"expected BASE-QUOTE", rate.Pair)
+	}
+
+	base, quote := parts[0], parts[1]
+	if rate.Via == "" || rate.Via == base || rate.Via == quote {
+		return crossRate{}, fmt.Errorf("invalid currency %q for cross rate "+
+			"%q", rate.Via, rate.Pair)
+	}
+
+	return crossRate{
+		CrossRate: rate,
+		basePair:  base + "-" + rate.Via,
+		quotePair: quote + "-" + rate.Via,
+	}, nil
+}
+
+// getImpliedRate returns the rate implied by the given VWAPs of the legs, and
+// its deviation from the given VWAP of the directly traded pair, in basis
+// points. Both are 0 if any of the VWAPs is unknown.
+func getImpliedRate(
+	baseVWAP, quoteVWAP, directVWAP float64,
+) (float64, float64) {
+	if baseVWAP == 0 || quoteVWAP == 0 {
+		return 0, 0
+	}
+
+	implied := baseVWAP / quoteVWAP
+	if directVWAP == 0 {
+		return implied, 0
+	}
+	return implied, (directVWAP/implied - 1) * 10000
+}
+
+// getCrossRateLogFormat returns the format string to use when printing the
+// given cross rates.
+func getCrossRateLogFormat(crossRates []crossRate) string {
+	formatString := ""
+	for _, rate := range crossRates {
+		formatString += fmt.Sprintf(
+			", %q: %%f (direct: %%f, deviation: %%f bps)",
+			rate.Pair+" via "+rate.Via)
+	}
+	return formatString
+}
+
+// CrossRateSnapshot holds the values of a cross rate at the time of a
+// snapshot.
+type CrossRateSnapshot struct {
+	CrossRate
+	Implied   float64 // Rate implied by the VWAPs of the legs.
+	Direct    float64 // VWAP of the directly traded pair.
+	Deviation float64 // Deviation of Direct from Implied, in basis points.
+}
+
+// getCrossRateSnapshot returns the values of the given cross rate, using the
+// VWAPs of the reported pairs in pairStats.
+func (e *Engine) getCrossRateSnapshot(rate crossRate) CrossRateSnapshot {
+	directVWAP := e.pairStats[rate.Pair].vwap
+	implied, deviation := getImpliedRate(e.pairStats[rate.basePair].vwap,
+		e.pairStats[rate.quotePair].vwap, directVWAP)
+
+	return CrossRateSnapshot{
+		CrossRate: rate.CrossRate,
+		Implied:   implied,
+		Direct:    directVWAP,
+		Deviation: deviation,
+	}
+}
+
+// setCrossRateValues sets the logged values of each cross rate in the given
+// slice, using the VWAPs of the reported pairs in pairStats.
+func (e *Engine) setCrossRateValues(values []interface{}) {
+	for i, rate := range e.crossRates {
+		snapshot := e.getCrossRateSnapshot(rate)
+
+		rateValues := values[i*crossRateValues : (i+1)*crossRateValues]
+		rateValues[0] = e.getLoggedPrice(rate.Pair, snapshot.Implied)
+		rateValues[1] = e.getLoggedPrice(rate.Pair, snapshot.Direct)
+		rateValues[2] = snapshot.Deviation
+	}
+}
@@ -0,0 +1,142 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newCrossRate(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		rate           CrossRate
+		expectedOutput crossRate
+		expectedError  error
+	}{
+		{
+			desc:          "invalid pair",
+			rate:          CrossRate{Pair: "ETHBTC", Via: "USD"},
+			expectedError: errors.New("invalid cross rate pair \"ETHBTC\", expected BASE-QUOTE"),
+		},
+		{
+			desc:          "empty currency",
+			rate:          CrossRate{Pair: "ETH-BTC"},
+			expectedError: errors.New("invalid currency \"\" for cross rate \"ETH-BTC\""),
+		},
+		{
+			desc:          "currency in pair",
+			rate:          CrossRate{Pair: "ETH-BTC", Via: "BTC"},
+			expectedError: errors.New("invalid currency \"BTC\" for cross rate \"ETH-BTC\""),
+		},
+		{
+			desc: "valid cross rate",
+			rate: CrossRate{Pair: "ETH-BTC", Via: "USD"},
+			expectedOutput: crossRate{
+				CrossRate: CrossRate{Pair: "ETH-BTC", Via: "USD"},
+				basePair:  "ETH-USD",
+				quotePair: "BTC-USD",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		output, err := newCrossRate(tc.rate)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_getImpliedRate(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		baseVWAP          float64
+		quoteVWAP         float64
+		directVWAP        float64
+		expectedImplied   float64
+		expectedDeviation float64
+	}{
+		{
+			desc:       "unknown base leg",
+			quoteVWAP:  40000,
+			directVWAP: 0.05,
+		},
+		{
+			desc:       "unknown quote leg",
+			baseVWAP:   2000,
+			directVWAP: 0.05,
+		},
+		{
+			desc:            "unknown direct VWAP",
+			baseVWAP:        2000,
+			quoteVWAP:       40000,
+			expectedImplied: 0.05,
+		},
+		{
+			desc:              "direct VWAP above implied rate",
+			baseVWAP:          2000,
+			quoteVWAP:         40000,
+			directVWAP:        0.0505,
+			expectedImplied:   0.05,
+			expectedDeviation: 100,
+		},
+		{
+			desc:              "direct VWAP below implied rate",
+			baseVWAP:          2000,
+			quoteVWAP:         40000,
+			directVWAP:        0.04975,
+			expectedImplied:   0.05,
+			expectedDeviation: -50,
+		},
+	}
+
+	for _, tc := range testCases {
+		implied, deviation := getImpliedRate(tc.baseVWAP, tc.quoteVWAP,
+			tc.directVWAP)
+		assert.InDelta(t, tc.expectedImplied, implied, 1e-12,
+			"For test %q, got wrong implied rate", tc.desc)
+		assert.InDelta(t, tc.expectedDeviation, deviation, 1e-6,
+			"For test %q, got wrong deviation", tc.desc)
+	}
+}
+
+func Test_EngineCrossRates(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD", "ETH-USD", "ETH-BTC"},
+		WindowSize:   10,
+		CrossRates: []CrossRate{
+			{Pair: "ETH-BTC", Via: "USD"},
+			{Pair: "BTC-ETH", Via: "USD"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 2000, ProductID: "ETH-USD", Side: feed.SellSide, Size: 1},
+		{Price: 0.0505, ProductID: "ETH-BTC", Side: feed.SellSide, Size: 1},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	assert.Equal(t,
+		"\"BTC-USD\": 40000.000000 (buy: 40000.000000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"ETH-USD\": 2000.000000 (buy: 2000.000000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"ETH-BTC\": 0.050500 (buy: 0.050500, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"ETH-BTC via USD\": 0.050000 (direct: 0.050500, deviation: 100.000000 bps), "+
+			"\"BTC-ETH via USD\": 20.000000 (direct: 0.000000, deviation: 0.000000 bps)",
+		engine.getVWAPLog(), "Got unexpected VWAP log")
+
+	_, err = NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD"},
+		WindowSize:   10,
+		CrossRates:   []CrossRate{{Pair: "ETH-BTC"}},
+	})
+	assert.NotNil(t, err, "Expected error for invalid cross rate")
+}
@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
-	ws "github.com/gorilla/websocket"
 	"github.com/ha2398/vwap/feed"
 )
 
@@ -14,63 +15,391 @@ import (
 // goroutine to the handler one.
 const bufferedChannelSize int = 1000
 
+// Number of values logged for each trading pair: the combined VWAP, the taker
+// buy and taker sell VWAPs, the buy/sell volume imbalance, and the price of the
+// last_match received.
+const vwapValuesPerPair int = 5
+
+// Number of values logged for each venue of a trading pair, when there are
+// several venues: the venue VWAP and its volume share.
+const vwapValuesPerVenue int = 2
+
+// Maximum number of distinct unexpected product IDs to keep rejection counts
+// for. Matches for further unexpected products are only counted in the total,
+// so that a misbehaving feed cannot make the engine grow without bounds.
+const maxRejectedProducts int = 100
+
+// Number of rejected matches for a given product between consecutive log
+// messages about them.
+const rejectedMatchesLogInterval int = 1000
+
+// Parameters for reconnecting to the feed: the maximum number of consecutive
+// failed attempts before giving up, and the bounds for the exponential backoff
+// between attempts.
+const (
+	maxReconnectAttempts int           = 10
+	minReconnectBackoff  time.Duration = time.Second
+	maxReconnectBackoff  time.Duration = 30 * time.Second
+)
+
 // Engine is the calculator engine for VWAP.
 type Engine struct {
-	// Connection to the WebSocket feed.
-	feedConn *ws.Conn
+	// Guards the engine state, which may be updated both by the match handler
+	// goroutine and by changes to the trading pairs at runtime.
+	mu sync.Mutex
+
+	// Serializes changes to the trading pairs at runtime, which subscribe and
+	// unsubscribe on the feeds without holding mu.
+	pairsMu sync.Mutex
+
+	// Venues to read matches from.
+	venues []*venueFeed
 
 	// Trading pairs to calculate VWAP for.
 	tradingPairs []string
 
-	// VWAP values for each trading pair, in the same order as they appear in
-	// the field tradingPairs.
-	vwapValues []interface{}
+	// Set of trading pairs, used to reject matches for any other product.
+	subscribedPairs map[string]bool
+
+	// Trading pairs that cannot be removed at runtime.
+	pinnedPairs []string
+
+	// Total number of matches rejected for not belonging to a trading pair of
+	// interest, and the number of rejected matches for each product ID.
+	rejectedMatches          int
+	rejectedMatchesByProduct map[string]int
+
+	// Mapping applied to the product IDs of matches, and the product IDs the
+	// trading pairs are reported as, after the mapping.
+	symbols       SymbolMapping
+	reportedPairs []string
+
+	// Cross rates implied by the VWAPs of the reported pairs, derived series,
+	// and the latest consolidated statistics of each reported pair, which they
+	// are computed from.
+	crossRates []crossRate
+	series     []series
+	pairStats  map[string]pairStats
+
+	// Currency to express the VWAPs of the reported pairs in, if any, and the
+	// conversions needed for it.
+	reportingCurrency string
+	conversions       []conversion
+
+	// Reported pairs to log the TWAP of, and the latest exchange time seen,
+	// from a match or a heartbeat, which the TWAPs are calculated at.
+	twapPairs    []string
+	exchangeTime time.Time
+
+	// Indicates if the standard deviation bands and the price statistics are
+	// logged with the VWAPs.
+	vwapBands, priceStats bool
+
+	// Width of the price buckets of the volume profile of each reported pair
+	// that has one.
+	volumeProfiles map[string]float64
+
+	// Time horizons to calculate additional VWAPs over, and the horizon
+	// windows for each reported pair.
+	vwapHorizons   []time.Duration
+	horizonWindows map[string][]*horizonWindow
+
+	// OHLCV bars to build, and the bar builders for each reported pair.
+	barSpecs []BarSpec
+	bars     map[string][]*barBuilder
+	onBar    func(Bar)
+
+	// VWAP values for each reported pair, in the same order as they appear in
+	// the field reportedPairs, followed by the values for each cross rate,
+	// conversion, TWAP and derived series. Each pair takes valuesPerPair
+	// consecutive entries.
+	vwapValues    []interface{}
+	valuesPerPair int
 
 	// Format string to use for printing VWAPs.
 	vwapLogFormat string
 
-	// Sliding window size.
-	windowSize int
+	// Type of the windows, along with the size of sliding windows and the
+	// half-life of EWMA windows.
+	windowType     WindowType
+	windowSize     int
+	halfLife       time.Duration
+	halfLifeTrades float64
+
+	// Windows with calculation data for each reported pair and venue.
+	windows map[string]map[string]window
+
+	// Policy for handling last_match messages.
+	lastMatchPolicy LastMatchPolicy
+
+	// Most recent last_match received for each reported pair, from any venue.
+	lastMatches map[string]feed.Match
 
-	// Sliding windows with calculation data for each trading pair.
-	windows map[string]*slidingWindow
+	// Local time at which a match was last added to the windows of each
+	// reported pair.
+	lastUpdates map[string]time.Time
+
+	// Function used to round logged prices, if any.
+	roundPrice func(productID string, price float64) float64
+
+	// Staleness detection parameters, and the activity for each trading pair.
+	heartbeatTimeout, tradeTimeout time.Duration
+	onStale                        func(StalenessEvent)
+	activity                       map[string]*pairActivity
+
+	// Reconnection parameters.
+	reconnectOnStale bool
+	reconnectBackoff time.Duration
 }
 
-// NewEngine creates a new VWAP calculation engine, using the given connection
-// to the WebSocket feed, the trading pairs to calculate VWAP for, and the size
-// of the sliding window to use for the algorithm.
-func NewEngine(
-	feedConn *ws.Conn, tradingPairs []string, windowSize int,
-) (*Engine, error) {
+// NewEngine creates a new VWAP calculation engine, reading matches from the
+// feeds of the given venues, and using the calculation parameters in config.
+func NewEngine(venues []Venue, config Config) (*Engine, error) {
 	// Sanity checks.
-	if feedConn == nil {
-		return nil, errors.New("nil feed connection")
+	if len(venues) < 1 {
+		return nil, errors.New("no venues")
 	}
 
-	if len(tradingPairs) < 1 {
+	venueFeeds := make([]*venueFeed, 0, len(venues))
+	venueNames := make(map[string]bool, len(venues))
+	for _, venue := range venues {
+		if venue.Conn == nil {
+			return nil, errors.New("nil feed connection")
+		}
+
+		name := venue.Conn.Venue()
+		if venueNames[name] {
+			return nil, fmt.Errorf("duplicate venue %q", name)
+		}
+		venueNames[name] = true
+
+		if venue.WeightCap < 0 || venue.WeightCap > 1 {
+			return nil, fmt.Errorf("invalid weight cap %v for venue %q, "+
+				"must be between 0 and 1", venue.WeightCap, name)
+		}
+
+		if config.ReconnectOnStale && venue.Reconnect == nil {
+			return nil, fmt.Errorf("reconnect on stale requires a reconnect "+
+				"function for venue %q", name)
+		}
+
+		venueFeeds = append(venueFeeds, &venueFeed{
+			name:          name,
+			conn:          venue.Conn,
+			reconnect:     venue.Reconnect,
+			validatePairs: venue.ValidatePairs,
+			excluded:      venue.Excluded,
+			weightCap:     venue.WeightCap,
+		})
+	}
+
+	if len(config.TradingPairs) < 1 {
 		return nil, errors.New("no trading pairs")
 	}
 
-	if windowSize < 1 {
+	if config.WindowType == "" {
+		config.WindowType = WindowSliding
+	}
+
+	if !config.WindowType.isValid() {
+		return nil, fmt.Errorf("invalid window type %q", config.WindowType)
+	}
+
+	if config.WindowType == WindowSliding && config.WindowSize < 1 {
 		return nil, fmt.Errorf("invalid window size %d, must be at least 1",
-			windowSize)
+			config.WindowSize)
+	}
+
+	if config.HalfLife < 0 || config.HalfLifeTrades < 0 {
+		return nil, errors.New("invalid negative half-life")
+	}
+
+	if config.WindowType == WindowEWMA &&
+		(config.HalfLife > 0) == (config.HalfLifeTrades > 0) {
+		return nil, errors.New("EWMA windows require a half-life either " +
+			"in time or in matches")
 	}
 
-	return &Engine{
-		feedConn:      feedConn,
-		tradingPairs:  tradingPairs,
-		vwapValues:    make([]interface{}, len(tradingPairs)),
-		vwapLogFormat: getVWAPLogFormat(tradingPairs),
-		windows:       make(map[string]*slidingWindow),
-		windowSize:    windowSize,
-	}, nil
+	if config.LastMatchPolicy == "" {
+		config.LastMatchPolicy = LastMatchInclude
+	}
+
+	if !config.LastMatchPolicy.isValid() {
+		return nil, fmt.Errorf("invalid last_match policy %q",
+			config.LastMatchPolicy)
+	}
+
+	if config.HeartbeatTimeout < 0 || config.TradeTimeout < 0 {
+		return nil, errors.New("invalid negative staleness timeout")
+	}
+
+	crossRates := make([]crossRate, 0, len(config.CrossRates))
+	for _, rate := range config.CrossRates {
+		crossRate, err := newCrossRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		crossRates = append(crossRates, crossRate)
+	}
+
+	if err := validateHorizons(config.VWAPHorizons); err != nil {
+		return nil, err
+	}
+
+	derivedSeries, err := newSeries(config.Series, config.VWAPHorizons)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.PriceStats && config.WindowType != WindowSliding {
+		return nil, fmt.Errorf("price statistics require %q windows",
+			WindowSliding)
+	}
+
+	if len(config.VolumeProfiles) > 0 && config.WindowType != WindowSliding {
+		return nil, fmt.Errorf("volume profiles require %q windows",
+			WindowSliding)
+	}
+
+	if err := validateVolumeProfiles(config.VolumeProfiles); err != nil {
+		return nil, err
+	}
+
+	if err := validateBarSpecs(config.Bars); err != nil {
+		return nil, err
+	}
+
+	isTWAPPair := make(map[string]bool, len(config.TWAPPairs))
+	for _, pair := range config.TWAPPairs {
+		if pair == "" {
+			return nil, errors.New("empty TWAP pair")
+		}
+
+		if isTWAPPair[pair] {
+			return nil, fmt.Errorf("duplicate TWAP pair %q", pair)
+		}
+		isTWAPPair[pair] = true
+	}
+
+	e := &Engine{
+		venues:                   venueFeeds,
+		rejectedMatchesByProduct: make(map[string]int),
+		windows:                  make(map[string]map[string]window),
+		windowType:               config.WindowType,
+		windowSize:               config.WindowSize,
+		halfLife:                 config.HalfLife,
+		halfLifeTrades:           config.HalfLifeTrades,
+		lastMatchPolicy:          config.LastMatchPolicy,
+		lastMatches:              make(map[string]feed.Match),
+		lastUpdates:              make(map[string]time.Time),
+		symbols:                  config.SymbolMapping,
+		pinnedPairs:              append([]string(nil), config.PinnedPairs...),
+		crossRates:               crossRates,
+		series:                   derivedSeries,
+		reportingCurrency:        config.ReportingCurrency,
+		twapPairs:                append([]string(nil), config.TWAPPairs...),
+		vwapBands:                config.VWAPBands,
+		priceStats:               config.PriceStats,
+		volumeProfiles:           config.VolumeProfiles,
+		vwapHorizons:             append([]time.Duration(nil), config.VWAPHorizons...),
+		horizonWindows:           make(map[string][]*horizonWindow),
+		barSpecs:                 append([]BarSpec(nil), config.Bars...),
+		bars:                     make(map[string][]*barBuilder),
+		onBar:                    config.OnBar,
+		roundPrice:               config.RoundPrice,
+		heartbeatTimeout:         config.HeartbeatTimeout,
+		tradeTimeout:             config.TradeTimeout,
+		onStale:                  config.OnStale,
+		activity:                 make(map[string]*pairActivity),
+		reconnectOnStale:         config.ReconnectOnStale,
+		reconnectBackoff:         minReconnectBackoff,
+	}
+
+	// The trading pairs slice is copied, since it may be changed at runtime.
+	// Conversion pairs are expected to be subscribed to along with the other
+	// trading pairs.
+	e.setTradingPairs(WithConversionPairs(config.TradingPairs,
+		config.ReportingCurrency, config.SymbolMapping,
+		e.getPairValidation()))
+	return e, nil
 }
 
-// getVWAPLogFormat returns the format string to use when printing VWAPs.
-func getVWAPLogFormat(tradingPairs []string) string {
+// setTradingPairs sets the trading pairs to calculate VWAP for, and updates
+// all the state derived from them.
+func (e *Engine) setTradingPairs(tradingPairs []string) {
+	e.tradingPairs = tradingPairs
+	e.subscribedPairs = make(map[string]bool, len(tradingPairs))
+	for _, pair := range tradingPairs {
+		e.subscribedPairs[pair] = true
+	}
+
+	// Venues are only detailed in the log if there are several of them.
+	var venueNames []string
+	if len(e.venues) > 1 {
+		for _, venue := range e.venues {
+			venueNames = append(venueNames, venue.name)
+		}
+	}
+
+	// Trading pairs merged by the symbol mapping are reported only once.
+	e.reportedPairs = e.symbols.getReportedPairs(tradingPairs)
+
+	e.conversions = e.getConversions(e.reportedPairs)
+
+	e.valuesPerPair = vwapValuesPerPair + len(venueNames)*vwapValuesPerVenue
+	if e.vwapBands {
+		e.valuesPerPair += bandValues
+	}
+	if e.priceStats {
+		e.valuesPerPair += priceStatValues
+	}
+	e.vwapValues = make([]interface{},
+		len(e.reportedPairs)*e.valuesPerPair+
+			len(e.crossRates)*crossRateValues+
+			len(e.conversions)*conversionValues+
+			len(e.twapPairs)+
+			len(e.series))
+	e.vwapLogFormat = getVWAPLogFormat(e.reportedPairs, venueNames,
+		e.vwapBands, e.priceStats) +
+		getCrossRateLogFormat(e.crossRates) +
+		getConversionLogFormat(e.conversions, e.reportingCurrency) +
+		getTWAPLogFormat(e.twapPairs) +
+		getSeriesLogFormat(e.series)
+	e.pairStats = make(map[string]pairStats, len(e.reportedPairs))
+}
+
+// getVWAPLogFormat returns the format string to use when printing VWAPs. The
+// standard deviation bands and the price statistics are included for each
+// trading pair if bands and priceStats are set, followed by the VWAP and
+// volume share of each of the given venues, if any.
+func getVWAPLogFormat(
+	tradingPairs, venueNames []string, bands, priceStats bool,
+) string {
 	formatString := ""
 	for i, pair := range tradingPairs {
-		formatString += fmt.Sprintf("%q: %%f", pair)
+		formatString += fmt.Sprintf(
+			"%q: %%f (buy: %%f, sell: %%f, imbalance: %%f, last_match: %%f",
+			pair)
+
+		if bands {
+			formatString += getBandsLogFormat()
+		}
+
+		if priceStats {
+			formatString += getPriceStatsLogFormat()
+		}
+
+		if len(venueNames) > 0 {
+			formatString += ", venues: {"
+			for j, venue := range venueNames {
+				formatString += fmt.Sprintf("%q: %%f (share: %%f)", venue)
+				if j != len(venueNames)-1 {
+					formatString += ", "
+				}
+			}
+			formatString += "}"
+		}
+		formatString += ")"
 
 		if i != len(tradingPairs)-1 {
 			formatString += ", "
@@ -79,18 +408,63 @@ func getVWAPLogFormat(tradingPairs []string) string {
 	return formatString
 }
 
-// getWindowForProduct returns the sliding window for the given product ID. If
-// no window is found, one is created and stored in the engine.
-func (e *Engine) getWindowForProduct(id string) *slidingWindow {
-	window, hasWindow := e.windows[id]
+// getWindow returns the window for the given product ID and venue. If no
+// window is found, one is created and stored in the engine.
+func (e *Engine) getWindow(id, venue string) window {
+	venueWindows, hasWindows := e.windows[id]
+	if !hasWindows {
+		venueWindows = make(map[string]window)
+		e.windows[id] = venueWindows
+	}
+
+	window, hasWindow := venueWindows[venue]
 	if !hasWindow {
-		window = newSlidingWindow(e.windowSize)
-		e.windows[id] = window
+		window = e.newWindow(id)
+		venueWindows[venue] = window
 	}
 
 	return window
 }
 
+// getWindowForMatch returns the window the given match should be added to,
+// according to the engine's last_match policy. The returned bool is false if
+// the match should not be added to any window. Each venue has its own windows,
+// so seed data is kept separately for each venue.
+func (e *Engine) getWindowForMatch(match feed.Match) (window, bool) {
+	id, venue := match.ProductID, match.Venue
+	window := e.getWindow(id, venue)
+
+	if match.IsLast {
+		if match.Time.IsZero() || !match.Time.Before(e.lastMatches[id].Time) {
+			e.lastMatches[id] = match
+		}
+
+		switch e.lastMatchPolicy {
+		case LastMatchExclude:
+			return nil, false
+
+		case LastMatchSeed:
+			if window.getVolume() > 0 && !window.isSeed() {
+				// Live data has already been received, so seed data is no
+				// longer needed.
+				return nil, false
+			}
+
+			// Only the most recent last_match is kept as seed.
+			window = e.newWindow(id)
+			window.setSeed()
+			e.windows[id][venue] = window
+			return window, true
+		}
+	} else if window.isSeed() {
+		// This is the first live match, so drop the seed data.
+		window = e.newWindow(id)
+		e.windows[id][venue] = window
+	}
+
+	return window, true
+}
+
 // Run is responsible for reading from the WebSocket feed and calculating the
 // VWAP for each registered trading pair.
 func (e *Engine) Run() chan struct{} {
@@ -98,64 +472,314 @@ func (e *Engine) Run() chan struct{} {
 	// The main goroutine listens for this event.
 	doneCh := make(chan struct{})
 
-	// The matchCh is used to communicate match data between the reader and
-	// the handler goroutines.
-	matchCh := make(chan feed.Match, bufferedChannelSize)
+	// The updateCh is used to communicate feed updates between the reader
+	// and the handler goroutines. Heartbeats go along with the matches, so
+	// that they are handled in the order they were read.
+	updateCh := make(chan feed.Update, bufferedChannelSize)
+
+	// Spin up goroutine to handle incoming updates.
+	go e.handleUpdates(updateCh, doneCh)
 
-	// Spin up goroutine to handle incoming matches.
-	go e.handleMatches(matchCh, doneCh)
+	// Spin up one goroutine per venue to read feed messages, parse them, and
+	// feed calculation data into the engine. The updateCh is closed once all
+	// of them stop.
+	var readers sync.WaitGroup
+	readers.Add(len(e.venues))
+	for _, venue := range e.venues {
+		go func(venue *venueFeed) {
+			defer readers.Done()
+			e.readFeed(venue, updateCh)
+		}(venue)
+	}
 
-	// Spin up goroutine to read feed messages, parse them, and feed
-	// calculation data into the engine.
-	go feed.ReadMessages(e.feedConn, func(msg feed.Message, readErr error) {
-		if readErr != nil {
-			close(matchCh)
+	go func() {
+		readers.Wait()
+		close(updateCh)
+	}()
+
+	// Spin up goroutine to detect stale trading pairs, if enabled.
+	if e.heartbeatTimeout > 0 || e.tradeTimeout > 0 {
+		e.mu.Lock()
+		e.resetActivity(now())
+		e.mu.Unlock()
+
+		go e.watchStaleness(doneCh)
+	}
+
+	return doneCh
+}
+
+// readFeed reads messages from the feed connection of the given venue,
+// recording heartbeats and passing updates through updateCh. When reading
+// fails, it reconnects to the feed if the venue has a reconnect function.
+// Otherwise, or if reconnecting fails, it returns.
+func (e *Engine) readFeed(venue *venueFeed, updateCh chan feed.Update) {
+	for {
+		e.getFeedConn(venue).ReadUpdates(
+			func(update feed.Update, readErr error) {
+				if readErr == nil {
+					e.recordHeartbeats(update.Heartbeats)
+					updateCh <- update
+				}
+			})
+
+		if venue.reconnect == nil || !e.reconnectFeed(venue) {
+			log.Printf("Stopped reading from venue %q", venue.name)
 			return
 		}
+	}
+}
 
-		match, isMatch, err := feed.ParseMatch(msg)
-		if !isMatch {
-			return
+// recordHeartbeats records the given heartbeats, read from a feed. Heartbeats
+// with no product ID cover the whole connection, and are recorded for every
+// trading pair.
+func (e *Engine) recordHeartbeats(heartbeats []feed.Heartbeat) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, heartbeat := range heartbeats {
+		if heartbeat.ProductID == "" {
+			for _, pair := range e.tradingPairs {
+				e.recordHeartbeat(pair, heartbeat.ReceivedAt)
+			}
+		} else if e.subscribedPairs[heartbeat.ProductID] {
+			e.recordHeartbeat(heartbeat.ProductID, heartbeat.ReceivedAt)
 		}
+	}
+}
 
-		if err != nil {
-			log.Printf("Error parsing match data: %v", err)
-			return
+// handleUpdate handles the heartbeats of a feed update, and then its matches.
+// The exchange timestamps of the heartbeats close the time bars that ended
+// before them, and move the end of the horizon windows, so they are handled
+// in the same goroutine as the matches read before them.
+func (e *Engine) handleUpdate(update feed.Update) {
+	if len(update.Heartbeats) > 0 {
+		var bars []Bar
+		e.mu.Lock()
+		for _, heartbeat := range update.Heartbeats {
+			if heartbeat.ProductID == "" ||
+				e.subscribedPairs[heartbeat.ProductID] {
+				e.recordExchangeTime(heartbeat.Time)
+			}
+
+			if heartbeat.ProductID == "" {
+				for _, pair := range e.reportedPairs {
+					bars = append(bars, e.advanceBars(pair, heartbeat.Time)...)
+					e.advanceHorizons(pair, heartbeat.Time)
+				}
+			} else if e.subscribedPairs[heartbeat.ProductID] {
+				pair := e.symbols.mapProduct(heartbeat.ProductID)
+				bars = append(bars, e.advanceBars(pair, heartbeat.Time)...)
+				e.advanceHorizons(pair, heartbeat.Time)
+			}
 		}
+		e.mu.Unlock()
+		e.raiseBars(bars)
+	}
 
-		matchCh <- match
-	})
+	for _, match := range update.Matches {
+		e.handleMatch(match)
+	}
+}
 
-	return doneCh
+// recordExchangeTime records the given exchange time, if it is the latest one
+// seen. Heartbeats with no exchange timestamp are ignored.
+func (e *Engine) recordExchangeTime(t time.Time) {
+	if t.After(e.exchangeTime) {
+		e.exchangeTime = t
+	}
 }
 
-// handleMatches takes all incoming matches data and updates the VWAP for each
-// of them.
-// The matchCh argument is used to receive match data, and the doneCh is used
-// to communicate the calculation termination.
-func (e *Engine) handleMatches(matchCh chan feed.Match, doneCh chan struct{}) {
+// getFeedConn returns the current connection to the feed of the given venue.
+func (e *Engine) getFeedConn(venue *venueFeed) *feed.Conn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return venue.conn
+}
+
+// closeFeedConns closes the current connections to the feeds of all venues,
+// which makes the reader goroutines reconnect if the venues have a reconnect
+// function.
+func (e *Engine) closeFeedConns() {
+	for _, venue := range e.venues {
+		e.closeFeedConn(venue)
+	}
+}
+
+// closeFeedConn closes the current connection to the feed of the given venue.
+func (e *Engine) closeFeedConn(venue *venueFeed) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := venue.conn.Close(); err != nil {
+		log.Printf("Error closing feed connection to venue %q: %v",
+			venue.name, err)
+	}
+}
+
+// reconnectFeed closes the current connection to the feed of the given venue,
+// and tries to create a new subscription for the current trading pairs, with
+// exponential backoff between attempts. It returns false if all attempts fail.
+// Calculation data is kept across reconnects.
+func (e *Engine) reconnectFeed(venue *venueFeed) bool {
+	e.closeFeedConn(venue)
+
+	backoff := e.reconnectBackoff
+	for attempt := 1; attempt <= maxReconnectAttempts; attempt++ {
+		log.Printf("Reconnecting to feed of venue %q (attempt %d of %d)",
+			venue.name, attempt, maxReconnectAttempts)
+
+		conn, err := venue.reconnect(e.TradingPairs())
+		if err == nil {
+			e.mu.Lock()
+			venue.conn = conn
+			e.resetActivity(now())
+			e.mu.Unlock()
+
+			log.Printf("Reconnected to feed of venue %q", venue.name)
+			return true
+		}
+
+		log.Printf("Error reconnecting to feed of venue %q: %v", venue.name,
+			err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+
+	log.Printf("Giving up reconnecting to feed of venue %q after %d attempts",
+		venue.name, maxReconnectAttempts)
+	return false
+}
+
+// handleUpdates takes all incoming feed updates and updates the VWAP for each
+// of their matches.
+// The updateCh argument is used to receive feed updates, and the doneCh is
+// used to communicate the calculation termination.
+func (e *Engine) handleUpdates(
+	updateCh chan feed.Update, doneCh chan struct{},
+) {
 	defer close(doneCh)
-	for match := range matchCh {
-		// Get the sliding window for the given trading pair.
-		slidingWindow := e.getWindowForProduct(match.ProductID)
+	for update := range updateCh {
+		e.handleUpdate(update)
+	}
+}
+
+// handleMatch updates the VWAP for the trading pair of the given match, and
+// logs the current VWAP values.
+func (e *Engine) handleMatch(match feed.Match) {
+	// Closed bars are raised once the engine is unlocked.
+	var bars []Bar
+	defer func() { e.raiseBars(bars) }()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-		// Update VWAP.
-		if err := slidingWindow.addMatch(match); err != nil {
+	// Only process matches for the trading pairs of interest.
+	if !e.subscribedPairs[match.ProductID] {
+		e.rejectMatch(match)
+		return
+	}
+
+	// Past trades reported by last_match messages do not indicate activity.
+	if !match.IsLast {
+		e.recordTrade(match.ProductID, now())
+	}
+
+	// Rename the product, keeping the one reported by the venue.
+	match.OriginalProductID = match.ProductID
+	match.ProductID = e.symbols.mapProduct(match.ProductID)
+	e.recordExchangeTime(getMatchTime(match))
+	bars = e.addBarMatch(match)
+	e.addHorizonMatch(match)
+
+	// Get the window for the given trading pair, and update its VWAP.
+	window, shouldAdd := e.getWindowForMatch(match)
+	if shouldAdd {
+		if err := window.addMatch(match); err != nil {
 			log.Printf("Error adding match data for %q VWAP calculation: %v",
 				match.ProductID, err)
-			continue
+			return
 		}
+		e.lastUpdates[match.ProductID] = now()
+	}
+
+	// Print current VWAP for each pair.
+	log.Print(e.getVWAPLog())
+}
 
-		// Print current VWAP for each pair.
-		log.Print(e.getVWAPLog())
+// rejectMatch counts the given match as rejected for not belonging to any of the
+// trading pairs of interest, and logs it periodically.
+func (e *Engine) rejectMatch(match feed.Match) {
+	e.rejectedMatches++
+
+	count, isTracked := e.rejectedMatchesByProduct[match.ProductID]
+	if !isTracked && len(e.rejectedMatchesByProduct) >= maxRejectedProducts {
+		return
+	}
+
+	count++
+	e.rejectedMatchesByProduct[match.ProductID] = count
+	if count%rejectedMatchesLogInterval == 1 {
+		log.Printf("Rejected match for unexpected product %q (%d rejected so "+
+			"far for this product, %d in total)", match.ProductID, count,
+			e.rejectedMatches)
 	}
 }
 
 // getVWAPLog prints the current VWAP values for all trading pairs of interest.
 func (e *Engine) getVWAPLog() string {
 	logString := e.vwapLogFormat
-	for i, pair := range e.tradingPairs {
-		e.vwapValues[i] = e.getWindowForProduct(pair).getVWAP()
+	for i, pair := range e.reportedPairs {
+		summary := e.getPairSummary(pair)
+		stats := e.getPairStats(pair, summary)
+		e.pairStats[pair] = stats
+
+		values := e.vwapValues[i*e.valuesPerPair : (i+1)*e.valuesPerPair]
+		values[0] = e.getLoggedPrice(pair, stats.vwap)
+		values[1] = e.getLoggedPrice(pair, stats.buyVWAP)
+		values[2] = e.getLoggedPrice(pair, stats.sellVWAP)
+		values[3] = stats.imbalance
+		values[4] = e.getLoggedPrice(pair, stats.lastMatch)
+
+		// Band and price statistic values are only allocated when they are
+		// logged, and venue values when there are several venues.
+		venueValues := values[vwapValuesPerPair:]
+		if e.vwapBands {
+			e.setBandValues(pair, stats, venueValues[:bandValues])
+			venueValues = venueValues[bandValues:]
+		}
+		if e.priceStats {
+			e.setPriceStatValues(pair, stats, venueValues[:priceStatValues])
+			venueValues = venueValues[priceStatValues:]
+		}
+		for j := 0; j < len(venueValues)/vwapValuesPerVenue; j++ {
+			venueValues[j*vwapValuesPerVenue] =
+				e.getLoggedPrice(pair, summary.venueVWAPs[j])
+			venueValues[j*vwapValuesPerVenue+1] = summary.venueShares[j]
+		}
 	}
+
+	crossRateStart := len(e.reportedPairs) * e.valuesPerPair
+	conversionStart := crossRateStart + len(e.crossRates)*crossRateValues
+	twapStart := conversionStart + len(e.conversions)*conversionValues
+	seriesStart := twapStart + len(e.twapPairs)
+	e.setCrossRateValues(e.vwapValues[crossRateStart:conversionStart])
+	e.setConversionValues(e.vwapValues[conversionStart:twapStart])
+	e.setTWAPValues(e.vwapValues[twapStart:seriesStart])
+	e.setSeriesValues(e.vwapValues[seriesStart:])
 	return fmt.Sprintf(logString, e.vwapValues...)
 }
+
+// getLoggedPrice returns the given price for a trading pair, rounded if the
+// engine has a rounding function.
+func (e *Engine) getLoggedPrice(pair string, price float64) float64 {
+	if e.roundPrice == nil {
+		return price
+	}
+	return e.roundPrice(pair, price)
+}
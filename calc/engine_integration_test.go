@@ -3,11 +3,15 @@
 package calc
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	ws "github.com/gorilla/websocket"
 	"github.com/ha2398/vwap/feed"
@@ -104,8 +108,8 @@ var engineTestMessages []feed.Message = []feed.Message{
 	},
 }
 
-// testServerHandler spins up a test server that will simply echo all the
-// messages it receives back to the sender.
+// testServerHandler spins up a test server that confirms the subscription
+// request it receives, and then sends all the engine test messages.
 func testServerHandler(w http.ResponseWriter, r *http.Request) {
 	wsUpgrader := ws.Upgrader{}
 	c, err := wsUpgrader.Upgrade(w, r, nil)
@@ -123,7 +127,11 @@ func testServerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.WriteJSON(&msg)
+	err = c.WriteJSON(feed.Message{
+		feed.TypeKey:       feed.SubscriptionsType,
+		feed.ChannelsKey:   msg[feed.ChannelsKey],
+		feed.ProductIDsKey: msg[feed.ProductIDsKey],
+	})
 	if err != nil {
 		log.Printf("Error writing subscription response in test server: %v",
 			err)
@@ -140,9 +148,12 @@ func testServerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func Test_EngineRun(t *testing.T) {
+	// Combined VWAP, taker buy VWAP, taker sell VWAP, imbalance and last_match
+	// price for each pair. All A-B makers are buyers, while all C-D makers are
+	// sellers.
 	var expectedVWAPFinalValues []interface{} = []interface{}{
-		128.75,             // A-B
-		18.533333333333335, // C-D
+		128.75, 0.0, 128.75, -1.0, 200.0, // A-B
+		18.533333333333335, 18.533333333333335, 0.0, 1.0, 10.0, // C-D
 	}
 
 	// Spin up test servers.
@@ -151,7 +162,11 @@ func Test_EngineRun(t *testing.T) {
 	serverEndpoint := strings.Replace(server.URL, "http", "ws", 1)
 	tradingPairs := []string{"A-B", "C-D"}
 
-	feedConn, err := feed.CreateSubscription(serverEndpoint, tradingPairs)
+	feedConn, err := feed.CreateSubscription(feed.Config{
+		Endpoint:            serverEndpoint,
+		ProductIDs:          tradingPairs,
+		ConfirmationTimeout: time.Second,
+	})
 	if err != nil {
 		t.Fatalf("Error creating feed subscription: %v", err)
 		return
@@ -161,7 +176,10 @@ func Test_EngineRun(t *testing.T) {
 
 	// Create calculation engine.
 	windowSize := 3
-	vwapEngine, err := NewEngine(feedConn, tradingPairs, windowSize)
+	vwapEngine, err := NewEngine([]Venue{{Conn: feedConn}}, Config{
+		TradingPairs: tradingPairs,
+		WindowSize:   windowSize,
+	})
 	if err != nil {
 		t.Fatalf("Error creating new VWAP calculation engine: %v", err)
 		return
@@ -175,3 +193,153 @@ func Test_EngineRun(t *testing.T) {
 	assert.EqualValues(t, expectedVWAPFinalValues, vwapEngine.vwapValues,
 		"Got incorrect VWAP values")
 }
+
+func Test_EngineRunMultipleVenues(t *testing.T) {
+	// Both venues send the same matches, so the consolidated values match the
+	// ones of a single venue, and each venue has half of the volume.
+	var expectedVWAPFinalValues []interface{} = []interface{}{
+		128.75, 0.0, 128.75, -1.0, 200.0, // A-B
+		128.75, 0.5, 128.75, 0.5, // A-B venues
+		18.533333333333335, 18.533333333333335, 0.0, 1.0, 10.0, // C-D
+		18.533333333333335, 0.5, 18.533333333333335, 0.5, // C-D venues
+	}
+
+	// Spin up test servers.
+	tradingPairs := []string{"A-B", "C-D"}
+	var venues []Venue
+	for _, name := range []string{"venue1", "venue2"} {
+		server := httptest.NewServer(http.HandlerFunc(testServerHandler))
+		defer server.Close()
+
+		feedConn, err := feed.CreateSubscription(feed.Config{
+			Endpoint:            strings.Replace(server.URL, "http", "ws", 1),
+			Venue:               name,
+			ProductIDs:          tradingPairs,
+			ConfirmationTimeout: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("Error creating feed subscription: %v", err)
+			return
+		}
+
+		defer feedConn.Close()
+		venues = append(venues, Venue{Conn: feedConn})
+	}
+
+	// Create calculation engine.
+	vwapEngine, err := NewEngine(venues, Config{
+		TradingPairs: tradingPairs,
+		WindowSize:   3,
+	})
+	if err != nil {
+		t.Fatalf("Error creating new VWAP calculation engine: %v", err)
+		return
+	}
+
+	// Start calculation engine.
+	doneCh := vwapEngine.Run()
+	<-doneCh
+
+	// Get VWAP values.
+	assert.EqualValues(t, expectedVWAPFinalValues, vwapEngine.vwapValues,
+		"Got incorrect VWAP values")
+}
+
+func Test_EngineReconnectOnStale(t *testing.T) {
+	// Spin up test server. The first connection goes silent after the
+	// subscription, while the second one sends a match and closes.
+	var connections int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			wsUpgrader := ws.Upgrader{}
+			c, err := wsUpgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			defer c.Close()
+			if atomic.AddInt32(&connections, 1) == 1 {
+				for {
+					if _, _, err := c.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}
+
+			err = c.WriteJSON(feed.Message{
+				feed.TypeKey:      feed.MatchType,
+				feed.PriceKey:     "10",
+				feed.ProductIDKey: "A-B",
+				feed.SideKey:      feed.BuySide,
+				feed.SizeKey:      "2",
+			})
+			if err != nil {
+				log.Printf("Error writing message in test server: %v", err)
+			}
+		}))
+	defer server.Close()
+	serverEndpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	feedConn, err := feed.CreateSubscription(feed.Config{
+		Endpoint:   serverEndpoint,
+		ProductIDs: []string{"A-B"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating feed subscription: %v", err)
+		return
+	}
+
+	// Only the first reconnect succeeds, so that the engine eventually stops.
+	var reconnects int
+	var eventsMu sync.Mutex
+	var events []StalenessEvent
+	venue := Venue{
+		Conn: feedConn,
+		Reconnect: func(pairs []string) (*feed.Conn, error) {
+			reconnects++
+			if reconnects > 1 {
+				return nil, errors.New("some error")
+			}
+
+			return feed.CreateSubscription(feed.Config{
+				Endpoint:   serverEndpoint,
+				ProductIDs: pairs,
+			})
+		},
+	}
+	vwapEngine, err := NewEngine([]Venue{venue}, Config{
+		TradingPairs:     []string{"A-B"},
+		WindowSize:       3,
+		HeartbeatTimeout: 50 * time.Millisecond,
+		OnStale: func(event StalenessEvent) {
+			eventsMu.Lock()
+			defer eventsMu.Unlock()
+			events = append(events, event)
+		},
+		ReconnectOnStale: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating new VWAP calculation engine: %v", err)
+		return
+	}
+	vwapEngine.reconnectBackoff = time.Millisecond
+
+	// Start calculation engine.
+	doneCh := vwapEngine.Run()
+	<-doneCh
+
+	// The pair also goes stale while the engine fails to reconnect for the
+	// second time, so only the first event is checked.
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if assert.NotEmpty(t, events, "Expected staleness events") {
+		assert.Equal(t, "A-B", events[0].ProductID,
+			"Got unexpected product in staleness event")
+		assert.Equal(t, HeartbeatStale, events[0].Kind,
+			"Got unexpected kind of staleness event")
+	}
+	assert.Equal(t, 1+maxReconnectAttempts, reconnects,
+		"Got unexpected number of reconnects")
+	assert.Equal(t, 10.0, vwapEngine.windows["A-B"][feed.ExchangeFormat].getVWAP(),
+		"Got incorrect VWAP after reconnecting")
+}
@@ -4,53 +4,111 @@ package calc
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"testing"
 
-	ws "github.com/gorilla/websocket"
 	"github.com/ha2398/vwap/feed"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_NewEngine(t *testing.T) {
 	testCases := []struct {
-		desc          string
-		conn          *ws.Conn
-		tradingPairs  []string
-		windowSize    int
-		expectedError error
+		desc             string
+		venues           []Venue
+		tradingPairs     []string
+		windowSize       int
+		lastMatchPolicy  LastMatchPolicy
+		reconnectOnStale bool
+		expectedPolicy   LastMatchPolicy
+		expectedError    error
 	}{
+		{
+			desc:          "no venues",
+			venues:        nil,
+			tradingPairs:  []string{"myPair"},
+			windowSize:    42,
+			expectedError: errors.New("no venues"),
+		},
 		{
 			desc:          "nil feed connection",
-			conn:          nil,
+			venues:        []Venue{{Conn: nil}},
 			tradingPairs:  []string{},
 			windowSize:    0,
 			expectedError: errors.New("nil feed connection"),
 		},
+		{
+			desc:          "duplicate venue",
+			venues:        []Venue{{Conn: &feed.Conn{}}, {Conn: &feed.Conn{}}},
+			tradingPairs:  []string{"myPair"},
+			windowSize:    42,
+			expectedError: errors.New("duplicate venue \"\""),
+		},
+		{
+			desc:         "invalid weight cap",
+			venues:       []Venue{{Conn: &feed.Conn{}, WeightCap: 1.5}},
+			tradingPairs: []string{"myPair"},
+			windowSize:   42,
+			expectedError: errors.New("invalid weight cap 1.5 for venue \"\", " +
+				"must be between 0 and 1"),
+		},
+		{
+			desc:             "reconnect on stale without reconnect function",
+			venues:           []Venue{{Conn: &feed.Conn{}}},
+			tradingPairs:     []string{"myPair"},
+			windowSize:       42,
+			reconnectOnStale: true,
+			expectedError: errors.New("reconnect on stale requires a " +
+				"reconnect function for venue \"\""),
+		},
 		{
 			desc:          "no trading pairs",
-			conn:          &ws.Conn{},
+			venues:        []Venue{{Conn: &feed.Conn{}}},
 			tradingPairs:  []string{},
 			windowSize:    0,
 			expectedError: errors.New("no trading pairs"),
 		},
 		{
 			desc:          "invalid window size",
-			conn:          &ws.Conn{},
+			venues:        []Venue{{Conn: &feed.Conn{}}},
 			tradingPairs:  []string{"myPair"},
 			windowSize:    -42,
 			expectedError: errors.New("invalid window size -42, must be at least 1"),
 		},
 		{
-			desc:          "valid parameters",
-			conn:          &ws.Conn{},
-			tradingPairs:  []string{"myPair"},
-			windowSize:    42,
-			expectedError: nil,
+			desc:            "invalid last_match policy",
+			venues:          []Venue{{Conn: &feed.Conn{}}},
+			tradingPairs:    []string{"myPair"},
+			windowSize:      42,
+			lastMatchPolicy: "somePolicy",
+			expectedError:   errors.New("invalid last_match policy \"somePolicy\""),
+		},
+		{
+			desc:           "valid parameters, default last_match policy",
+			venues:         []Venue{{Conn: &feed.Conn{}}},
+			tradingPairs:   []string{"myPair"},
+			windowSize:     42,
+			expectedPolicy: LastMatchInclude,
+			expectedError:  nil,
+		},
+		{
+			desc:            "valid parameters",
+			venues:          []Venue{{Conn: &feed.Conn{}}},
+			tradingPairs:    []string{"myPair"},
+			windowSize:      42,
+			lastMatchPolicy: LastMatchSeed,
+			expectedPolicy:  LastMatchSeed,
+			expectedError:   nil,
 		},
 	}
 
 	for _, tc := range testCases {
-		engine, err := NewEngine(tc.conn, tc.tradingPairs, tc.windowSize)
+		engine, err := NewEngine(tc.venues, Config{
+			TradingPairs:     tc.tradingPairs,
+			WindowSize:       tc.windowSize,
+			LastMatchPolicy:  tc.lastMatchPolicy,
+			ReconnectOnStale: tc.reconnectOnStale,
+		})
 		assert.Equal(t, tc.expectedError, err,
 			"For test %q, got unexpected error value", tc.desc)
 
@@ -58,67 +116,91 @@ func Test_NewEngine(t *testing.T) {
 			continue
 		}
 
-		assert.NotNil(t, engine.feedConn,
-			"For test %q, got nil feed connection", tc.desc)
+		assert.Equal(t, len(tc.venues), len(engine.venues),
+			"For test %q, got incorrect number of venues", tc.desc)
 		assert.Equal(t, tc.tradingPairs, engine.tradingPairs,
 			"For test %q, got incorrect trading pairs", tc.desc)
-		assert.Equal(t, len(tc.tradingPairs), len(engine.vwapValues),
+		assert.Equal(t, len(tc.tradingPairs)*vwapValuesPerPair,
+			len(engine.vwapValues),
 			"For test %q, vwapValues slice not initialized correctly", tc.desc)
 		assert.Equal(t, tc.windowSize, engine.windowSize,
 			"For test %q, got incorrect window size", tc.desc)
+		assert.Equal(t, tc.expectedPolicy, engine.lastMatchPolicy,
+			"For test %q, got incorrect last_match policy", tc.desc)
 	}
 }
 
-func Test_getWindowForProduct(t *testing.T) {
+func Test_getWindow(t *testing.T) {
 	testCases := []struct {
 		desc      string
 		engine    *Engine
 		productID string
+		venue     string
 	}{
 		{
 			desc: "no previous window",
 			engine: &Engine{
 				windowSize: 42,
-				windows:    map[string]*slidingWindow{},
+				windows:    map[string]map[string]window{},
 			},
 			productID: "someID",
+			venue:     "someVenue",
+		},
+		{
+			desc: "previous window present for another venue",
+			engine: &Engine{
+				windowSize: 42,
+				windows: map[string]map[string]window{
+					"someID": {"otherVenue": newSlidingWindow(42)},
+				},
+			},
+			productID: "someID",
+			venue:     "someVenue",
 		},
 		{
 			desc: "previous window present",
 			engine: &Engine{
 				windowSize: 42,
-				windows: map[string]*slidingWindow{
-					"someID": newSlidingWindow(42),
+				windows: map[string]map[string]window{
+					"someID": {"someVenue": newSlidingWindow(42)},
 				},
 			},
 			productID: "someID",
+			venue:     "someVenue",
 		},
 	}
 
 	for _, tc := range testCases {
-		output := tc.engine.getWindowForProduct(tc.productID)
+		output := tc.engine.getWindow(tc.productID, tc.venue)
 
-		assert.NotNil(t, output,
-			"For test %q, got nil output", tc.desc)
-		assert.NotNil(t, output.data,
+		window, ok := output.(*slidingWindow)
+		if !assert.True(t, ok, "For test %q, got %T output", tc.desc,
+			output) {
+			continue
+		}
+		assert.NotNil(t, window.data,
 			"For test %q, got nil data channel", tc.desc)
-		assert.Equal(t, tc.engine.windowSize, output.size,
+		assert.Equal(t, tc.engine.windowSize, window.size,
 			"For test %q, got incorrect window size", tc.desc)
+		assert.Same(t, window, tc.engine.windows[tc.productID][tc.venue],
+			"For test %q, window not stored in engine", tc.desc)
 	}
 }
 
-func Test_handleMatches(t *testing.T) {
+func Test_handleUpdates(t *testing.T) {
 	testCases := []struct {
-		desc         string
-		tradingPairs []string
-		matches      []feed.Match
-		expectedLog  string
+		desc            string
+		tradingPairs    []string
+		lastMatchPolicy LastMatchPolicy
+		matches         []feed.Match
+		expectedLog     string
+		expectedRejects int
 	}{
 		{
 			desc:         "no matches",
 			tradingPairs: []string{"pair1"},
 			matches:      []feed.Match{},
-			expectedLog:  "\"pair1\": 0.000000",
+			expectedLog:  "\"pair1\": 0.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000)",
 		},
 		{
 			desc:         "only last_match data",
@@ -128,10 +210,12 @@ func Test_handleMatches(t *testing.T) {
 					IsLast:    true,
 					Price:     10,
 					ProductID: "pair2",
+					Side:      feed.SellSide,
 					Size:      2,
 				},
 			},
-			expectedLog: "\"pair1\": 0.000000, \"pair2\": 10.000000",
+			expectedLog: "\"pair1\": 0.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000), " +
+				"\"pair2\": 10.000000 (buy: 10.000000, sell: 0.000000, imbalance: 1.000000, last_match: 10.000000)",
 		},
 		{
 			desc:         "last_match and match data",
@@ -141,22 +225,26 @@ func Test_handleMatches(t *testing.T) {
 					IsLast:    true,
 					Price:     10,
 					ProductID: "pair2",
+					Side:      feed.BuySide,
 					Size:      2,
 				},
 				feed.Match{
 					IsLast:    true,
 					Price:     5,
 					ProductID: "pair1",
+					Side:      feed.BuySide,
 					Size:      1,
 				},
 				feed.Match{
 					IsLast:    false,
 					Price:     20,
 					ProductID: "pair1",
+					Side:      feed.SellSide,
 					Size:      10,
 				},
 			},
-			expectedLog: "\"pair1\": 18.636364, \"pair2\": 10.000000",
+			expectedLog: "\"pair1\": 18.636364 (buy: 20.000000, sell: 5.000000, imbalance: 0.818182, last_match: 5.000000), " +
+				"\"pair2\": 10.000000 (buy: 0.000000, sell: 10.000000, imbalance: -1.000000, last_match: 10.000000)",
 		},
 		{
 			desc:         "invalid match data",
@@ -169,34 +257,162 @@ func Test_handleMatches(t *testing.T) {
 					Size:      0,
 				},
 			},
-			expectedLog: "\"pair1\": 0.000000",
+			expectedLog: "\"pair1\": 0.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000)",
+		},
+		{
+			desc:         "matches for unexpected products",
+			tradingPairs: []string{"pair1"},
+			matches: []feed.Match{
+				feed.Match{
+					Price:     10,
+					ProductID: "pair2",
+					Side:      feed.BuySide,
+					Size:      2,
+				},
+				feed.Match{
+					Price:     10,
+					ProductID: "",
+					Side:      feed.BuySide,
+					Size:      2,
+				},
+			},
+			expectedLog:     "\"pair1\": 0.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000)",
+			expectedRejects: 2,
+		},
+		{
+			desc:            "last_match data excluded",
+			tradingPairs:    []string{"pair1"},
+			lastMatchPolicy: LastMatchExclude,
+			matches: []feed.Match{
+				feed.Match{
+					IsLast:    true,
+					Price:     5,
+					ProductID: "pair1",
+					Side:      feed.BuySide,
+					Size:      1,
+				},
+				feed.Match{
+					IsLast:    false,
+					Price:     20,
+					ProductID: "pair1",
+					Side:      feed.SellSide,
+					Size:      10,
+				},
+			},
+			expectedLog: "\"pair1\": 20.000000 (buy: 20.000000, sell: 0.000000, imbalance: 1.000000, last_match: 5.000000)",
+		},
+		{
+			desc:            "last_match data as seed, no live data",
+			tradingPairs:    []string{"pair1"},
+			lastMatchPolicy: LastMatchSeed,
+			matches: []feed.Match{
+				feed.Match{
+					IsLast:    true,
+					Price:     5,
+					ProductID: "pair1",
+					Side:      feed.BuySide,
+					Size:      1,
+				},
+				feed.Match{
+					IsLast:    true,
+					Price:     6,
+					ProductID: "pair1",
+					Side:      feed.BuySide,
+					Size:      1,
+				},
+			},
+			expectedLog: "\"pair1\": 6.000000 (buy: 0.000000, sell: 6.000000, imbalance: -1.000000, last_match: 6.000000)",
+		},
+		{
+			desc:            "last_match data as seed, dropped on live data",
+			tradingPairs:    []string{"pair1"},
+			lastMatchPolicy: LastMatchSeed,
+			matches: []feed.Match{
+				feed.Match{
+					IsLast:    true,
+					Price:     5,
+					ProductID: "pair1",
+					Side:      feed.BuySide,
+					Size:      1,
+				},
+				feed.Match{
+					IsLast:    false,
+					Price:     20,
+					ProductID: "pair1",
+					Side:      feed.SellSide,
+					Size:      10,
+				},
+				feed.Match{
+					IsLast:    true,
+					Price:     7,
+					ProductID: "pair1",
+					Side:      feed.BuySide,
+					Size:      1,
+				},
+			},
+			expectedLog: "\"pair1\": 20.000000 (buy: 20.000000, sell: 0.000000, imbalance: 1.000000, last_match: 7.000000)",
 		},
 	}
 
 	for _, tc := range testCases {
-		engine, err := NewEngine(&ws.Conn{}, tc.tradingPairs, 10)
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs:    tc.tradingPairs,
+			WindowSize:      10,
+			LastMatchPolicy: tc.lastMatchPolicy,
+		})
 
 		assert.Nil(t, err, "For test %q, got error creating engine", tc.desc)
 
-		matchCh := make(chan feed.Match, bufferedChannelSize)
+		updateCh := make(chan feed.Update, bufferedChannelSize)
 		doneCh := make(chan struct{})
 
 		for _, match := range tc.matches {
-			matchCh <- match
+			updateCh <- feed.Update{Matches: []feed.Match{match}}
 		}
 
-		close(matchCh)
-		engine.handleMatches(matchCh, doneCh)
+		close(updateCh)
+		engine.handleUpdates(updateCh, doneCh)
 
 		assert.Equal(t, tc.expectedLog, engine.getVWAPLog(),
 			"For test %q, for unexpected VWAP log", tc.desc)
+		assert.Equal(t, tc.expectedRejects, engine.rejectedMatches,
+			"For test %q, got unexpected number of rejected matches", tc.desc)
+		for pair := range engine.windows {
+			assert.Contains(t, tc.tradingPairs, pair,
+				"For test %q, got windows for unexpected products", tc.desc)
+		}
+	}
+}
+
+func Test_rejectMatch(t *testing.T) {
+	e := &Engine{
+		rejectedMatchesByProduct: make(map[string]int),
 	}
+
+	for i := 0; i < maxRejectedProducts; i++ {
+		e.rejectMatch(feed.Match{ProductID: fmt.Sprintf("product%d", i)})
+	}
+
+	e.rejectMatch(feed.Match{ProductID: "product0"})
+	e.rejectMatch(feed.Match{ProductID: "oneTooMany"})
+
+	assert.Equal(t, maxRejectedProducts+2, e.rejectedMatches,
+		"Got unexpected total of rejected matches")
+	assert.Equal(t, maxRejectedProducts, len(e.rejectedMatchesByProduct),
+		"Got unexpected number of tracked products")
+	assert.Equal(t, 2, e.rejectedMatchesByProduct["product0"],
+		"Got unexpected number of rejected matches for tracked product")
+	assert.NotContains(t, e.rejectedMatchesByProduct, "oneTooMany",
+		"Got untracked product in rejection counts")
 }
 
 func Test_getVWAPLogFormat(t *testing.T) {
 	testCases := []struct {
 		desc           string
 		tradingPairs   []string
+		venueNames     []string
+		bands          bool
+		priceStats     bool
 		expectedOutput string
 	}{
 		{
@@ -212,17 +428,47 @@ func Test_getVWAPLogFormat(t *testing.T) {
 		{
 			desc:           "single trading pair",
 			tradingPairs:   []string{"pair1"},
-			expectedOutput: `"pair1": %f`,
+			expectedOutput: `"pair1": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f)`,
+		},
+		{
+			desc:         "multiple trading pairs",
+			tradingPairs: []string{"pair1", "pair2", "pair3"},
+			expectedOutput: `"pair1": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f), ` +
+				`"pair2": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f), ` +
+				`"pair3": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f)`,
+		},
+		{
+			desc:         "multiple venues",
+			tradingPairs: []string{"pair1", "pair2"},
+			venueNames:   []string{"venue1", "venue2"},
+			expectedOutput: `"pair1": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f, ` +
+				`venues: {"venue1": %f (share: %f), "venue2": %f (share: %f)}), ` +
+				`"pair2": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f, ` +
+				`venues: {"venue1": %f (share: %f), "venue2": %f (share: %f)})`,
 		},
 		{
-			desc:           "multiple trading pairs",
-			tradingPairs:   []string{"pair1", "pair2", "pair3"},
-			expectedOutput: `"pair1": %f, "pair2": %f, "pair3": %f`,
+			desc:         "bands and venues",
+			tradingPairs: []string{"pair1"},
+			venueNames:   []string{"venue1"},
+			bands:        true,
+			expectedOutput: `"pair1": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f, ` +
+				`std_dev: %f, bands: [%f, %f, %f, %f], ` +
+				`venues: {"venue1": %f (share: %f)})`,
+		},
+		{
+			desc:         "bands and price statistics",
+			tradingPairs: []string{"pair1"},
+			bands:        true,
+			priceStats:   true,
+			expectedOutput: `"pair1": %f (buy: %f, sell: %f, imbalance: %f, last_match: %f, ` +
+				`std_dev: %f, bands: [%f, %f, %f, %f], ` +
+				`high: %f, low: %f, median: %f)`,
 		},
 	}
 
 	for _, tc := range testCases {
-		output := getVWAPLogFormat(tc.tradingPairs)
+		output := getVWAPLogFormat(tc.tradingPairs, tc.venueNames, tc.bands,
+			tc.priceStats)
 		assert.Equal(t, tc.expectedOutput, output,
 			"For test %q, got wrong output", tc.desc)
 	}
@@ -232,7 +478,9 @@ func Test_getVWAPLog(t *testing.T) {
 	testCases := []struct {
 		desc           string
 		tradingPairs   []string
-		windows        map[string]*slidingWindow
+		venues         []*venueFeed
+		windows        map[string]map[string]window
+		roundPrice     func(string, float64) float64
 		expectedOutput string
 	}{
 		{
@@ -244,36 +492,86 @@ func Test_getVWAPLog(t *testing.T) {
 		{
 			desc:           "no trading pairs",
 			tradingPairs:   []string{},
-			windows:        map[string]*slidingWindow{},
+			windows:        map[string]map[string]window{},
 			expectedOutput: "",
 		},
 		{
 			desc:         "single trading pair",
 			tradingPairs: []string{"pair1"},
-			windows: map[string]*slidingWindow{
-				"pair1": &slidingWindow{vwap: 10.0},
+			windows: map[string]map[string]window{
+				"pair1": {"": &slidingWindow{
+					vwap:            10.0,
+					vwapDenominator: 4,
+					buySums:         vwapSums{numerator: 33, denominator: 3},
+					sellSums:        vwapSums{numerator: 9, denominator: 1},
+				}},
 			},
-			expectedOutput: "\"pair1\": 10.000000",
+			expectedOutput: "\"pair1\": 10.000000 (buy: 11.000000, sell: 9.000000, imbalance: 0.500000, last_match: 0.000000)",
 		},
 		{
 			desc:         "multiple trading pairs",
 			tradingPairs: []string{"pair1", "pair2", "pair3"},
-			windows: map[string]*slidingWindow{
-				"pair1": &slidingWindow{vwap: 10.0},
-				"pair2": &slidingWindow{vwap: 42.123456},
-				"pair3": &slidingWindow{vwap: -123.456789},
+			windows: map[string]map[string]window{
+				"pair1": {"": &slidingWindow{vwap: 10.0, vwapDenominator: 1}},
+				"pair2": {"": &slidingWindow{vwap: 42.123456, vwapDenominator: 1}},
+				"pair3": {"": &slidingWindow{vwap: -123.456789, vwapDenominator: 1}},
 			},
-			expectedOutput: "\"pair1\": 10.000000, \"pair2\": 42.123456, \"pair3\": -123.456789",
+			expectedOutput: "\"pair1\": 10.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000), " +
+				"\"pair2\": 42.123456 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000), " +
+				"\"pair3\": -123.456789 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 0.000000)",
+		},
+		{
+			desc:         "rounded prices",
+			tradingPairs: []string{"pair1"},
+			windows: map[string]map[string]window{
+				"pair1": {"": &slidingWindow{
+					vwap:            10.4,
+					vwapDenominator: 4,
+					buySums:         vwapSums{numerator: 34, denominator: 3},
+					sellSums:        vwapSums{numerator: 9, denominator: 1},
+				}},
+			},
+			roundPrice: func(pair string, price float64) float64 {
+				return math.Round(price)
+			},
+			expectedOutput: "\"pair1\": 10.000000 (buy: 11.000000, sell: 9.000000, imbalance: 0.500000, last_match: 0.000000)",
+		},
+		{
+			desc:         "multiple venues",
+			tradingPairs: []string{"pair1"},
+			venues:       []*venueFeed{{name: "venue1"}, {name: "venue2"}},
+			windows: map[string]map[string]window{
+				"pair1": {
+					"venue1": &slidingWindow{
+						vwap:            10.0,
+						vwapDenominator: 4,
+						buySums:         vwapSums{numerator: 30, denominator: 3},
+						sellSums:        vwapSums{numerator: 10, denominator: 1},
+					},
+					"venue2": &slidingWindow{
+						vwap:            20.0,
+						vwapDenominator: 4,
+						buySums:         vwapSums{numerator: 80, denominator: 4},
+						sellSums:        vwapSums{numerator: 0, denominator: 0},
+					},
+				},
+			},
+			expectedOutput: "\"pair1\": 15.000000 (buy: 15.714286, sell: 10.000000, imbalance: 0.750000, last_match: 0.000000, " +
+				"venues: {\"venue1\": 10.000000 (share: 0.500000), \"venue2\": 20.000000 (share: 0.500000)})",
 		},
 	}
 
 	for _, tc := range testCases {
 		e := &Engine{
-			tradingPairs:  tc.tradingPairs,
-			vwapValues:    make([]interface{}, len(tc.tradingPairs)),
-			vwapLogFormat: getVWAPLogFormat(tc.tradingPairs),
-			windows:       tc.windows,
+			tradingPairs: tc.tradingPairs,
+			venues:       tc.venues,
+			windows:      tc.windows,
+			roundPrice:   tc.roundPrice,
+		}
+		if e.venues == nil {
+			e.venues = []*venueFeed{{}}
 		}
+		e.setTradingPairs(tc.tradingPairs)
 		output := e.getVWAPLog()
 		assert.Equal(t, tc.expectedOutput, output,
 			"For test %q, got wrong output", tc.desc)
@@ -0,0 +1,197 @@
+package calc
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// ewmaWindow maintains an exponentially weighted VWAP, in which the weight of
+// each match halves every half-life, either in time or in number of matches.
+//
+// On each update, the partial sums are multiplied by the decay since the
+// previous update, and the new data is added to them, so that no match has to
+// be stored:
+//
+//	numerator = numerator * decay + price * size
+//	denominator = denominator * decay + size
+//
+// With a half-life in time, the decay is 2^(-elapsed / halfLife), where the
+// elapsed time is measured with the exchange timestamps of the matches. With
+// a half-life in matches, it is 2^(-1 / halfLifeTrades).
+type ewmaWindow struct {
+	// Half-life of the weights, in time or in number of matches. Only one of
+	// them is set.
+	halfLife       time.Duration
+	halfLifeTrades float64
+
+	// Decayed sums of all matches, and of taker buy and taker sell matches.
+	sums, buySums, sellSums vwapSums
+
+	// Decayed sums over all matches for the variance, shifted by the price
+	// of the most recent match.
+	variance varianceSums
+
+	// Decayed sums of the time-weighted prices held, for the TWAP, and the
+	// price of the most recent match.
+	twapNumerator, twapDenominator float64
+	last                           *heldPrice
+
+	// Time the sums were last decayed at.
+	updatedAt time.Time
+
+	// Function returning the latest exchange time seen by the engine, until
+	// which the sums keep decaying after the last match. The sums do not
+	// decay after the last match if nil.
+	clock func() time.Time
+
+	// Indicates if the window only holds last_match seed data.
+	seed bool
+}
+
+func newEWMAWindow(
+	halfLife time.Duration, halfLifeTrades float64,
+) *ewmaWindow {
+	return &ewmaWindow{halfLife: halfLife, halfLifeTrades: halfLifeTrades}
+}
+
+func (w *ewmaWindow) addMatch(match feed.Match) error {
+	held := newHeldPrice(match)
+	decay := w.getDecay(held.time)
+
+	// The price of the previous match was held until this one.
+	if w.last != nil {
+		duration := getHeldDuration(w.last.time, held.time).Seconds()
+		w.twapNumerator = w.twapNumerator*decay + w.last.price*duration
+		w.twapDenominator = w.twapDenominator*decay + duration
+	}
+	w.last = held
+
+	partialData := getVWAPPartialDataFromMatch(match)
+	w.sums = w.sums.decay(decay)
+	w.buySums = w.buySums.decay(decay)
+	w.sellSums = w.sellSums.decay(decay)
+	w.variance = w.variance.decay(decay)
+	w.variance.rebase(match.Price, w.sums.denominator)
+	w.variance.add(match.Price, match.Size)
+	w.sums.add(partialData)
+	switch partialData.takerSide {
+	case feed.BuySide:
+		w.buySums.add(partialData)
+	case feed.SellSide:
+		w.sellSums.add(partialData)
+	}
+
+	if held.time.After(w.updatedAt) {
+		w.updatedAt = held.time
+	}
+
+	if w.sums.denominator <= 0 {
+		return errors.New("unable to calculate VWAP with zero denominator")
+	}
+	return nil
+}
+
+// getDecay returns the factor to multiply the sums by for an update at the
+// given time. Updates older than the last one do not decay the sums.
+func (w *ewmaWindow) getDecay(t time.Time) float64 {
+	if w.halfLifeTrades > 0 {
+		return math.Exp2(-1 / w.halfLifeTrades)
+	}
+
+	if w.halfLife <= 0 || w.updatedAt.IsZero() {
+		return 1
+	}
+
+	elapsed := getHeldDuration(w.updatedAt, t)
+	return math.Exp2(-elapsed.Seconds() / w.halfLife.Seconds())
+}
+
+func (w *ewmaWindow) getVWAP() float64 {
+	return w.sums.getVWAP()
+}
+
+// getVariance returns the exponentially weighted variance of the prices around
+// the VWAP.
+func (w *ewmaWindow) getVariance() float64 {
+	return w.variance.getVariance(w.sums.denominator)
+}
+
+// getHigh returns 0, since EWMA windows do not keep the prices of their
+// matches.
+func (w *ewmaWindow) getHigh() float64 {
+	return 0
+}
+
+// getLow returns 0, since EWMA windows do not keep the prices of their
+// matches.
+func (w *ewmaWindow) getLow() float64 {
+	return 0
+}
+
+// getSortedPrices returns nil, since EWMA windows do not keep the prices of
+// their matches.
+func (w *ewmaWindow) getSortedPrices() []weightedPrice {
+	return nil
+}
+
+// getVolumeProfile returns nil, since EWMA windows do not keep the prices of
+// their matches.
+func (w *ewmaWindow) getVolumeProfile() *volumeProfile {
+	return nil
+}
+
+// getVolume returns the decayed volume of the matches. With a half-life in
+// time, it keeps decaying after the last match, so that a venue that stops
+// trading loses its weight in the consolidated VWAP.
+func (w *ewmaWindow) getVolume() float64 {
+	return w.sums.denominator * w.getIdleDecay()
+}
+
+func (w *ewmaWindow) getTakerSums() (buySums, sellSums vwapSums) {
+	decay := w.getIdleDecay()
+	return w.buySums.decay(decay), w.sellSums.decay(decay)
+}
+
+// getIdleDecay returns the decay of the sums since the last match, up to the
+// latest exchange time, which is only applied with a half-life in time.
+func (w *ewmaWindow) getIdleDecay() float64 {
+	if w.halfLifeTrades > 0 || w.clock == nil {
+		return 1
+	}
+	return w.getDecay(w.clock())
+}
+
+// getTWAP returns the exponentially weighted TWAP at the given time, in which
+// the price of the most recent match is held until then.
+func (w *ewmaWindow) getTWAP(t time.Time) float64 {
+	if w.last == nil {
+		return 0
+	}
+
+	open := getHeldDuration(w.last.time, t).Seconds()
+	numerator := w.twapNumerator + w.last.price*open
+	denominator := w.twapDenominator + open
+	if denominator <= 0 {
+		return w.last.price
+	}
+	return numerator / denominator
+}
+
+// decay returns the sums multiplied by the given factor.
+func (s vwapSums) decay(factor float64) vwapSums {
+	return vwapSums{
+		numerator:   s.numerator * factor,
+		denominator: s.denominator * factor,
+	}
+}
+
+func (w *ewmaWindow) isSeed() bool {
+	return w.seed
+}
+
+func (w *ewmaWindow) setSeed() {
+	w.seed = true
+}
@@ -0,0 +1,258 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ewmaWindowAddMatch(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc             string
+		halfLife         time.Duration
+		halfLifeTrades   float64
+		matches          []feed.Match
+		expectedVWAP     float64
+		expectedVolume   float64
+		expectedBuySums  vwapSums
+		expectedSellSums vwapSums
+	}{
+		{
+			desc:           "half-life in matches",
+			halfLifeTrades: 1,
+			matches: []feed.Match{
+				{Price: 10, Size: 2, Side: feed.SellSide, Time: start},
+				{Price: 20, Size: 1, Side: feed.BuySide, Time: start},
+			},
+			expectedVWAP:     15,
+			expectedVolume:   2,
+			expectedBuySums:  vwapSums{numerator: 10, denominator: 1},
+			expectedSellSums: vwapSums{numerator: 20, denominator: 1},
+		},
+		{
+			desc:     "half-life in time",
+			halfLife: time.Second,
+			matches: []feed.Match{
+				{Price: 10, Size: 4, Time: start},
+				{Price: 20, Size: 1, Time: start.Add(2 * time.Second)},
+			},
+			expectedVWAP:   15,
+			expectedVolume: 2,
+		},
+		{
+			desc:     "match out of order",
+			halfLife: time.Second,
+			matches: []feed.Match{
+				{Price: 10, Size: 1, Time: start.Add(2 * time.Second)},
+				{Price: 20, Size: 1, Time: start},
+			},
+			expectedVWAP:   15,
+			expectedVolume: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		window := newEWMAWindow(tc.halfLife, tc.halfLifeTrades)
+		for _, match := range tc.matches {
+			assert.Nil(t, window.addMatch(match),
+				"For test %q, got error adding match", tc.desc)
+		}
+
+		assert.InDelta(t, tc.expectedVWAP, window.getVWAP(), 1e-9,
+			"For test %q, got wrong VWAP", tc.desc)
+		assert.InDelta(t, tc.expectedVolume, window.sums.denominator, 1e-9,
+			"For test %q, got wrong volume", tc.desc)
+
+		buySums, sellSums := window.getTakerSums()
+		if tc.halfLifeTrades > 0 {
+			assert.Equal(t, tc.expectedBuySums, buySums,
+				"For test %q, got wrong buy sums", tc.desc)
+			assert.Equal(t, tc.expectedSellSums, sellSums,
+				"For test %q, got wrong sell sums", tc.desc)
+		}
+	}
+}
+
+func Test_ewmaWindowGetDecay(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc           string
+		halfLife       time.Duration
+		halfLifeTrades float64
+		updatedAt      time.Time
+		at             time.Time
+		expectedOutput float64
+	}{
+		{
+			desc:           "no previous update",
+			halfLife:       time.Second,
+			at:             start,
+			expectedOutput: 1,
+		},
+		{
+			desc:           "half-life in time",
+			halfLife:       time.Second,
+			updatedAt:      start,
+			at:             start.Add(3 * time.Second),
+			expectedOutput: 0.125,
+		},
+		{
+			desc:           "update before the previous one",
+			halfLife:       time.Second,
+			updatedAt:      start,
+			at:             start.Add(-time.Second),
+			expectedOutput: 1,
+		},
+		{
+			desc:           "half-life in matches",
+			halfLifeTrades: 2,
+			updatedAt:      start,
+			at:             start.Add(time.Hour),
+			expectedOutput: 0.7071067811865476,
+		},
+	}
+
+	for _, tc := range testCases {
+		window := newEWMAWindow(tc.halfLife, tc.halfLifeTrades)
+		window.updatedAt = tc.updatedAt
+
+		assert.InDelta(t, tc.expectedOutput, window.getDecay(tc.at), 1e-9,
+			"For test %q, got wrong decay", tc.desc)
+	}
+}
+
+func Test_ewmaWindowGetTWAP(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	window := newEWMAWindow(time.Second, 0)
+	assert.Equal(t, 0.0, window.getTWAP(start), "Got TWAP with no matches")
+
+	matches := []feed.Match{
+		{Price: 10, Size: 1, Time: start},
+		{Price: 20, Size: 1, Time: start.Add(time.Second)},
+		{Price: 30, Size: 1, Time: start.Add(2 * time.Second)},
+	}
+	for _, match := range matches {
+		assert.Nil(t, window.addMatch(match), "Got error adding match")
+	}
+
+	// Both prices were held for 1 second, but the first one was decayed by
+	// half on the last match.
+	assert.InDelta(t, 25/1.5, window.getTWAP(start.Add(2*time.Second)),
+		1e-9, "Got wrong TWAP")
+}
+
+func Test_ewmaWindowIdleDecay(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	window := newEWMAWindow(10*time.Second, 0)
+	assert.Nil(t, window.addMatch(feed.Match{Price: 10, Size: 4,
+		Side: feed.SellSide, Time: start}), "Got error adding match")
+	assert.Equal(t, 4.0, window.getVolume(), "Got decayed volume with no clock")
+
+	// The volume decays until the latest exchange time.
+	exchangeTime := start.Add(10 * time.Second)
+	window.clock = func() time.Time { return exchangeTime }
+	assert.InDelta(t, 2.0, window.getVolume(), 1e-9,
+		"Got wrong volume after a half-life")
+	buySums, _ := window.getTakerSums()
+	assert.InDelta(t, 2.0, buySums.denominator, 1e-9,
+		"Got wrong taker sums after a half-life")
+}
+
+func Test_NewEngineWindowType(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		windowType     WindowType
+		windowSize     int
+		halfLife       time.Duration
+		halfLifeTrades float64
+		expectedError  error
+		expectedWindow window
+	}{
+		{
+			desc:          "invalid window type",
+			windowType:    "tumbling",
+			windowSize:    10,
+			expectedError: errors.New("invalid window type \"tumbling\""),
+		},
+		{
+			desc:          "negative half-life",
+			windowType:    WindowEWMA,
+			halfLife:      -time.Second,
+			expectedError: errors.New("invalid negative half-life"),
+		},
+		{
+			desc:       "no half-life",
+			windowType: WindowEWMA,
+			expectedError: errors.New("EWMA windows require a half-life " +
+				"either in time or in matches"),
+		},
+		{
+			desc:           "both half-lives",
+			windowType:     WindowEWMA,
+			halfLife:       time.Second,
+			halfLifeTrades: 10,
+			expectedError: errors.New("EWMA windows require a half-life " +
+				"either in time or in matches"),
+		},
+		{
+			desc:           "sliding windows by default",
+			windowSize:     10,
+			expectedWindow: newSlidingWindow(10),
+		},
+		{
+			desc:           "EWMA windows",
+			windowType:     WindowEWMA,
+			halfLifeTrades: 10,
+			expectedWindow: newEWMAWindow(0, 10),
+		},
+	}
+
+	for _, tc := range testCases {
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs:   []string{"BTC-USD"},
+			WindowType:     tc.windowType,
+			WindowSize:     tc.windowSize,
+			HalfLife:       tc.halfLife,
+			HalfLifeTrades: tc.halfLifeTrades,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		assert.IsType(t, tc.expectedWindow, engine.newWindow("BTC-USD"),
+			"For test %q, got wrong window type", tc.desc)
+	}
+}
+
+func Test_EngineEWMASeed(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:    []string{"BTC-USD"},
+		WindowType:      WindowEWMA,
+		HalfLifeTrades:  10,
+		LastMatchPolicy: LastMatchSeed,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 39000, ProductID: "BTC-USD", Size: 1, IsLast: true},
+		{Price: 40000, ProductID: "BTC-USD", Size: 1},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	// The seed data is dropped on the first live match.
+	assert.Equal(t, 40000.0, engine.getWindow("BTC-USD", "").getVWAP(),
+		"Got wrong VWAP")
+}
@@ -0,0 +1,338 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expressions define derived series over the statistics of the reported pairs,
+// e.g. "(BTC-USD.vwap - BTC-USDT.vwap) / BTC-USD.vwap". They are made of
+// numbers, variables of the form PAIR.statistic, the binary operators +, -, *
+// and /, unary minus, and parentheses. Since product IDs contain dashes,
+// subtractions must be surrounded by spaces when their left operand is a
+// number.
+
+// Patterns for variables and numbers in expressions. Numbers are digits with
+// an optional fraction and an optional exponent, or a fraction alone, e.g.
+// "42", "1.e5" or ".5".
+var (
+	variablePattern = regexp.MustCompile(
+		`^[A-Za-z0-9]+(?:-[A-Za-z0-9]+)*\.[A-Za-z_][A-Za-z0-9_]*`)
+	numberPattern = regexp.MustCompile(
+		`^(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][+-]?[0-9]+)?`)
+)
+
+// tokenKind is the kind of an expression token.
+type tokenKind int
+
+// Kinds of expression tokens.
+const (
+	numberToken tokenKind = iota
+	variableToken
+	operatorToken
+	endToken
+)
+
+// token is a lexical unit of an expression.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits the given expression into tokens, ending with an endToken.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(input); {
+		rest := input[pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t':
+			pos++
+
+		case strings.ContainsRune("+-*/()", rune(rest[0])):
+			tokens = append(tokens, token{operatorToken, rest[:1], pos})
+			pos++
+
+		default:
+			// A number followed by more of a word is not a number, but may
+			// be a variable for a product ID starting with digits, e.g.
+			// "1INCH-USD.vwap".
+			number := numberPattern.FindString(rest)
+			variable := variablePattern.FindString(rest)
+			switch {
+			case number != "" && getWordLength(rest[len(number):]) == 0:
+				tokens = append(tokens, token{numberToken, number, pos})
+				pos += len(number)
+
+			case variable != "":
+				tokens = append(tokens, token{variableToken, variable, pos})
+				pos += len(variable)
+
+			case number != "":
+				return nil, fmt.Errorf("malformed number %q at position %d",
+					rest[:getWordLength(rest)], pos)
+
+			default:
+				return nil, fmt.Errorf("unexpected character %q at "+
+					"position %d", rest[0], pos)
+			}
+		}
+	}
+
+	return append(tokens, token{endToken, "", len(input)}), nil
+}
+
+// getWordLength returns the length of the letters, digits, underscores and
+// dots at the start of the given string.
+func getWordLength(s string) int {
+	for i, c := range s {
+		isLetter := c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !isDigit && c != '_' && c != '.' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// expression is a node of a parsed expression.
+type expression interface {
+	// evaluate returns the value of the expression, using the given function
+	// to get the value of each variable. The value is NaN if any variable is
+	// unknown, or if there is a division by zero.
+	evaluate(getVariable func(pair, statistic string) (float64, bool)) float64
+}
+
+type numberExpression float64
+
+func (n numberExpression) evaluate(
+	getVariable func(pair, statistic string) (float64, bool),
+) float64 {
+	return float64(n)
+}
+
+type variableExpression struct {
+	pair, statistic string
+}
+
+func (v variableExpression) evaluate(
+	getVariable func(pair, statistic string) (float64, bool),
+) float64 {
+	value, ok := getVariable(v.pair, v.statistic)
+	if !ok {
+		return math.NaN()
+	}
+	return value
+}
+
+type negationExpression struct {
+	operand expression
+}
+
+func (n negationExpression) evaluate(
+	getVariable func(pair, statistic string) (float64, bool),
+) float64 {
+	return -n.operand.evaluate(getVariable)
+}
+
+type binaryExpression struct {
+	operator    string
+	left, right expression
+}
+
+func (b binaryExpression) evaluate(
+	getVariable func(pair, statistic string) (float64, bool),
+) float64 {
+	left := b.left.evaluate(getVariable)
+	right := b.right.evaluate(getVariable)
+
+	switch b.operator {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	default:
+		if right == 0 {
+			return math.NaN()
+		}
+		return left / right
+	}
+}
+
+// getExpressionPairs returns the pairs referred to by the variables of the
+// given expression, without duplicates.
+func getExpressionPairs(expr expression) []string {
+	var pairs []string
+	var addPairs func(expression)
+	addPairs = func(expr expression) {
+		switch expr := expr.(type) {
+		case variableExpression:
+			if !containsString(pairs, expr.pair) {
+				pairs = append(pairs, expr.pair)
+			}
+		case negationExpression:
+			addPairs(expr.operand)
+		case binaryExpression:
+			addPairs(expr.left)
+			addPairs(expr.right)
+		}
+	}
+
+	addPairs(expr)
+	return pairs
+}
+
+// parser builds an expression from its tokens, by recursive descent.
+type parser struct {
+	tokens []token
+	next   int
+
+	// Function indicating if a statistic name is valid.
+	isStatistic func(string) bool
+}
+
+// parseExpression parses the given expression, in which variables may only
+// refer to the statistics accepted by isStatistic.
+func parseExpression(
+	input string, isStatistic func(string) bool,
+) (expression, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expression %q: %v", input, err)
+	}
+
+	p := &parser{tokens: tokens, isStatistic: isStatistic}
+	expr, err := p.parseSum()
+	if err == nil && p.peek().kind != endToken {
+		err = fmt.Errorf("unexpected %q at position %d", p.peek().text,
+			p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expression %q: %v", input, err)
+	}
+	return expr, nil
+}
+
+// peek returns the next token, without consuming it.
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+// acceptOperator consumes the next token if it is one of the given operators,
+// and returns it.
+func (p *parser) acceptOperator(operators ...string) (string, bool) {
+	next := p.peek()
+	if next.kind != operatorToken {
+		return "", false
+	}
+
+	for _, operator := range operators {
+		if next.text == operator {
+			p.next++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+// parseSum parses a sequence of terms separated by + or -.
+func (p *parser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator, ok := p.acceptOperator("+", "-")
+		if !ok {
+			return left, nil
+		}
+
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression{operator: operator, left: left, right: right}
+	}
+}
+
+// parseProduct parses a sequence of factors separated by * or /.
+func (p *parser) parseProduct() (expression, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator, ok := p.acceptOperator("*", "/")
+		if !ok {
+			return left, nil
+		}
+
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression{operator: operator, left: left, right: right}
+	}
+}
+
+// parseFactor parses a number, a variable, a negated factor, or an expression
+// in parentheses.
+func (p *parser) parseFactor() (expression, error) {
+	if _, ok := p.acceptOperator("-"); ok {
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return negationExpression{operand: operand}, nil
+	}
+
+	if _, ok := p.acceptOperator("("); ok {
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := p.acceptOperator(")"); !ok {
+			return nil, fmt.Errorf("missing \")\" at position %d",
+				p.peek().pos)
+		}
+		return expr, nil
+	}
+
+	next := p.peek()
+	switch next.kind {
+	case numberToken:
+		p.next++
+		value, err := strconv.ParseFloat(next.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d",
+				next.text, next.pos)
+		}
+		return numberExpression(value), nil
+
+	case variableToken:
+		p.next++
+		separator := strings.LastIndex(next.text, ".")
+		pair, statistic := next.text[:separator], next.text[separator+1:]
+		if !p.isStatistic(statistic) {
+			return nil, fmt.Errorf("unknown statistic %q at position %d",
+				statistic, next.pos)
+		}
+		return variableExpression{pair: pair, statistic: statistic}, nil
+
+	case endToken:
+		return nil, errors.New("unexpected end of expression")
+
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", next.text,
+			next.pos)
+	}
+}
@@ -0,0 +1,250 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseExpression(t *testing.T) {
+	variables := map[string]float64{
+		"BTC-USD.vwap":   40000,
+		"BTC-USDT.vwap":  40040,
+		"ETH-USD.vwap":   2000,
+		"1INCH-USD.vwap": 0.5,
+		"ETH-USD.volume": 0,
+	}
+	getVariable := func(pair, statistic string) (float64, bool) {
+		value, ok := variables[pair+"."+statistic]
+		return value, ok
+	}
+
+	testCases := []struct {
+		desc           string
+		input          string
+		expectedOutput float64
+		expectedError  error
+	}{
+		{
+			desc:           "number",
+			input:          "42.5",
+			expectedOutput: 42.5,
+		},
+		{
+			desc:           "number with exponent",
+			input:          "1.e5",
+			expectedOutput: 100000,
+		},
+		{
+			desc:           "number with signed exponent",
+			input:          "2.5E-1 * 4",
+			expectedOutput: 1,
+		},
+		{
+			desc:           "fraction",
+			input:          ".5 + 1",
+			expectedOutput: 1.5,
+		},
+		{
+			desc:           "variable",
+			input:          "BTC-USD.vwap",
+			expectedOutput: 40000,
+		},
+		{
+			desc:           "product ID starting with a digit",
+			input:          "1INCH-USD.vwap * 2",
+			expectedOutput: 1,
+		},
+		{
+			desc:           "operator precedence",
+			input:          "1 + 2 * 3 - 4 / 2",
+			expectedOutput: 5,
+		},
+		{
+			desc:           "parentheses and unary minus",
+			input:          "-(1 + 2) * -2",
+			expectedOutput: 6,
+		},
+		{
+			desc:           "subtraction without spaces",
+			input:          "BTC-USDT.vwap-BTC-USD.vwap",
+			expectedOutput: 40,
+		},
+		{
+			desc:           "basis",
+			input:          "(BTC-USDT.vwap - BTC-USD.vwap) / BTC-USD.vwap * 10000",
+			expectedOutput: 10,
+		},
+		{
+			desc:           "unknown variable",
+			input:          "ETH-BTC.vwap + 1",
+			expectedOutput: math.NaN(),
+		},
+		{
+			desc:           "division by zero",
+			input:          "ETH-USD.vwap / ETH-USD.volume",
+			expectedOutput: math.NaN(),
+		},
+		{
+			desc:          "empty expression",
+			input:         "",
+			expectedError: errors.New("error parsing expression \"\": unexpected end of expression"),
+		},
+		{
+			desc:          "unknown statistic",
+			input:         "BTC-USD.price",
+			expectedError: errors.New("error parsing expression \"BTC-USD.price\": unknown statistic \"price\" at position 0"),
+		},
+		{
+			desc:          "unexpected character",
+			input:         "BTC-USD.vwap % 2",
+			expectedError: errors.New("error parsing expression \"BTC-USD.vwap % 2\": unexpected character '%' at position 13"),
+		},
+		{
+			desc:          "repeated decimal point",
+			input:         "1..2",
+			expectedError: errors.New("error parsing expression \"1..2\": malformed number \"1..2\" at position 0"),
+		},
+		{
+			desc:          "exponent without digits",
+			input:         "1e + 2",
+			expectedError: errors.New("error parsing expression \"1e + 2\": malformed number \"1e\" at position 0"),
+		},
+		{
+			desc:          "number with two fractions",
+			input:         "2 * 1.5.5",
+			expectedError: errors.New("error parsing expression \"2 * 1.5.5\": malformed number \"1.5.5\" at position 4"),
+		},
+		{
+			desc:          "missing parenthesis",
+			input:         "(1 + 2",
+			expectedError: errors.New("error parsing expression \"(1 + 2\": missing \")\" at position 6"),
+		},
+		{
+			desc:          "trailing tokens",
+			input:         "1 2",
+			expectedError: errors.New("error parsing expression \"1 2\": unexpected \"2\" at position 2"),
+		},
+		{
+			desc:          "missing operand",
+			input:         "1 + * 2",
+			expectedError: errors.New("error parsing expression \"1 + * 2\": unexpected \"*\" at position 4"),
+		},
+	}
+
+	for _, tc := range testCases {
+		expr, err := parseExpression(tc.input, isPairStatistic)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		output := expr.evaluate(getVariable)
+		if math.IsNaN(tc.expectedOutput) {
+			assert.True(t, math.IsNaN(output),
+				"For test %q, expected NaN, got %v", tc.desc, output)
+			continue
+		}
+		assert.InDelta(t, tc.expectedOutput, output, 1e-9,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_newSeries(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		definitions   []Series
+		horizons      []time.Duration
+		expectedError error
+	}{
+		{
+			desc:          "empty name",
+			definitions:   []Series{{Expression: "1"}},
+			expectedError: errors.New("empty series name"),
+		},
+		{
+			desc: "duplicate name",
+			definitions: []Series{
+				{Name: "s", Expression: "1"},
+				{Name: "s", Expression: "2"},
+			},
+			expectedError: errors.New("duplicate series \"s\""),
+		},
+		{
+			desc:        "invalid expression",
+			definitions: []Series{{Name: "s", Expression: "1 +"}},
+			expectedError: errors.New("error creating series \"s\": error " +
+				"parsing expression \"1 +\": unexpected end of expression"),
+		},
+		{
+			desc: "valid series",
+			definitions: []Series{
+				{Name: "s1", Expression: "BTC-USD.vwap"},
+				{Name: "s2", Expression: "BTC-USD.vwap / 2"},
+			},
+		},
+		{
+			desc: "unknown horizon",
+			definitions: []Series{
+				{Name: "s", Expression: "BTC-USD.vwap5m"},
+			},
+			horizons: []time.Duration{time.Hour},
+			expectedError: errors.New("error creating series \"s\": error " +
+				"parsing expression \"BTC-USD.vwap5m\": unknown statistic " +
+				"\"vwap5m\" at position 0"),
+		},
+		{
+			desc: "horizons",
+			definitions: []Series{
+				{Name: "s", Expression: "(BTC-USD.vwap5m - BTC-USD.vwap1h) / " +
+					"BTC-USD.vwap1h"},
+			},
+			horizons: []time.Duration{5 * time.Minute, time.Hour},
+		},
+	}
+
+	for _, tc := range testCases {
+		output, err := newSeries(tc.definitions, tc.horizons)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err == nil {
+			assert.Equal(t, len(tc.definitions), len(output),
+				"For test %q, got wrong number of series", tc.desc)
+		}
+	}
+}
+
+func Test_EngineSeries(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD", "BTC-USDT"},
+		WindowSize:   10,
+		Series: []Series{
+			{Name: "basis", Expression: "(BTC-USDT.vwap - BTC-USD.vwap) / BTC-USD.vwap"},
+			{Name: "missing", Expression: "ETH-USD.vwap"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 40400, ProductID: "BTC-USDT", Side: feed.BuySide, Size: 2},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	assert.Equal(t,
+		"\"BTC-USD\": 40000.000000 (buy: 40000.000000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"BTC-USDT\": 40400.000000 (buy: 0.000000, sell: 40400.000000, imbalance: -1.000000, last_match: 0.000000), "+
+			"\"basis\": 0.010000, \"missing\": NaN",
+		engine.getVWAPLog(), "Got unexpected VWAP log")
+}
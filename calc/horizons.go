@@ -0,0 +1,164 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// horizonMatch is a match in a horizon window, along with its exchange time.
+type horizonMatch struct {
+	time    time.Time
+	partial vwapPartialData
+}
+
+// horizonWindow holds the matches of a reported pair whose exchange times are
+// within a time horizon of the latest exchange time seen for the pair, from
+// a match or a heartbeat.
+type horizonWindow struct {
+	horizon time.Duration
+	matches []horizonMatch
+	sums    vwapSums
+	latest  time.Time
+}
+
+func newHorizonWindow(horizon time.Duration) *horizonWindow {
+	return &horizonWindow{horizon: horizon}
+}
+
+// addMatch adds the given match to the window, and evicts the matches that
+// are no longer within the horizon.
+func (w *horizonWindow) addMatch(match feed.Match) {
+	matchTime := getMatchTime(match)
+	partial := vwapPartialData{
+		product: match.Price * match.Size,
+		size:    match.Size,
+	}
+
+	w.matches = append(w.matches, horizonMatch{matchTime, partial})
+	w.sums.add(partial)
+	w.advance(matchTime)
+}
+
+// advance moves the end of the window to the given exchange time, if it is
+// later than the current one, and evicts the matches that are no longer
+// within the horizon. Matches received out of order are kept until the ones
+// before them are evicted.
+func (w *horizonWindow) advance(t time.Time) {
+	if t.After(w.latest) {
+		w.latest = t
+	}
+
+	start := w.latest.Add(-w.horizon)
+	evicted := 0
+	for ; evicted < len(w.matches); evicted++ {
+		if w.matches[evicted].time.After(start) {
+			break
+		}
+		w.sums.subtract(w.matches[evicted].partial)
+	}
+	w.matches = w.matches[evicted:]
+
+	// Rounding errors are dropped along with the last match.
+	if len(w.matches) == 0 {
+		w.sums = vwapSums{}
+	}
+}
+
+// formatHorizon returns the given horizon in its largest whole unit, e.g. "5m"
+// for 5 minutes, as used in the names of the horizon statistics.
+func formatHorizon(horizon time.Duration) string {
+	switch {
+	case horizon%time.Hour == 0:
+		return fmt.Sprintf("%dh", horizon/time.Hour)
+	case horizon%time.Minute == 0:
+		return fmt.Sprintf("%dm", horizon/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", horizon/time.Second)
+	}
+}
+
+// getHorizonStatistic returns the name of the statistic holding the VWAP over
+// the given horizon, e.g. "vwap5m".
+func getHorizonStatistic(horizon time.Duration) string {
+	return "vwap" + formatHorizon(horizon)
+}
+
+// validateHorizons checks that the given VWAP horizons are positive whole
+// seconds, and have distinct names.
+func validateHorizons(horizons []time.Duration) error {
+	names := make(map[string]bool, len(horizons))
+	for _, horizon := range horizons {
+		if horizon <= 0 {
+			return errors.New("invalid non-positive VWAP horizon")
+		}
+
+		if horizon%time.Second != 0 {
+			return fmt.Errorf("invalid VWAP horizon %v, must be whole "+
+				"seconds", horizon)
+		}
+
+		name := formatHorizon(horizon)
+		if names[name] {
+			return fmt.Errorf("duplicate VWAP horizon %q", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// getHorizonWindows returns the horizon windows of the given reported pair,
+// one for each of the engine's VWAP horizons. If none are found, they are
+// created and stored in the engine.
+func (e *Engine) getHorizonWindows(pair string) []*horizonWindow {
+	windows, hasWindows := e.horizonWindows[pair]
+	if !hasWindows {
+		for _, horizon := range e.vwapHorizons {
+			windows = append(windows, newHorizonWindow(horizon))
+		}
+		e.horizonWindows[pair] = windows
+	}
+	return windows
+}
+
+// addHorizonMatch adds the given match, already mapped to its reported pair,
+// to the horizon windows of the pair. As for bars, matches from excluded
+// venues and past trades reported by last_match messages are left out.
+func (e *Engine) addHorizonMatch(match feed.Match) {
+	if len(e.vwapHorizons) == 0 || match.IsLast ||
+		e.isExcludedVenue(match.Venue) {
+		return
+	}
+
+	for _, window := range e.getHorizonWindows(match.ProductID) {
+		window.addMatch(match)
+	}
+}
+
+// advanceHorizons moves the end of the horizon windows of the given reported
+// pair to the given exchange time.
+func (e *Engine) advanceHorizons(pair string, t time.Time) {
+	for _, window := range e.horizonWindows[pair] {
+		window.advance(t)
+	}
+}
+
+// getHorizonVWAPs returns the VWAP of the given reported pair over each of the
+// engine's horizons, keyed by the name of its statistic, or nil if there are
+// no horizons.
+func (e *Engine) getHorizonVWAPs(pair string) map[string]float64 {
+	if len(e.vwapHorizons) == 0 {
+		return nil
+	}
+
+	vwaps := make(map[string]float64, len(e.vwapHorizons))
+	for _, horizon := range e.vwapHorizons {
+		vwaps[getHorizonStatistic(horizon)] = 0
+	}
+	for _, window := range e.horizonWindows[pair] {
+		vwaps[getHorizonStatistic(window.horizon)] = window.sums.getVWAP()
+	}
+	return vwaps
+}
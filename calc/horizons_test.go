@@ -0,0 +1,125 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_horizonWindow(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	window := newHorizonWindow(time.Minute)
+
+	window.addMatch(feed.Match{Price: 10, Size: 1, Time: start})
+	window.addMatch(feed.Match{Price: 20, Size: 3,
+		Time: start.Add(30 * time.Second)})
+	assert.Equal(t, 17.5, window.sums.getVWAP(), "Got wrong VWAP")
+
+	// The first match leaves the window a minute after it.
+	window.advance(start.Add(time.Minute))
+	assert.Equal(t, 20.0, window.sums.getVWAP(),
+		"Got wrong VWAP after eviction")
+
+	// Earlier times do not move the window back.
+	window.advance(start)
+	assert.Equal(t, start.Add(time.Minute), window.latest,
+		"Got wrong end of the window")
+
+	window.advance(start.Add(2 * time.Minute))
+	assert.Equal(t, 0, len(window.matches), "Got matches out of the window")
+	assert.Equal(t, vwapSums{}, window.sums, "Got sums with no matches")
+}
+
+func Test_validateHorizons(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		horizons      []time.Duration
+		expectedError error
+	}{
+		{
+			desc:          "zero horizon",
+			horizons:      []time.Duration{0},
+			expectedError: errors.New("invalid non-positive VWAP horizon"),
+		},
+		{
+			desc:     "fractional seconds",
+			horizons: []time.Duration{1500 * time.Millisecond},
+			expectedError: errors.New("invalid VWAP horizon 1.5s, must be " +
+				"whole seconds"),
+		},
+		{
+			desc:          "duplicate horizons",
+			horizons:      []time.Duration{time.Hour, 60 * time.Minute},
+			expectedError: errors.New("duplicate VWAP horizon \"1h\""),
+		},
+		{
+			desc: "valid horizons",
+			horizons: []time.Duration{30 * time.Second, 5 * time.Minute,
+				90 * time.Minute, 24 * time.Hour},
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedError, validateHorizons(tc.horizons),
+			"For test %q, got unexpected error value", tc.desc)
+	}
+
+	var names []string
+	for _, horizon := range testCases[3].horizons {
+		names = append(names, getHorizonStatistic(horizon))
+	}
+	assert.Equal(t, []string{"vwap30s", "vwap5m", "vwap90m", "vwap24h"},
+		names, "Got wrong statistic names")
+}
+
+func Test_EngineHorizons(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs: []string{"BTC-USD", "ETH-USD", "ETH-BTC"},
+		WindowSize:   10,
+		VWAPHorizons: []time.Duration{5 * time.Minute, time.Hour},
+		CrossRates:   []CrossRate{{Pair: "ETH-BTC", Via: "USD"}},
+		Series: []Series{
+			{Name: "momentum", Expression: "(BTC-USD.vwap5m - " +
+				"BTC-USD.vwap1h) / BTC-USD.vwap1h"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 39000, ProductID: "BTC-USD", Size: 1, IsLast: true,
+			Time: start.Add(-2 * time.Hour)},
+		{Price: 40000, ProductID: "BTC-USD", Size: 1, Time: start},
+		{Price: 41000, ProductID: "BTC-USD", Size: 1,
+			Time: start.Add(30 * time.Minute)},
+		{Price: 2000, ProductID: "ETH-USD", Size: 1,
+			Time: start.Add(30 * time.Minute)},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	// The heartbeat moves the 5 minute window past the matches.
+	engine.handleUpdate(feed.Update{Heartbeats: []feed.Heartbeat{
+		{ProductID: "BTC-USD", Time: start.Add(40 * time.Minute)},
+	}})
+
+	snapshot := engine.Snapshot()
+	assert.Equal(t, map[time.Duration]float64{
+		5 * time.Minute: 0,
+		time.Hour:       40500,
+	}, snapshot.Pairs["BTC-USD"].HorizonVWAPs, "Got wrong horizon VWAPs")
+	assert.Equal(t, map[string]float64{"momentum": -1}, snapshot.Series,
+		"Got wrong series")
+	assert.Equal(t, []CrossRateSnapshot{
+		{
+			CrossRate: CrossRate{Pair: "ETH-BTC", Via: "USD"},
+			Implied:   0.05,
+		},
+	}, snapshot.CrossRates, "Got wrong cross rates")
+}
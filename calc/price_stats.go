@@ -0,0 +1,148 @@
+package calc
+
+import "sort"
+
+// Number of price statistics logged for each trading pair: the high, the low
+// and the volume-weighted median.
+const priceStatValues int = 3
+
+// pricePoint is the price of a match in a sliding window, along with the
+// sequence number of the match in the window.
+type pricePoint struct {
+	seq   int64
+	price float64
+}
+
+// priceDeque is a monotonic deque of the prices in a sliding window, whose
+// front is the high, or the low, of the window. Prices are pushed at the back,
+// dropping the ones that can no longer become the front, since a more recent
+// match has a higher, or lower, price. The front is evicted when its match
+// leaves the window. Each price is pushed and dropped at most once, so both
+// operations take O(1) amortized time.
+type priceDeque struct {
+	points []pricePoint
+
+	// Indicates if the front is the highest price, rather than the lowest.
+	isHigh bool
+}
+
+// push adds the given price at the back of the deque.
+func (d *priceDeque) push(point pricePoint) {
+	for len(d.points) > 0 {
+		back := d.points[len(d.points)-1].price
+		if (d.isHigh && back > point.price) ||
+			(!d.isHigh && back < point.price) {
+			break
+		}
+		d.points = d.points[:len(d.points)-1]
+	}
+	d.points = append(d.points, point)
+}
+
+// evict removes the price of the match with the given sequence number, which
+// is leaving the window, if it is at the front of the deque. Otherwise, it was
+// already dropped.
+func (d *priceDeque) evict(seq int64) {
+	if len(d.points) > 0 && d.points[0].seq == seq {
+		d.points = d.points[1:]
+	}
+}
+
+// front returns the highest, or lowest, price in the deque, or 0 if it is
+// empty.
+func (d *priceDeque) front() float64 {
+	if len(d.points) == 0 {
+		return 0
+	}
+	return d.points[0].price
+}
+
+// weightedPrice is a price along with the size traded at it.
+type weightedPrice struct {
+	price, size float64
+}
+
+// insertWeightedPrice adds the given price to the slice, which is sorted by
+// price, and returns it.
+func insertWeightedPrice(
+	prices []weightedPrice, price weightedPrice,
+) []weightedPrice {
+	i := sort.Search(len(prices), func(i int) bool {
+		return prices[i].price > price.price
+	})
+
+	prices = append(prices, weightedPrice{})
+	copy(prices[i+1:], prices[i:])
+	prices[i] = price
+	return prices
+}
+
+// removeWeightedPrice removes the given price from the slice, which is sorted
+// by price, and returns it. The slice is returned as is if the price is not
+// found.
+func removeWeightedPrice(
+	prices []weightedPrice, price weightedPrice,
+) []weightedPrice {
+	i := sort.Search(len(prices), func(i int) bool {
+		return prices[i].price >= price.price
+	})
+
+	for ; i < len(prices) && prices[i].price == price.price; i++ {
+		if prices[i].size == price.size {
+			return append(prices[:i], prices[i+1:]...)
+		}
+	}
+	return prices
+}
+
+// getWeightedMedian returns the volume-weighted median of the given prices,
+// sorted by price, which is the lowest price at which the cumulative size
+// reaches half of the total size. It is 0 if there is no size.
+func getWeightedMedian(prices []weightedPrice) float64 {
+	var totalSize float64
+	for _, price := range prices {
+		totalSize += price.size
+	}
+
+	if totalSize <= 0 {
+		return 0
+	}
+
+	var cumulativeSize float64
+	for _, price := range prices {
+		cumulativeSize += price.size
+		if cumulativeSize >= totalSize/2 {
+			return price.price
+		}
+	}
+	return prices[len(prices)-1].price
+}
+
+// getHighLow returns the given high and low, extended with the high and low of
+// a window. Zero values are ignored, since they come from windows that do not
+// keep their prices.
+func getHighLow(high, low, windowHigh, windowLow float64) (float64, float64) {
+	if windowHigh > 0 && (high == 0 || windowHigh > high) {
+		high = windowHigh
+	}
+	if windowLow > 0 && (low == 0 || windowLow < low) {
+		low = windowLow
+	}
+	return high, low
+}
+
+// getPriceStatsLogFormat returns the format string to use when printing the
+// price statistics of a trading pair.
+func getPriceStatsLogFormat() string {
+	return ", high: %f, low: %f, median: %f"
+}
+
+// setPriceStatValues sets the logged price statistics of the given reported
+// pair in the given slice.
+func (e *Engine) setPriceStatValues(
+	pair string, stats pairStats, values []interface{},
+) {
+	values[0] = e.getLoggedPrice(pair, stats.high)
+	values[1] = e.getLoggedPrice(pair, stats.low)
+	values[2] = e.getLoggedPrice(pair, stats.median)
+}
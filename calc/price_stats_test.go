@@ -0,0 +1,186 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getWeightedMedian(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		prices         []weightedPrice
+		expectedOutput float64
+	}{
+		{
+			desc:           "no prices",
+			expectedOutput: 0,
+		},
+		{
+			desc:           "single price",
+			prices:         []weightedPrice{{price: 10, size: 1}},
+			expectedOutput: 10,
+		},
+		{
+			desc: "block print",
+			prices: []weightedPrice{
+				{price: 10, size: 1}, {price: 11, size: 1},
+				{price: 50, size: 1.5},
+			},
+			expectedOutput: 11,
+		},
+		{
+			desc: "half of the size",
+			prices: []weightedPrice{
+				{price: 10, size: 1}, {price: 20, size: 1},
+			},
+			expectedOutput: 10,
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedOutput, getWeightedMedian(tc.prices),
+			"For test %q, got wrong median", tc.desc)
+	}
+}
+
+func Test_getConsolidatedMedian(t *testing.T) {
+	venue1 := []weightedPrice{{price: 10, size: 1}, {price: 30, size: 3}}
+	venue2 := []weightedPrice{{price: 20, size: 1}}
+
+	assert.Equal(t, 30.0, getConsolidatedMedian(
+		[][]weightedPrice{venue1}, []float64{4}, []float64{1}),
+		"Got wrong median for a single venue")
+
+	// The second venue weighs as much as the first one, despite its lower
+	// volume.
+	assert.Equal(t, 20.0, getConsolidatedMedian(
+		[][]weightedPrice{venue1, venue2}, []float64{4, 1},
+		[]float64{0.5, 0.5}), "Got wrong median for several venues")
+}
+
+func Test_removeWeightedPrice(t *testing.T) {
+	prices := []weightedPrice{
+		{price: 10, size: 1}, {price: 10, size: 2}, {price: 20, size: 1},
+	}
+
+	prices = removeWeightedPrice(prices, weightedPrice{price: 10, size: 3})
+	assert.Equal(t, 3, len(prices), "Removed a price that is not found")
+
+	prices = removeWeightedPrice(prices, weightedPrice{price: 10, size: 2})
+	assert.Equal(t, []weightedPrice{{price: 10, size: 1}, {price: 20, size: 1}},
+		prices, "Got wrong prices after removing one")
+}
+
+func Test_slidingWindowPriceStats(t *testing.T) {
+	const windowSize = 5
+
+	// The statistics are checked against the matches in the window after
+	// every match.
+	random := rand.New(rand.NewSource(1))
+	window := newSlidingWindow(windowSize)
+	window.keepSortedPrices = true
+	var matches []feed.Match
+	for i := 0; i < 200; i++ {
+		match := feed.Match{
+			Price: float64(random.Intn(20) + 1),
+			Size:  float64(random.Intn(3) + 1),
+		}
+		assert.Nil(t, window.addMatch(match), "Got error adding match")
+
+		matches = append(matches, match)
+		if len(matches) > windowSize {
+			matches = matches[1:]
+		}
+
+		var prices []weightedPrice
+		high, low := matches[0].Price, matches[0].Price
+		for _, m := range matches {
+			prices = append(prices,
+				weightedPrice{price: m.Price, size: m.Size})
+			if m.Price > high {
+				high = m.Price
+			}
+			if m.Price < low {
+				low = m.Price
+			}
+		}
+		sort.Slice(prices, func(i, j int) bool {
+			return prices[i].price < prices[j].price
+		})
+
+		assert.Equal(t, high, window.getHigh(), "Got wrong high at %d", i)
+		assert.Equal(t, low, window.getLow(), "Got wrong low at %d", i)
+		assert.Equal(t, getWeightedMedian(prices),
+			getWeightedMedian(window.getSortedPrices()),
+			"Got wrong median at %d", i)
+		assert.LessOrEqual(t, len(window.highs.points), windowSize,
+			"Got too many highs at %d", i)
+	}
+}
+
+func Test_getHighLow(t *testing.T) {
+	high, low := getHighLow(0, 0, 12, 10)
+	high, low = getHighLow(high, low, 0, 0)
+	high, low = getHighLow(high, low, 15, 11)
+	assert.Equal(t, 15.0, high, "Got wrong high")
+	assert.Equal(t, 10.0, low, "Got wrong low")
+}
+
+func Test_EnginePriceStats(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		windowType     WindowType
+		expectedError  error
+		expectedOutput string
+	}{
+		{
+			desc:       "EWMA windows",
+			windowType: WindowEWMA,
+			expectedError: errors.New("price statistics require " +
+				"\"sliding\" windows"),
+		},
+		{
+			desc:       "sliding windows",
+			windowType: WindowSliding,
+			expectedOutput: "\"BTC-USD\": 40100.000000 (buy: 40100.000000, " +
+				"sell: 0.000000, imbalance: 1.000000, last_match: 0.000000, " +
+				"high: 40400.000000, low: 40000.000000, median: 40000.000000)",
+		},
+	}
+
+	for _, tc := range testCases {
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs:   []string{"BTC-USD"},
+			WindowType:     tc.windowType,
+			WindowSize:     2,
+			HalfLifeTrades: 10,
+			PriceStats:     true,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		// The first match leaves the window.
+		matches := []feed.Match{
+			{Price: 50000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+			{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 3},
+			{Price: 40400, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		}
+		for _, match := range matches {
+			engine.handleMatch(match)
+		}
+
+		assert.Equal(t, tc.expectedOutput, engine.getVWAPLog(),
+			"For test %q, got unexpected VWAP log", tc.desc)
+	}
+}
@@ -0,0 +1,162 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Series is a derived series, defined by an expression over the statistics of
+// the reported pairs, e.g. "(BTC-USD.vwap - ETH-USD.vwap) / BTC-USD.vwap", or
+// "(BTC-USD.vwap5m - BTC-USD.vwap1h) / BTC-USD.vwap1h" with VWAP horizons.
+type Series struct {
+	// Name the series is logged as.
+	Name string
+
+	// Expression to evaluate for the series.
+	Expression string
+}
+
+// series holds a derived series along with its parsed expression.
+type series struct {
+	name       string
+	expression expression
+}
+
+// pairStats holds the statistics of a reported pair, consolidated across
+// venues, which derived series are computed from.
+type pairStats struct {
+	vwap, buyVWAP, sellVWAP, imbalance, lastMatch, volume, twap, stdDev float64
+	high, low, median                                                   float64
+
+	// VWAP over each horizon, keyed by the name of its statistic.
+	horizonVWAPs map[string]float64
+}
+
+// Statistics of the reported pairs available in expressions.
+var pairStatistics = map[string]func(pairStats) float64{
+	"vwap":       func(s pairStats) float64 { return s.vwap },
+	"buy_vwap":   func(s pairStats) float64 { return s.buyVWAP },
+	"sell_vwap":  func(s pairStats) float64 { return s.sellVWAP },
+	"imbalance":  func(s pairStats) float64 { return s.imbalance },
+	"last_match": func(s pairStats) float64 { return s.lastMatch },
+	"volume":     func(s pairStats) float64 { return s.volume },
+	"twap":       func(s pairStats) float64 { return s.twap },
+	"std_dev":    func(s pairStats) float64 { return s.stdDev },
+	"high":       func(s pairStats) float64 { return s.high },
+	"low":        func(s pairStats) float64 { return s.low },
+	"median":     func(s pairStats) float64 { return s.median },
+}
+
+// getPairStats returns the statistics of the given reported pair, from its
+// consolidated calculation data.
+func (e *Engine) getPairStats(pair string, summary pairSummary) pairStats {
+	return pairStats{
+		vwap:      summary.vwap,
+		buyVWAP:   summary.buySums.getVWAP(),
+		sellVWAP:  summary.sellSums.getVWAP(),
+		imbalance: getImbalance(summary.buySums, summary.sellSums),
+		lastMatch: e.lastMatches[pair].Price,
+		volume:    summary.volume,
+		twap:      summary.twap,
+		stdDev:    summary.stdDev,
+		high:      summary.high,
+		low:       summary.low,
+		median:    summary.median,
+
+		horizonVWAPs: e.getHorizonVWAPs(pair),
+	}
+}
+
+// isPairStatistic indicates if the given name is a statistic of the reported
+// pairs.
+func isPairStatistic(name string) bool {
+	_, ok := pairStatistics[name]
+	return ok
+}
+
+// newSeries parses the expressions of the given series, which may also refer to
+// the VWAP over each of the given horizons.
+func newSeries(
+	definitions []Series, horizons []time.Duration,
+) ([]series, error) {
+	horizonStatistics := make(map[string]bool, len(horizons))
+	for _, horizon := range horizons {
+		horizonStatistics[getHorizonStatistic(horizon)] = true
+	}
+	isStatistic := func(name string) bool {
+		return isPairStatistic(name) || horizonStatistics[name]
+	}
+
+	parsedSeries := make([]series, 0, len(definitions))
+	names := make(map[string]bool, len(definitions))
+	for _, definition := range definitions {
+		if definition.Name == "" {
+			return nil, errors.New("empty series name")
+		}
+
+		if names[definition.Name] {
+			return nil, fmt.Errorf("duplicate series %q", definition.Name)
+		}
+		names[definition.Name] = true
+
+		expr, err := parseExpression(definition.Expression, isStatistic)
+		if err != nil {
+			return nil, fmt.Errorf("error creating series %q: %v",
+				definition.Name, err)
+		}
+
+		parsedSeries = append(parsedSeries, series{
+			name:       definition.Name,
+			expression: expr,
+		})
+	}
+	return parsedSeries, nil
+}
+
+// getSeriesLogFormat returns the format string to use when printing the given
+// series.
+func getSeriesLogFormat(derivedSeries []series) string {
+	formatString := ""
+	for _, s := range derivedSeries {
+		formatString += fmt.Sprintf(", %q: %%f", s.name)
+	}
+	return formatString
+}
+
+// getPairStatistic returns the given statistic of a reported pair. The
+// returned bool is false if the pair is not reported.
+func (e *Engine) getPairStatistic(pair, statistic string) (float64, bool) {
+	stats, ok := e.pairStats[pair]
+	if !ok {
+		return 0, false
+	}
+
+	if getStatistic, isPairStat := pairStatistics[statistic]; isPairStat {
+		return getStatistic(stats), true
+	}
+	return stats.horizonVWAPs[statistic], true
+}
+
+// setSeriesValues sets the logged values of each derived series in the given
+// slice, using the statistics of the reported pairs in pairStats.
+func (e *Engine) setSeriesValues(values []interface{}) {
+	for i, s := range e.series {
+		values[i] = s.expression.evaluate(e.getPairStatistic)
+	}
+}
+
+// getSeriesValues returns the value of each derived series, keyed by its name,
+// using the statistics of the reported pairs in pairStats, or nil if there
+// are no series.
+func (e *Engine) getSeriesValues() map[string]float64 {
+	if len(e.series) == 0 {
+		return nil
+	}
+
+	values := make(map[string]float64, len(e.series))
+	for _, s := range e.series {
+		values[s.name] = s.expression.evaluate(e.getPairStatistic)
+	}
+	return values
+}
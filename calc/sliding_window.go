@@ -2,6 +2,7 @@ package calc
 
 import (
 	"errors"
+	"time"
 
 	"github.com/ha2398/vwap/feed"
 )
@@ -19,6 +20,9 @@ import (
 //
 // In both these cases, the update data is added to the queue, so that the
 // sliding window keeps moving when needed.
+//
+// Partial sums are also kept separately for taker buy and taker sell flow, so
+// that a VWAP for each side can be reported for the trades in the window.
 type slidingWindow struct {
 	// Queue of partial VWAP calculation data.
 	data chan vwapPartialData
@@ -35,6 +39,38 @@ type slidingWindow struct {
 	// Current sums used in the VWAP calculation.
 	// VWAP is vwapNumerator / vwapDenominator
 	vwapNumerator, vwapDenominator float64
+
+	// Current sums used in the variance calculation, shifted by a price
+	// close to the VWAP.
+	variance varianceSums
+
+	// Number of matches added since the sums were last recomputed from the
+	// matches in the window.
+	addedSinceRecompute int
+
+	// Monotonic deques for the high and low prices in the window, and the
+	// prices in the window sorted by value, for the volume-weighted median.
+	// The sorted prices are only kept if keepSortedPrices is set, since each
+	// update takes linear time.
+	highs, lows      priceDeque
+	sortedPrices     []weightedPrice
+	keepSortedPrices bool
+
+	// Sequence number of the next match added to the window.
+	nextSeq int64
+
+	// Volume at each price bucket in the window, if kept for its pair.
+	profile *volumeProfile
+
+	// Partial sums restricted to taker buy and taker sell matches.
+	buySums, sellSums vwapSums
+
+	// Time-weighted sums of the prices held in the window, for the TWAP.
+	twapSums twapSums
+
+	// Indicates if the window only holds last_match seed data, when using
+	// the LastMatchSeed policy.
+	seed bool
 }
 
 func newSlidingWindow(size int) *slidingWindow {
@@ -45,8 +81,9 @@ func newSlidingWindow(size int) *slidingWindow {
 	}
 
 	return &slidingWindow{
-		data: make(chan vwapPartialData, size),
-		size: size,
+		data:  make(chan vwapPartialData, size),
+		size:  size,
+		highs: priceDeque{isHigh: true},
 	}
 }
 
@@ -54,8 +91,82 @@ func (w *slidingWindow) getVWAP() float64 {
 	return w.vwap
 }
 
+// getVolume returns the total size of the matches in the window.
+func (w *slidingWindow) getVolume() float64 {
+	return w.vwapDenominator
+}
+
+// getVariance returns the volume-weighted variance of the prices in the
+// window, around the VWAP.
+func (w *slidingWindow) getVariance() float64 {
+	return w.variance.getVariance(w.vwapDenominator)
+}
+
+// getHigh returns the highest price in the window, or 0 if it is empty.
+func (w *slidingWindow) getHigh() float64 {
+	return w.highs.front()
+}
+
+// getLow returns the lowest price in the window, or 0 if it is empty.
+func (w *slidingWindow) getLow() float64 {
+	return w.lows.front()
+}
+
+// getSortedPrices returns the prices in the window, sorted by value, along
+// with their sizes, or nil if they are not kept.
+func (w *slidingWindow) getSortedPrices() []weightedPrice {
+	return w.sortedPrices
+}
+
+// getVolumeProfile returns the volume profile of the window, or nil if it
+// does not keep one.
+func (w *slidingWindow) getVolumeProfile() *volumeProfile {
+	return w.profile
+}
+
+// getTakerSums returns the partial sums of taker buy and taker sell matches in
+// the window.
+func (w *slidingWindow) getTakerSums() (buySums, sellSums vwapSums) {
+	return w.buySums, w.sellSums
+}
+
+func (w *slidingWindow) isSeed() bool {
+	return w.seed
+}
+
+func (w *slidingWindow) setSeed() {
+	w.seed = true
+}
+
+// getTWAP returns the TWAP of the window at the given time.
+func (w *slidingWindow) getTWAP(t time.Time) float64 {
+	return w.twapSums.getTWAP(t)
+}
+
+// getImbalance returns the imbalance between the volumes of the given taker
+// buy and taker sell sums, defined as (buyVolume - sellVolume) / (buyVolume +
+// sellVolume). It ranges from -1 (only taker sells) to 1 (only taker buys),
+// and is 0 if there is no sided volume.
+func getImbalance(buySums, sellSums vwapSums) float64 {
+	totalVolume := buySums.denominator + sellSums.denominator
+	if totalVolume <= 0 {
+		return 0
+	}
+
+	return (buySums.denominator - sellSums.denominator) / totalVolume
+}
+
 func (w *slidingWindow) addMatch(match feed.Match) error {
 	currentPartial := getVWAPPartialDataFromMatch(match)
+	currentPartial.seq = w.nextSeq
+	if w.nextSeq == 0 {
+		w.variance.shift = match.Price
+	}
+	w.nextSeq++
+
+	// The price of the previous match is held until this one, which must be
+	// accounted for before that match may be dropped.
+	w.twapSums.add(currentPartial.held)
 
 	if w.isWindowFull {
 		// If we enter this case, the sliding window is full. Hence, drop the
@@ -89,6 +200,14 @@ func (w *slidingWindow) addMatch(match feed.Match) error {
 		return errors.New("unable to send to data channel")
 	}
 
+	// Adding and subtracting matches accumulates rounding errors in the
+	// partial sums, so they are recomputed once all the matches in the window
+	// have been replaced.
+	w.addedSinceRecompute++
+	if w.addedSinceRecompute >= w.size {
+		w.recomputeSums()
+	}
+
 	// After updating the partial sums for VWAP calculation, update the VWAP
 	// value.
 	if w.vwapDenominator == 0 {
@@ -102,22 +221,132 @@ func (w *slidingWindow) addMatch(match feed.Match) error {
 func (w *slidingWindow) addPartial(partialData vwapPartialData) {
 	w.vwapNumerator += partialData.product
 	w.vwapDenominator += partialData.size
+	w.variance.add(partialData.price, partialData.size)
+
+	point := pricePoint{seq: partialData.seq, price: partialData.price}
+	w.highs.push(point)
+	w.lows.push(point)
+	if w.keepSortedPrices {
+		w.sortedPrices = insertWeightedPrice(w.sortedPrices,
+			weightedPrice{price: partialData.price, size: partialData.size})
+	}
+
+	if w.profile != nil {
+		w.profile.add(partialData.price, partialData.size)
+	}
+
+	if sums := w.getSideSums(partialData.takerSide); sums != nil {
+		sums.add(partialData)
+	}
 }
 
 func (w *slidingWindow) subtractPartial(partialData vwapPartialData) {
 	w.vwapNumerator -= partialData.product
 	w.vwapDenominator -= partialData.size
+	w.variance.subtract(partialData.price, partialData.size)
+	w.twapSums.subtract(partialData.held)
+
+	w.highs.evict(partialData.seq)
+	w.lows.evict(partialData.seq)
+	if w.keepSortedPrices {
+		w.sortedPrices = removeWeightedPrice(w.sortedPrices,
+			weightedPrice{price: partialData.price, size: partialData.size})
+	}
+
+	if w.profile != nil {
+		w.profile.subtract(partialData.price, partialData.size)
+	}
+
+	if sums := w.getSideSums(partialData.takerSide); sums != nil {
+		sums.subtract(partialData)
+	}
+}
+
+// recomputeSums recomputes the VWAP, variance and taker side sums from the
+// matches in the window, shifting the variance sums by the current VWAP.
+func (w *slidingWindow) recomputeSums() {
+	partials := make([]vwapPartialData, 0, len(w.data))
+	for len(w.data) > 0 {
+		partials = append(partials, <-w.data)
+	}
+
+	w.vwapNumerator, w.vwapDenominator = 0, 0
+	w.variance = varianceSums{shift: w.vwap}
+	w.buySums, w.sellSums = vwapSums{}, vwapSums{}
+	for _, partialData := range partials {
+		w.vwapNumerator += partialData.product
+		w.vwapDenominator += partialData.size
+		w.variance.add(partialData.price, partialData.size)
+		if sums := w.getSideSums(partialData.takerSide); sums != nil {
+			sums.add(partialData)
+		}
+		w.data <- partialData
+	}
+
+	w.addedSinceRecompute = 0
+}
+
+// getSideSums returns the partial sums for the given taker side, or nil if the
+// side is unknown.
+func (w *slidingWindow) getSideSums(takerSide string) *vwapSums {
+	switch takerSide {
+	case feed.BuySide:
+		return &w.buySums
+	case feed.SellSide:
+		return &w.sellSums
+	default:
+		return nil
+	}
 }
 
 // vwapPartialData holds a pair of values, the product (price_i * size_i) and
-// the size_i, for a given update i.
+// the size_i, for a given update i, along with the side of its taker order and
+// the price it held for the TWAP. The price_i and the sequence number of the
+// update in the window are kept for the variance, high, low and median.
 type vwapPartialData struct {
 	product, size float64
+	takerSide     string
+	held          *heldPrice
+	price         float64
+	seq           int64
 }
 
 func getVWAPPartialDataFromMatch(match feed.Match) vwapPartialData {
 	return vwapPartialData{
-		product: match.Price * match.Size,
-		size:    match.Size,
+		product:   match.Price * match.Size,
+		size:      match.Size,
+		takerSide: match.TakerSide(),
+		held:      newHeldPrice(match),
+		price:     match.Price,
+	}
+}
+
+// vwapSums holds the numerator and denominator of a VWAP calculation.
+type vwapSums struct {
+	numerator, denominator float64
+}
+
+func (s *vwapSums) add(partialData vwapPartialData) {
+	s.numerator += partialData.product
+	s.denominator += partialData.size
+}
+
+func (s *vwapSums) subtract(partialData vwapPartialData) {
+	s.numerator -= partialData.product
+	s.denominator -= partialData.size
+}
+
+// addSums adds the given sums to these ones.
+func (s *vwapSums) addSums(other vwapSums) {
+	s.numerator += other.numerator
+	s.denominator += other.denominator
+}
+
+// getVWAP returns the VWAP for the sums, or 0 if there is no volume.
+func (s *vwapSums) getVWAP() float64 {
+	if s.denominator <= 0 {
+		return 0
 	}
+
+	return s.numerator / s.denominator
 }
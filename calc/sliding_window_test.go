@@ -55,6 +55,8 @@ func Test_addMatch(t *testing.T) {
 		expectedVWAPNumerator   float64
 		expectedVWAPDenominator float64
 		expectedVWAP            float64
+		expectedBuySums         vwapSums
+		expectedSellSums        vwapSums
 	}{
 		{
 			desc: "window full, but no data available",
@@ -106,6 +108,43 @@ func Test_addMatch(t *testing.T) {
 			},
 			expectedError: errors.New("unable to calculate VWAP with zero denominator"),
 		},
+		{
+			desc: "window full, sided data",
+			window: &slidingWindow{
+				isWindowFull:    true,
+				size:            2,
+				currentLength:   2,
+				vwapNumerator:   14,
+				vwapDenominator: 5,
+				buySums:         vwapSums{numerator: 2, denominator: 1},
+				sellSums:        vwapSums{numerator: 12, denominator: 4},
+			},
+			enqueuedPartialData: []vwapPartialData{
+				vwapPartialData{
+					product:   2,
+					size:      1,
+					takerSide: feed.BuySide,
+				},
+				vwapPartialData{
+					product:   12,
+					size:      4,
+					takerSide: feed.SellSide,
+				},
+			},
+			match: feed.Match{
+				Price: 6,
+				Side:  feed.SellSide,
+				Size:  4,
+			},
+			expectedError:           nil,
+			expectWindowFull:        true,
+			expectedLength:          2,
+			expectedVWAPNumerator:   36,
+			expectedVWAPDenominator: 8,
+			expectedVWAP:            4.5,
+			expectedBuySums:         vwapSums{numerator: 24, denominator: 4},
+			expectedSellSums:        vwapSums{numerator: 12, denominator: 4},
+		},
 		{
 			desc: "window not full",
 			window: &slidingWindow{
@@ -161,5 +200,65 @@ func Test_addMatch(t *testing.T) {
 			"For test %q, got unexpected VWAP denominator", tc.desc)
 		assert.Equal(t, tc.expectedVWAP, tc.window.vwap,
 			"For test %q, got unexpected VWAP", tc.desc)
+		assert.Equal(t, tc.expectedBuySums, tc.window.buySums,
+			"For test %q, got unexpected buy sums", tc.desc)
+		assert.Equal(t, tc.expectedSellSums, tc.window.sellSums,
+			"For test %q, got unexpected sell sums", tc.desc)
+	}
+}
+
+func Test_getTakerSums(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		window            *slidingWindow
+		expectedBuyVWAP   float64
+		expectedSellVWAP  float64
+		expectedImbalance float64
+	}{
+		{
+			desc:              "no sided volume",
+			window:            &slidingWindow{},
+			expectedBuyVWAP:   0,
+			expectedSellVWAP:  0,
+			expectedImbalance: 0,
+		},
+		{
+			desc: "only buy volume",
+			window: &slidingWindow{
+				buySums: vwapSums{numerator: 30, denominator: 3},
+			},
+			expectedBuyVWAP:   10,
+			expectedSellVWAP:  0,
+			expectedImbalance: 1,
+		},
+		{
+			desc: "only sell volume",
+			window: &slidingWindow{
+				sellSums: vwapSums{numerator: 30, denominator: 3},
+			},
+			expectedBuyVWAP:   0,
+			expectedSellVWAP:  10,
+			expectedImbalance: -1,
+		},
+		{
+			desc: "buy and sell volume",
+			window: &slidingWindow{
+				buySums:  vwapSums{numerator: 33, denominator: 3},
+				sellSums: vwapSums{numerator: 9, denominator: 1},
+			},
+			expectedBuyVWAP:   11,
+			expectedSellVWAP:  9,
+			expectedImbalance: 0.5,
+		},
+	}
+
+	for _, tc := range testCases {
+		buySums, sellSums := tc.window.getTakerSums()
+		assert.Equal(t, tc.expectedBuyVWAP, buySums.getVWAP(),
+			"For test %q, got unexpected buy VWAP", tc.desc)
+		assert.Equal(t, tc.expectedSellVWAP, sellSums.getVWAP(),
+			"For test %q, got unexpected sell VWAP", tc.desc)
+		assert.Equal(t, tc.expectedImbalance, getImbalance(buySums, sellSums),
+			"For test %q, got unexpected imbalance", tc.desc)
 	}
 }
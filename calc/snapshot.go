@@ -0,0 +1,116 @@
+package calc
+
+import "time"
+
+// PairSnapshot holds the statistics of a reported pair, consolidated across
+// venues, at the time of a snapshot.
+type PairSnapshot struct {
+	VWAP      float64
+	BuyVWAP   float64
+	SellVWAP  float64
+	Imbalance float64
+	LastMatch float64 // Price of the most recent last_match.
+	Volume    float64 // Volume in the windows of the included venues.
+	TWAP      float64
+	StdDev    float64 // Volume-weighted standard deviation around the VWAP.
+	High      float64
+	Low       float64
+	Median    float64 // Volume-weighted median price, with PriceStats.
+
+	// VWAP over each of the engine's horizons, across the included venues.
+	HorizonVWAPs map[time.Duration]float64
+
+	// Volume profile of the pair, if it has one.
+	Profile *VolumeProfile
+
+	// Indicates if any of the trading pairs reported as this pair is
+	// currently stale.
+	Stale bool
+
+	// Local time at which a match was last added to the windows of the pair,
+	// or the zero time if none was.
+	UpdatedAt time.Time
+}
+
+// Snapshot holds the statistics of all reported pairs at some point in time,
+// along with the cross rates and derived series computed from them.
+type Snapshot struct {
+	Time       time.Time
+	Pairs      map[string]PairSnapshot
+	CrossRates []CrossRateSnapshot
+	Series     map[string]float64
+}
+
+// Snapshot returns the current statistics of all reported pairs, for modules
+// that build on the engine, e.g. indexes.
+func (e *Engine) Snapshot() Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	snapshot := Snapshot{
+		Time:  now(),
+		Pairs: make(map[string]PairSnapshot, len(e.reportedPairs)),
+	}
+
+	for _, pair := range e.reportedPairs {
+		stats := e.getPairStats(pair, e.getPairSummary(pair))
+		e.pairStats[pair] = stats
+
+		snapshot.Pairs[pair] = PairSnapshot{
+			VWAP:      stats.vwap,
+			BuyVWAP:   stats.buyVWAP,
+			SellVWAP:  stats.sellVWAP,
+			Imbalance: stats.imbalance,
+			LastMatch: stats.lastMatch,
+			Volume:    stats.volume,
+			TWAP:      stats.twap,
+			StdDev:    stats.stdDev,
+			High:      stats.high,
+			Low:       stats.low,
+			Median:    stats.median,
+
+			HorizonVWAPs: e.getSnapshotHorizonVWAPs(stats),
+			Profile:      e.getVolumeProfile(pair),
+			UpdatedAt:    e.lastUpdates[pair],
+		}
+	}
+
+	// Cross rates and derived series use the statistics just computed.
+	for _, rate := range e.crossRates {
+		snapshot.CrossRates = append(snapshot.CrossRates,
+			e.getCrossRateSnapshot(rate))
+	}
+	snapshot.Series = e.getSeriesValues()
+
+	// Staleness is tracked for the trading pairs as subscribed to.
+	for _, pair := range e.tradingPairs {
+		activity, hasActivity := e.activity[pair]
+		if !hasActivity ||
+			!(activity.isHeartbeatStale || activity.isTradeStale) {
+			continue
+		}
+
+		reportedPair := e.symbols.mapProduct(pair)
+		pairSnapshot := snapshot.Pairs[reportedPair]
+		pairSnapshot.Stale = true
+		snapshot.Pairs[reportedPair] = pairSnapshot
+	}
+
+	return snapshot
+}
+
+// getSnapshotHorizonVWAPs returns the VWAPs over each of the engine's horizons
+// in the given statistics, keyed by horizon, or nil if there are no horizons.
+func (e *Engine) getSnapshotHorizonVWAPs(
+	stats pairStats,
+) map[time.Duration]float64 {
+	if len(e.vwapHorizons) == 0 {
+		return nil
+	}
+
+	vwaps := make(map[time.Duration]float64, len(e.vwapHorizons))
+	for _, horizon := range e.vwapHorizons {
+		vwaps[horizon] = stats.horizonVWAPs[getHorizonStatistic(horizon)]
+	}
+	return vwaps
+}
@@ -0,0 +1,68 @@
+// +build unit
+
+package calc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EngineSnapshot(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return start }
+
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:     []string{"BTC-USD", "BTC-USDT", "ETH-USD"},
+		WindowSize:       10,
+		HeartbeatTimeout: 5 * time.Second,
+		SymbolMapping: SymbolMapping{
+			QuoteEquivalents: map[string]string{"USDT": "USD"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 40300, ProductID: "BTC-USDT", Side: feed.BuySide, Size: 3},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	// Only the trading pair merged into BTC-USD goes stale.
+	engine.recordHeartbeat("BTC-USD", start.Add(8*time.Second))
+	engine.recordHeartbeat("ETH-USD", start.Add(8*time.Second))
+	engine.checkStaleness(start.Add(10 * time.Second))
+
+	// The standard deviation is subject to rounding errors.
+	snapshot := engine.Snapshot()
+	pairSnapshot := snapshot.Pairs["BTC-USD"]
+	assert.InDelta(t, math.Sqrt(16875), pairSnapshot.StdDev, 1e-6,
+		"Got wrong standard deviation")
+	pairSnapshot.StdDev = 0
+	snapshot.Pairs["BTC-USD"] = pairSnapshot
+
+	assert.Equal(t, Snapshot{
+		Time: start,
+		Pairs: map[string]PairSnapshot{
+			"BTC-USD": {
+				VWAP:      40225,
+				BuyVWAP:   40000,
+				SellVWAP:  40300,
+				Imbalance: -0.5,
+				Volume:    4,
+				TWAP:      40300,
+				High:      40300,
+				Low:       40000,
+				Stale:     true,
+				UpdatedAt: start,
+			},
+			"ETH-USD": {},
+		},
+	}, snapshot, "Got unexpected snapshot")
+}
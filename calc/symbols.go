@@ -0,0 +1,131 @@
+package calc
+
+import (
+	"sort"
+	"strings"
+)
+
+// SymbolMapping renames the products reported by the venues before their
+// matches are added to the windows, so that the same market is reported under
+// a single product ID.
+type SymbolMapping struct {
+	// Product IDs to rename as a whole, e.g. "XBT-USD" to "BTC-USD". They
+	// are renamed before any asset alias or quote equivalence is applied.
+	Products map[string]string
+
+	// Asset symbols to rename in both the base and the quote of products,
+	// e.g. "XBT" to "BTC".
+	Assets map[string]string
+
+	// Quote currencies to merge into an equivalent one, e.g. "USDC" and
+	// "USDT" to "USD". Matches for merged products share the same windows.
+	QuoteEquivalents map[string]string
+}
+
+// mapProduct returns the product ID the given one is reported as.
+func (m SymbolMapping) mapProduct(id string) string {
+	if renamed, ok := m.Products[id]; ok {
+		id = renamed
+	}
+
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return id
+	}
+
+	base, quote := parts[0], parts[1]
+	if alias, ok := m.Assets[base]; ok {
+		base = alias
+	}
+	if alias, ok := m.Assets[quote]; ok {
+		quote = alias
+	}
+	if equivalent, ok := m.QuoteEquivalents[quote]; ok {
+		quote = equivalent
+	}
+
+	return base + "-" + quote
+}
+
+// ReportedPair returns the product ID the given trading pair is reported as,
+// e.g. in snapshots.
+func (m SymbolMapping) ReportedPair(pair string) string {
+	return m.mapProduct(pair)
+}
+
+// getReportedPairs returns the product IDs the given trading pairs are
+// reported as, in the same order and without duplicates.
+func (m SymbolMapping) getReportedPairs(tradingPairs []string) []string {
+	reportedPairs := make([]string, 0, len(tradingPairs))
+	for _, pair := range tradingPairs {
+		reportedPair := m.mapProduct(pair)
+		if !containsString(reportedPairs, reportedPair) {
+			reportedPairs = append(reportedPairs, reportedPair)
+		}
+	}
+	return reportedPairs
+}
+
+// getRawPairs returns the product IDs which are reported as the given pair,
+// i.e. the names a venue may list it under. Product renames come first, then
+// the IDs using the symbols of the given trading pairs, e.g. "XBT-USD" for
+// "BTC-USD" if "XBT" is used by them, and then the reported pair itself.
+func (m SymbolMapping) getRawPairs(
+	reportedPair string, tradingPairs []string,
+) []string {
+	var rawPairs []string
+	addRawPair := func(id string) {
+		if m.mapProduct(id) == reportedPair &&
+			!containsString(rawPairs, id) {
+			rawPairs = append(rawPairs, id)
+		}
+	}
+
+	renamed := make([]string, 0, len(m.Products))
+	for id := range m.Products {
+		renamed = append(renamed, id)
+	}
+	sort.Strings(renamed)
+	for _, id := range renamed {
+		addRawPair(id)
+	}
+
+	parts := strings.SplitN(reportedPair, "-", 2)
+	if len(parts) != 2 {
+		addRawPair(reportedPair)
+		return rawPairs
+	}
+
+	// Symbols used by the trading pairs are tried first, then the reported
+	// ones, and then the other symbols known to the mapping.
+	var symbols []string
+	addSymbols := func(newSymbols ...string) {
+		for _, symbol := range newSymbols {
+			if !containsString(symbols, symbol) {
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+
+	for _, pair := range tradingPairs {
+		addSymbols(strings.SplitN(pair, "-", 2)...)
+	}
+	addSymbols(parts...)
+
+	var known []string
+	for symbol, alias := range m.Assets {
+		known = append(known, symbol, alias)
+	}
+	for quote, equivalent := range m.QuoteEquivalents {
+		known = append(known, quote, equivalent)
+	}
+	sort.Strings(known)
+	addSymbols(known...)
+
+	for _, base := range symbols {
+		for _, quote := range symbols {
+			addRawPair(base + "-" + quote)
+		}
+	}
+	return rawPairs
+}
@@ -0,0 +1,109 @@
+// +build unit
+
+package calc
+
+import (
+	"testing"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSymbolMapping SymbolMapping = SymbolMapping{
+	Products:         map[string]string{"BTCUSD-PERP": "BTC-PERP"},
+	Assets:           map[string]string{"XBT": "BTC", "XDG": "DOGE"},
+	QuoteEquivalents: map[string]string{"USDC": "USD", "USDT": "USD"},
+}
+
+func Test_mapProduct(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		mapping        SymbolMapping
+		productID      string
+		expectedOutput string
+	}{
+		{
+			desc:           "empty mapping",
+			mapping:        SymbolMapping{},
+			productID:      "XBT-USDT",
+			expectedOutput: "XBT-USDT",
+		},
+		{
+			desc:           "unmapped product",
+			mapping:        testSymbolMapping,
+			productID:      "ETH-BTC",
+			expectedOutput: "ETH-BTC",
+		},
+		{
+			desc:           "base alias",
+			mapping:        testSymbolMapping,
+			productID:      "XBT-EUR",
+			expectedOutput: "BTC-EUR",
+		},
+		{
+			desc:           "quote alias",
+			mapping:        testSymbolMapping,
+			productID:      "XDG-XBT",
+			expectedOutput: "DOGE-BTC",
+		},
+		{
+			desc:           "equivalent quote",
+			mapping:        testSymbolMapping,
+			productID:      "XBT-USDT",
+			expectedOutput: "BTC-USD",
+		},
+		{
+			desc:           "renamed product",
+			mapping:        testSymbolMapping,
+			productID:      "BTCUSD-PERP",
+			expectedOutput: "BTC-PERP",
+		},
+		{
+			desc:           "not a pair",
+			mapping:        testSymbolMapping,
+			productID:      "XBT",
+			expectedOutput: "XBT",
+		},
+	}
+
+	for _, tc := range testCases {
+		output := tc.mapping.mapProduct(tc.productID)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_getReportedPairs(t *testing.T) {
+	output := testSymbolMapping.getReportedPairs(
+		[]string{"BTC-USD", "ETH-BTC", "XBT-USDT", "ETH-USDC", "BTC-USDC"})
+	assert.Equal(t, []string{"BTC-USD", "ETH-BTC", "ETH-USD"}, output,
+		"Got wrong reported pairs")
+}
+
+func Test_EngineSymbolMapping(t *testing.T) {
+	engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+		TradingPairs:  []string{"BTC-USD", "XBT-USDT", "ETH-USDC"},
+		WindowSize:    10,
+		SymbolMapping: testSymbolMapping,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	matches := []feed.Match{
+		{Price: 10, ProductID: "BTC-USD", Side: feed.SellSide, Size: 1},
+		{Price: 20, ProductID: "XBT-USDT", Side: feed.SellSide, Size: 1},
+		{IsLast: true, Price: 5, ProductID: "ETH-USDC", Size: 2},
+		{Price: 30, ProductID: "BTC-USDT", Side: feed.SellSide, Size: 1},
+	}
+	for _, match := range matches {
+		engine.handleMatch(match)
+	}
+
+	assert.Equal(t,
+		"\"BTC-USD\": 15.000000 (buy: 15.000000, sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), "+
+			"\"ETH-USD\": 5.000000 (buy: 0.000000, sell: 0.000000, imbalance: 0.000000, last_match: 5.000000)",
+		engine.getVWAPLog(), "Got unexpected VWAP log")
+	assert.Equal(t, 1, engine.rejectedMatches,
+		"Got unexpected number of rejected matches")
+	assert.Equal(t, "ETH-USDC", engine.lastMatches["ETH-USD"].OriginalProductID,
+		"Got wrong original product for last_match")
+}
@@ -0,0 +1,288 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// TradingPairs returns the trading pairs the engine currently calculates VWAP
+// for.
+func (e *Engine) TradingPairs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.tradingPairs...)
+}
+
+// ValidateTradingPairs checks that the given trading pairs, along with the
+// pairs needed to convert them to the reporting currency, can be added to the
+// engine, using the pair validation function of each venue.
+func (e *Engine) ValidateTradingPairs(pairs []string) error {
+	e.pairsMu.Lock()
+	defer e.pairsMu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.getNewTradingPairs(pairs)
+	return err
+}
+
+// AddTradingPairs subscribes to the given trading pairs on the live feed
+// connections of all venues, and starts calculating VWAP for them, along with
+// the pairs needed to convert them to the reporting currency. Pairs that are
+// already being tracked are ignored. The pairs are validated before
+// subscribing, and if subscribing fails on a venue, the venues already
+// subscribed to are unsubscribed from them. Matches keep being handled while
+// subscribing, and the ones for the new pairs are rejected until they are
+// added.
+func (e *Engine) AddTradingPairs(pairs []string) error {
+	e.pairsMu.Lock()
+	defer e.pairsMu.Unlock()
+
+	e.mu.Lock()
+	newPairs, err := e.getNewTradingPairs(pairs)
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(newPairs) == 0 {
+		return nil
+	}
+
+	for i, venue := range e.venues {
+		if err := e.getFeedConn(venue).Subscribe(newPairs); err != nil {
+			e.unsubscribeVenues(e.venues[:i], newPairs)
+			return fmt.Errorf("error subscribing to trading pairs %v on "+
+				"venue %q: %v", newPairs, venue.name, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.setTradingPairs(append(e.tradingPairs, newPairs...))
+	return nil
+}
+
+// getNewTradingPairs returns the given trading pairs, along with the pairs
+// needed to convert them to the reporting currency, that are not tracked yet.
+// An error is returned if any of them is rejected by a venue.
+func (e *Engine) getNewTradingPairs(pairs []string) ([]string, error) {
+	// The current trading pairs are included, so that inverted conversion
+	// pairs among them are used.
+	allPairs := append(append([]string(nil), e.tradingPairs...), pairs...)
+
+	var newPairs []string
+	for _, pair := range WithConversionPairs(allPairs, e.reportingCurrency,
+		e.symbols, e.getPairValidation()) {
+		if pair == "" {
+			return nil, errors.New("empty trading pair")
+		}
+
+		if !e.subscribedPairs[pair] && !containsString(newPairs, pair) {
+			newPairs = append(newPairs, pair)
+		}
+	}
+
+	if len(newPairs) == 0 {
+		return nil, nil
+	}
+
+	if err := e.validateVenuePairs(newPairs); err != nil {
+		return nil, err
+	}
+	return newPairs, nil
+}
+
+// validateVenuePairs checks the given trading pairs with the pair validation
+// function of each venue that has one.
+func (e *Engine) validateVenuePairs(pairs []string) error {
+	for _, venue := range e.venues {
+		if venue.validatePairs == nil {
+			continue
+		}
+
+		if err := venue.validatePairs(pairs); err != nil {
+			return fmt.Errorf("error validating trading pairs %v for "+
+				"venue %q: %v", pairs, venue.name, err)
+		}
+	}
+	return nil
+}
+
+// getPairValidation returns the function checking trading pairs with the
+// venues, or nil if no venue validates its pairs.
+func (e *Engine) getPairValidation() func(pairs []string) error {
+	for _, venue := range e.venues {
+		if venue.validatePairs != nil {
+			return e.validateVenuePairs
+		}
+	}
+	return nil
+}
+
+// unsubscribeVenues unsubscribes from the given trading pairs on the live feed
+// connections of the given venues, logging any error.
+func (e *Engine) unsubscribeVenues(venues []*venueFeed, pairs []string) {
+	for _, venue := range venues {
+		if err := e.getFeedConn(venue).Unsubscribe(pairs); err != nil {
+			log.Printf("Error unsubscribing from trading pairs %v on venue "+
+				"%q: %v", pairs, venue.name, err)
+		}
+	}
+}
+
+// subscribeVenues subscribes to the given trading pairs on the live feed
+// connections of the given venues, logging any error.
+func (e *Engine) subscribeVenues(venues []*venueFeed, pairs []string) {
+	for _, venue := range venues {
+		if err := e.getFeedConn(venue).Subscribe(pairs); err != nil {
+			log.Printf("Error subscribing to trading pairs %v on venue %q: "+
+				"%v", pairs, venue.name, err)
+		}
+	}
+}
+
+// RemoveTradingPairs unsubscribes from the given trading pairs on the live feed
+// connections of all venues, and drops all calculation data for them. Pairs
+// that are not being tracked are ignored. At least one trading pair must
+// remain, and pairs still used by a conversion, cross rate, derived series or
+// pinned pair cannot be removed. If unsubscribing fails on a venue, the venues
+// already unsubscribed from are subscribed to the pairs again.
+func (e *Engine) RemoveTradingPairs(pairs []string) error {
+	e.pairsMu.Lock()
+	defer e.pairsMu.Unlock()
+
+	e.mu.Lock()
+	removedPairs, err := e.getRemovedTradingPairs(pairs)
+	e.mu.Unlock()
+	if err != nil || len(removedPairs) == 0 {
+		return err
+	}
+
+	for i, venue := range e.venues {
+		if err := e.getFeedConn(venue).Unsubscribe(removedPairs); err != nil {
+			e.subscribeVenues(e.venues[:i], removedPairs)
+			return fmt.Errorf("error unsubscribing from trading pairs %v on "+
+				"venue %q: %v", removedPairs, venue.name, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var remainingPairs []string
+	for _, pair := range e.tradingPairs {
+		if !containsString(removedPairs, pair) {
+			remainingPairs = append(remainingPairs, pair)
+		}
+	}
+	e.setTradingPairs(remainingPairs)
+
+	// Matches for the removed pairs that are still in flight will be rejected
+	// as unexpected. Calculation data is kept for the reported pairs that
+	// other trading pairs are merged into.
+	for _, pair := range removedPairs {
+		delete(e.activity, pair)
+
+		reportedPair := e.symbols.mapProduct(pair)
+		if !containsString(e.reportedPairs, reportedPair) {
+			delete(e.windows, reportedPair)
+			delete(e.bars, reportedPair)
+			delete(e.horizonWindows, reportedPair)
+			delete(e.lastMatches, reportedPair)
+			delete(e.lastUpdates, reportedPair)
+		}
+	}
+
+	return nil
+}
+
+// getRemovedTradingPairs returns the given trading pairs that are tracked. An
+// error is returned if no trading pair would remain, or if a pair would no
+// longer be reported while still in use.
+func (e *Engine) getRemovedTradingPairs(pairs []string) ([]string, error) {
+	var removedPairs, remainingPairs []string
+	for _, pair := range e.tradingPairs {
+		if containsString(pairs, pair) {
+			removedPairs = append(removedPairs, pair)
+		} else {
+			remainingPairs = append(remainingPairs, pair)
+		}
+	}
+
+	if len(removedPairs) == 0 {
+		return nil, nil
+	}
+
+	if len(remainingPairs) == 0 {
+		return nil, errors.New("cannot remove all trading pairs")
+	}
+
+	// Trading pairs merged into a reported pair that remains can always be
+	// removed.
+	remainingReported := e.symbols.getReportedPairs(remainingPairs)
+	uses := e.getPairUses(remainingReported)
+	for _, pair := range e.reportedPairs {
+		if containsString(remainingReported, pair) {
+			continue
+		}
+
+		if use, inUse := uses[pair]; inUse {
+			return nil, fmt.Errorf("cannot remove trading pair %q, used by "+
+				"%s", pair, use)
+		}
+	}
+	return removedPairs, nil
+}
+
+// getPairUses returns what uses each reported pair, among the cross rates, the
+// derived series, the pinned pairs, and the conversions of the given remaining
+// reported pairs which would have no conversion pair left among them.
+func (e *Engine) getPairUses(remainingPairs []string) map[string]string {
+	uses := make(map[string]string)
+	for _, pair := range e.pinnedPairs {
+		uses[e.symbols.mapProduct(pair)] = "the pinned pairs"
+	}
+
+	for _, s := range e.series {
+		for _, pair := range getExpressionPairs(s.expression) {
+			uses[pair] = fmt.Sprintf("series %q", s.name)
+		}
+	}
+
+	for _, rate := range e.crossRates {
+		use := fmt.Sprintf("cross rate %q", rate.Pair)
+		uses[rate.basePair] = use
+		uses[rate.quotePair] = use
+	}
+
+	for _, pair := range remainingPairs {
+		conversionPair, _ := getConversionPair(pair, e.reportingCurrency,
+			e.reportedPairs)
+		if conversionPair == "" ||
+			!containsString(e.reportedPairs, conversionPair) {
+			continue
+		}
+
+		newPair, _ := getConversionPair(pair, e.reportingCurrency,
+			remainingPairs)
+		if !containsString(remainingPairs, newPair) {
+			uses[conversionPair] = fmt.Sprintf("the conversion of %q", pair)
+		}
+	}
+	return uses
+}
+
+// containsString indicates if the given slice contains the string s.
+func containsString(slice []string, s string) bool {
+	for _, element := range slice {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSinkServerHandler spins up a test server that reads and discards all the
+// messages it receives.
+func testSinkServerHandler(w http.ResponseWriter, r *http.Request) {
+	wsUpgrader := ws.Upgrader{}
+	c, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	defer c.Close()
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func Test_AddAndRemoveTradingPairs(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	testCases := []struct {
+		desc                 string
+		add                  []string
+		remove               []string
+		expectedTradingPairs []string
+		expectedError        error
+	}{
+		{
+			desc:                 "add nothing",
+			add:                  []string{},
+			expectedTradingPairs: []string{"pair1", "pair2"},
+			expectedError:        nil,
+		},
+		{
+			desc:                 "add empty pair",
+			add:                  []string{""},
+			expectedTradingPairs: []string{"pair1", "pair2"},
+			expectedError:        errors.New("empty trading pair"),
+		},
+		{
+			desc:                 "add new and existing pairs",
+			add:                  []string{"pair2", "pair3", "pair3"},
+			expectedTradingPairs: []string{"pair1", "pair2", "pair3"},
+			expectedError:        nil,
+		},
+		{
+			desc:                 "remove unknown pair",
+			remove:               []string{"pair4"},
+			expectedTradingPairs: []string{"pair1", "pair2"},
+			expectedError:        nil,
+		},
+		{
+			desc:                 "remove existing pair",
+			remove:               []string{"pair1", "pair4"},
+			expectedTradingPairs: []string{"pair2"},
+			expectedError:        nil,
+		},
+		{
+			desc:                 "remove all pairs",
+			remove:               []string{"pair1", "pair2"},
+			expectedTradingPairs: []string{"pair1", "pair2"},
+			expectedError:        errors.New("cannot remove all trading pairs"),
+		},
+	}
+
+	for _, tc := range testCases {
+		c, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+		if err != nil {
+			t.Fatalf("Error creating test feed subscription: %v", err)
+			return
+		}
+
+		engine, err := NewEngine([]Venue{{Conn: c}}, Config{
+			TradingPairs: []string{"pair1", "pair2"},
+			WindowSize:   10,
+		})
+		assert.Nil(t, err, "For test %q, got error creating engine", tc.desc)
+
+		// Add some calculation data for all the pairs.
+		for _, pair := range engine.TradingPairs() {
+			engine.handleMatch(feed.Match{
+				IsLast:    true,
+				Price:     10,
+				ProductID: pair,
+				Size:      1,
+			})
+		}
+
+		if tc.add != nil {
+			err = engine.AddTradingPairs(tc.add)
+		} else {
+			err = engine.RemoveTradingPairs(tc.remove)
+		}
+
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedTradingPairs, engine.TradingPairs(),
+			"For test %q, got unexpected trading pairs", tc.desc)
+		assert.Equal(t, len(tc.expectedTradingPairs)*vwapValuesPerPair,
+			len(engine.vwapValues),
+			"For test %q, vwapValues slice not updated correctly", tc.desc)
+		assert.Equal(t, getVWAPLogFormat(tc.expectedTradingPairs, nil, false,
+			false),
+			engine.vwapLogFormat,
+			"For test %q, VWAP log format not updated correctly", tc.desc)
+
+		for _, pair := range tc.remove {
+			if containsString(tc.expectedTradingPairs, pair) {
+				continue
+			}
+
+			assert.NotContains(t, engine.windows, pair,
+				"For test %q, window not removed for %q", tc.desc, pair)
+			assert.NotContains(t, engine.lastMatches, pair,
+				"For test %q, last_match not removed for %q", tc.desc, pair)
+		}
+
+		c.Close()
+	}
+}
+
+func Test_AddTradingPairsClosedConnection(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	c, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+
+	engine, err := NewEngine([]Venue{{Conn: c}}, Config{
+		TradingPairs: []string{"pair1"},
+		WindowSize:   10,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	c.Close()
+	err = engine.AddTradingPairs([]string{"pair2"})
+
+	assert.NotNil(t, err, "Expected error subscribing on closed connection")
+	assert.Equal(t, []string{"pair1"}, engine.TradingPairs(),
+		"Trading pairs changed despite subscription error")
+}
+
+func Test_AddTradingPairsValidation(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	c1, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+	defer c1.Close()
+
+	c2, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint,
+		Format: feed.KrakenFormat})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+
+	var validated [][]string
+	engine, err := NewEngine([]Venue{
+		{Conn: c1},
+		{
+			Conn: c2,
+			ValidatePairs: func(pairs []string) error {
+				validated = append(validated, pairs)
+				if containsString(pairs, "pair3") {
+					return errors.New("unknown products [pair3]")
+				}
+				return nil
+			},
+		},
+	}, Config{
+		TradingPairs: []string{"pair1"},
+		WindowSize:   10,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	expectedErr := errors.New("error validating trading pairs [pair2 pair3] " +
+		"for venue \"kraken\": unknown products [pair3]")
+	assert.Equal(t, expectedErr, engine.ValidateTradingPairs(
+		[]string{"pair1", "pair2", "pair3"}), "Got wrong validation error")
+	assert.Equal(t, expectedErr, engine.AddTradingPairs(
+		[]string{"pair2", "pair3"}), "Got wrong error adding invalid pairs")
+	assert.Equal(t, [][]string{{"pair2", "pair3"}, {"pair2", "pair3"}},
+		validated, "Got wrong validated pairs")
+
+	// Subscribing fails on the second venue, after the first one.
+	c2.Close()
+	err = engine.AddTradingPairs([]string{"pair2"})
+	assert.NotNil(t, err, "Expected error subscribing on closed connection")
+	assert.Equal(t, []string{"pair1"}, engine.TradingPairs(),
+		"Trading pairs changed despite subscription error")
+}
+
+func Test_RemoveMergedTradingPairs(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	c, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+	defer c.Close()
+
+	engine, err := NewEngine([]Venue{{Conn: c}}, Config{
+		TradingPairs: []string{"BTC-USD", "BTC-USDT", "ETH-USDT"},
+		WindowSize:   10,
+		Bars:         []BarSpec{{Kind: BarVolume, Threshold: 100}},
+		SymbolMapping: SymbolMapping{
+			QuoteEquivalents: map[string]string{"USDT": "USD"},
+		},
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	for _, pair := range engine.TradingPairs() {
+		engine.handleMatch(feed.Match{Price: 10, ProductID: pair, Size: 1})
+	}
+
+	err = engine.RemoveTradingPairs([]string{"BTC-USDT", "ETH-USDT"})
+	assert.Nil(t, err, "Got error removing trading pairs")
+
+	assert.Equal(t, []string{"BTC-USD"}, engine.reportedPairs,
+		"Got unexpected reported pairs")
+	assert.Contains(t, engine.windows, "BTC-USD",
+		"Window removed for merged pair")
+	assert.NotContains(t, engine.windows, "ETH-USD",
+		"Window not removed for removed pair")
+	assert.NotContains(t, engine.bars, "ETH-USD",
+		"Bars not removed for removed pair")
+}
+
+func Test_RemoveTradingPairsInUse(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	testCases := []struct {
+		desc          string
+		remove        []string
+		expectedError error
+	}{
+		{
+			desc:   "unused pair",
+			remove: []string{"LTC-USD"},
+		},
+		{
+			desc:          "pinned pair",
+			remove:        []string{"SOL-USD", "SOL-USDT"},
+			expectedError: errors.New("cannot remove trading pair \"SOL-USD\", used by the pinned pairs"),
+		},
+		{
+			desc:          "cross rate leg",
+			remove:        []string{"ETH-USD"},
+			expectedError: errors.New("cannot remove trading pair \"ETH-USD\", used by cross rate \"ETH-BTC\""),
+		},
+		{
+			desc:          "series variable",
+			remove:        []string{"DOGE-USD"},
+			expectedError: errors.New("cannot remove trading pair \"DOGE-USD\", used by series \"S\""),
+		},
+		{
+			desc:          "conversion pair",
+			remove:        []string{"EUR-USD"},
+			expectedError: errors.New("cannot remove trading pair \"EUR-USD\", used by the conversion of \"BTC-EUR\""),
+		},
+		{
+			desc:   "conversion pair along with the converted pair",
+			remove: []string{"EUR-USD", "BTC-EUR"},
+		},
+		{
+			desc:   "pair merged into a remaining pair",
+			remove: []string{"SOL-USDT"},
+		},
+	}
+
+	for _, tc := range testCases {
+		c, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+		if err != nil {
+			t.Fatalf("Error creating test feed subscription: %v", err)
+			return
+		}
+
+		engine, err := NewEngine([]Venue{{Conn: c}}, Config{
+			TradingPairs: []string{"BTC-USD", "ETH-USD", "SOL-USD",
+				"SOL-USDT", "DOGE-USD", "LTC-USD", "BTC-EUR", "EUR-USD"},
+			PinnedPairs: []string{"SOL-USDT"},
+			WindowSize:  10,
+			CrossRates:  []CrossRate{{Pair: "ETH-BTC", Via: "USD"}},
+			Series: []Series{
+				{Name: "S", Expression: "DOGE-USD.vwap * 2"},
+			},
+			ReportingCurrency: "USD",
+			SymbolMapping: SymbolMapping{
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+		})
+		assert.Nil(t, err, "For test %q, got error creating engine", tc.desc)
+
+		pairs := engine.TradingPairs()
+		err = engine.RemoveTradingPairs(tc.remove)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		if err != nil {
+			assert.Equal(t, pairs, engine.TradingPairs(),
+				"For test %q, trading pairs changed despite error", tc.desc)
+		}
+
+		c.Close()
+	}
+}
+
+func Test_RemoveTradingPairsClosedConnection(t *testing.T) {
+	// Spin up test server.
+	server := httptest.NewServer(http.HandlerFunc(testSinkServerHandler))
+	defer server.Close()
+	endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+	c1, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+	defer c1.Close()
+
+	c2, err := feed.CreateSubscription(feed.Config{Endpoint: endpoint,
+		Format: feed.KrakenFormat})
+	if err != nil {
+		t.Fatalf("Error creating test feed subscription: %v", err)
+		return
+	}
+
+	engine, err := NewEngine([]Venue{{Conn: c1}, {Conn: c2}}, Config{
+		TradingPairs: []string{"pair1", "pair2"},
+		WindowSize:   10,
+	})
+	assert.Nil(t, err, "Got error creating engine")
+
+	// Unsubscribing fails on the second venue, after the first one.
+	c2.Close()
+	err = engine.RemoveTradingPairs([]string{"pair2"})
+	assert.NotNil(t, err, "Expected error unsubscribing on closed connection")
+	assert.Equal(t, []string{"pair1", "pair2"}, engine.TradingPairs(),
+		"Trading pairs changed despite unsubscription error")
+}
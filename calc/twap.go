@@ -0,0 +1,100 @@
+package calc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// heldPrice is the price of a match, which is held from the time of the match
+// until the time of the next match in the same window.
+type heldPrice struct {
+	price float64
+	time  time.Time
+
+	// Time the price was held for, which is zero until the next match.
+	duration time.Duration
+}
+
+// newHeldPrice returns the price held from the given match, at its exchange
+// timestamp, or at the local time it was received if it has none.
+func newHeldPrice(match feed.Match) *heldPrice {
+	return &heldPrice{price: match.Price, time: getMatchTime(match)}
+}
+
+// twapSums holds the sums of a TWAP calculation, over the prices held in a
+// window. The TWAP is SUM_i(price_i * duration_i) / SUM_i(duration_i), where
+// the price of the most recent match is held until the time of the TWAP.
+type twapSums struct {
+	// Sums over the prices whose duration is known, in seconds.
+	numerator, denominator float64
+
+	// Price of the most recent match, if any.
+	last *heldPrice
+}
+
+// add holds the given price from now on, which ends the duration of the
+// previous one. Matches received out of order hold their price for no time.
+func (s *twapSums) add(held *heldPrice) {
+	if s.last != nil {
+		s.last.duration = getHeldDuration(s.last.time, held.time)
+		s.numerator += s.last.price * s.last.duration.Seconds()
+		s.denominator += s.last.duration.Seconds()
+	}
+	s.last = held
+}
+
+// subtract removes the given price, dropped from the window, from the sums.
+func (s *twapSums) subtract(held *heldPrice) {
+	if held == nil {
+		return
+	}
+
+	s.numerator -= held.price * held.duration.Seconds()
+	s.denominator -= held.duration.Seconds()
+}
+
+// getTWAP returns the TWAP at the given time, or 0 if no price was held. If
+// no time elapsed since the first price, the most recent price is returned.
+func (s *twapSums) getTWAP(t time.Time) float64 {
+	if s.last == nil {
+		return 0
+	}
+
+	open := getHeldDuration(s.last.time, t).Seconds()
+	numerator := s.numerator + s.last.price*open
+	denominator := s.denominator + open
+	if denominator <= 0 {
+		return s.last.price
+	}
+	return numerator / denominator
+}
+
+// getHeldDuration returns the time elapsed between from and to, or zero if to
+// is before from.
+func getHeldDuration(from, to time.Time) time.Duration {
+	if to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// getTWAPLogFormat returns the format string to use when printing the TWAP of
+// the given pairs.
+func getTWAPLogFormat(twapPairs []string) string {
+	formatString := ""
+	for _, pair := range twapPairs {
+		formatString += fmt.Sprintf(", %q TWAP: %%f", pair)
+	}
+	return formatString
+}
+
+// setTWAPValues sets the logged TWAP of each pair of interest in the given
+// slice, using the statistics of the reported pairs in pairStats. The TWAP of
+// a pair that is not reported is 0.
+func (e *Engine) setTWAPValues(values []interface{}) {
+	for i, pair := range e.twapPairs {
+		values[i] = e.getLoggedPrice(pair, e.pairStats[pair].twap)
+	}
+}
@@ -0,0 +1,162 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_twapSums(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc           string
+		prices         []heldPrice
+		dropped        int
+		at             time.Time
+		expectedOutput float64
+	}{
+		{
+			desc:           "no prices",
+			at:             start,
+			expectedOutput: 0,
+		},
+		{
+			desc:           "single price, no time elapsed",
+			prices:         []heldPrice{{price: 10, time: start}},
+			at:             start,
+			expectedOutput: 10,
+		},
+		{
+			desc: "prices weighted by time held",
+			prices: []heldPrice{
+				{price: 10, time: start},
+				{price: 20, time: start.Add(3 * time.Second)},
+			},
+			at:             start.Add(4 * time.Second),
+			expectedOutput: 12.5,
+		},
+		{
+			desc: "dropped price",
+			prices: []heldPrice{
+				{price: 10, time: start},
+				{price: 20, time: start.Add(3 * time.Second)},
+				{price: 40, time: start.Add(4 * time.Second)},
+			},
+			dropped:        1,
+			at:             start.Add(5 * time.Second),
+			expectedOutput: 30,
+		},
+		{
+			desc: "out of order price",
+			prices: []heldPrice{
+				{price: 10, time: start.Add(2 * time.Second)},
+				{price: 20, time: start},
+			},
+			at:             start.Add(4 * time.Second),
+			expectedOutput: 20,
+		},
+	}
+
+	for _, tc := range testCases {
+		var sums twapSums
+		held := make([]*heldPrice, len(tc.prices))
+		for i := range tc.prices {
+			held[i] = &tc.prices[i]
+			sums.add(held[i])
+		}
+
+		for i := 0; i < tc.dropped; i++ {
+			sums.subtract(held[i])
+		}
+
+		assert.InDelta(t, tc.expectedOutput, sums.getTWAP(tc.at), 1e-9,
+			"For test %q, got wrong TWAP", tc.desc)
+	}
+}
+
+func Test_addMatchTWAP(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	window := newSlidingWindow(2)
+
+	matches := []feed.Match{
+		{Price: 10, Size: 1, Time: start},
+		{Price: 20, Size: 1, Time: start.Add(2 * time.Second)},
+		{Price: 30, Size: 1, ReceivedAt: start.Add(3 * time.Second)},
+	}
+	for _, match := range matches {
+		assert.Nil(t, window.addMatch(match), "Got error adding match")
+	}
+
+	// The first match left the window, so only the prices of the last two
+	// are weighted.
+	assert.InDelta(t, 25, window.getTWAP(start.Add(4*time.Second)), 1e-9,
+		"Got wrong TWAP")
+}
+
+func Test_EngineTWAP(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc           string
+		twapPairs      []string
+		expectedError  error
+		expectedOutput string
+	}{
+		{
+			desc:          "empty pair",
+			twapPairs:     []string{""},
+			expectedError: errors.New("empty TWAP pair"),
+		},
+		{
+			desc:          "duplicate pair",
+			twapPairs:     []string{"BTC-USD", "BTC-USD"},
+			expectedError: errors.New("duplicate TWAP pair \"BTC-USD\""),
+		},
+		{
+			desc:      "TWAP of reported and unknown pairs",
+			twapPairs: []string{"BTC-USD", "ETH-USD"},
+			expectedOutput: "\"BTC-USD\": 40200.000000 (buy: 40200.000000, " +
+				"sell: 0.000000, imbalance: 1.000000, last_match: 0.000000), " +
+				"\"BTC-USD\" TWAP: 40300.000000, \"ETH-USD\" TWAP: 0.000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs: []string{"BTC-USD"},
+			WindowSize:   10,
+			TWAPPairs:    tc.twapPairs,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		matches := []feed.Match{
+			{Price: 40000, ProductID: "BTC-USD", Side: feed.SellSide,
+				Size: 1, Time: start},
+			{Price: 40400, ProductID: "BTC-USD", Side: feed.SellSide,
+				Size: 1, Time: start.Add(5 * time.Second)},
+		}
+		for _, match := range matches {
+			engine.handleMatch(match)
+		}
+
+		// The last price is held until the latest exchange time, from a
+		// heartbeat.
+		engine.handleUpdate(feed.Update{Heartbeats: []feed.Heartbeat{
+			{Time: start.Add(20 * time.Second)},
+		}})
+
+		assert.Equal(t, tc.expectedOutput, engine.getVWAPLog(),
+			"For test %q, got unexpected VWAP log", tc.desc)
+	}
+}
@@ -0,0 +1,231 @@
+package calc
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// Venue is a connection to the feed of a single trading venue, along with how
+// its trades count towards the consolidated VWAP.
+type Venue struct {
+	// Connection to the venue's feed. The venue is named after the
+	// connection, and names must be unique.
+	Conn *feed.Conn
+
+	// Function used to create a new subscription to the venue's feed for the
+	// given trading pairs, when the current connection fails. If nil, the
+	// engine stops reading from the venue when the connection fails.
+	Reconnect func(tradingPairs []string) (*feed.Conn, error)
+
+	// Indicates if the venue's trades are left out of the consolidated VWAP.
+	// The VWAP for the venue itself is still reported.
+	Excluded bool
+
+	// Maximum weight of the venue in the consolidated VWAP, between 0 and 1.
+	// The weight of a venue is otherwise its share of the volume of the
+	// included venues. No cap is applied if zero.
+	WeightCap float64
+
+	// Function used to check the trading pairs added at runtime before
+	// subscribing to them on the venue's feed, e.g. against the venue's
+	// product catalog. Pairs are not checked if nil.
+	ValidatePairs func(tradingPairs []string) error
+}
+
+// venueFeed holds the state of a venue in the engine.
+type venueFeed struct {
+	name          string
+	conn          *feed.Conn
+	reconnect     func(tradingPairs []string) (*feed.Conn, error)
+	validatePairs func(tradingPairs []string) error
+	excluded      bool
+	weightCap     float64
+}
+
+// pairSummary holds the calculation data for a trading pair, consolidated
+// across venues.
+type pairSummary struct {
+	// Consolidated VWAP, weighting the VWAP of each included venue, and the
+	// total volume of the included venues.
+	vwap, volume float64
+
+	// Consolidated TWAP, with the same weights as the VWAP.
+	twap float64
+
+	// Standard deviation of the prices of the included venues around the
+	// consolidated VWAP, with the same weights as the VWAP.
+	stdDev float64
+
+	// Highest and lowest prices across the included venues, and their
+	// volume-weighted median, with the same weights as the VWAP. The median
+	// is only calculated when the price statistics are logged.
+	high, low, median float64
+
+	// Sums of taker buy and taker sell matches across the included venues.
+	buySums, sellSums vwapSums
+
+	// VWAP of each venue, and its share of the volume of all venues, in the
+	// same order as the engine venues.
+	venueVWAPs, venueShares []float64
+}
+
+// getPairSummary returns the calculation data for the given trading pair,
+// consolidated across venues.
+func (e *Engine) getPairSummary(pair string) pairSummary {
+	summary := pairSummary{
+		venueVWAPs:  make([]float64, len(e.venues)),
+		venueShares: make([]float64, len(e.venues)),
+	}
+
+	var includedVWAPs, includedTWAPs, includedVariances []float64
+	var includedVolumes, includedCaps []float64
+	var includedPrices [][]weightedPrice
+	var totalVolume float64
+	t := e.exchangeTime
+	for i, venue := range e.venues {
+		// Venues with no window for the pair have no data for it yet.
+		window, hasWindow := e.windows[pair][venue.name]
+		if !hasWindow {
+			continue
+		}
+
+		summary.venueVWAPs[i] = window.getVWAP()
+		summary.venueShares[i] = window.getVolume()
+		totalVolume += window.getVolume()
+
+		if venue.excluded || window.getVolume() <= 0 {
+			continue
+		}
+
+		includedVWAPs = append(includedVWAPs, window.getVWAP())
+		includedTWAPs = append(includedTWAPs, window.getTWAP(t))
+		includedVariances = append(includedVariances, window.getVariance())
+		if e.priceStats {
+			includedPrices = append(includedPrices,
+				window.getSortedPrices())
+		}
+		summary.high, summary.low = getHighLow(summary.high, summary.low,
+			window.getHigh(), window.getLow())
+		includedVolumes = append(includedVolumes, window.getVolume())
+		includedCaps = append(includedCaps, venue.weightCap)
+		summary.volume += window.getVolume()
+		buySums, sellSums := window.getTakerSums()
+		summary.buySums.addSums(buySums)
+		summary.sellSums.addSums(sellSums)
+	}
+
+	if totalVolume > 0 {
+		for i := range summary.venueShares {
+			summary.venueShares[i] /= totalVolume
+		}
+	}
+
+	weights := getCappedWeights(includedVolumes, includedCaps)
+	for i, weight := range weights {
+		summary.vwap += weight * includedVWAPs[i]
+		summary.twap += weight * includedTWAPs[i]
+	}
+
+	// The variance around the consolidated VWAP is the weighted mean of the
+	// variance of each venue plus the square of the deviation of its VWAP
+	// from the consolidated one.
+	var variance float64
+	for i, weight := range weights {
+		deviation := includedVWAPs[i] - summary.vwap
+		variance += weight * (includedVariances[i] + deviation*deviation)
+	}
+	summary.stdDev = math.Sqrt(variance)
+
+	if e.priceStats {
+		summary.median = getConsolidatedMedian(includedPrices, includedVolumes,
+			weights)
+	}
+
+	return summary
+}
+
+// getConsolidatedMedian returns the volume-weighted median of the given sorted
+// prices of each venue, with the sizes at each price scaled so that the prices
+// of each venue add up to its weight. The prices of a single venue are used
+// as they are, since scaling them does not change their median.
+func getConsolidatedMedian(
+	prices [][]weightedPrice, volumes, weights []float64,
+) float64 {
+	if len(prices) == 1 {
+		return getWeightedMedian(prices[0])
+	}
+
+	var weightedPrices []weightedPrice
+	for i, weight := range weights {
+		for _, price := range prices[i] {
+			weightedPrices = append(weightedPrices, weightedPrice{
+				price: price.price,
+				size:  price.size * weight / volumes[i],
+			})
+		}
+	}
+
+	sort.Slice(weightedPrices, func(i, j int) bool {
+		return weightedPrices[i].price < weightedPrices[j].price
+	})
+	return getWeightedMedian(weightedPrices)
+}
+
+// getCappedWeights returns the weights for the given volumes, which are their
+// shares of the total volume, with each weight limited by the cap at the same
+// position, if not zero. The weight above a cap is redistributed among the
+// uncapped volumes, in proportion to them. If every volume is capped and the
+// caps add up to less than 1, the weights are normalized to add up to 1.
+func getCappedWeights(volumes, caps []float64) []float64 {
+	weights := make([]float64, len(volumes))
+	isCapped := make([]bool, len(volumes))
+	remainingWeight := 1.0
+
+	for {
+		var uncappedVolume float64
+		for i, volume := range volumes {
+			if !isCapped[i] {
+				uncappedVolume += volume
+			}
+		}
+
+		if uncappedVolume <= 0 {
+			break
+		}
+
+		// Cap the weights exceeding their caps, and start over with the
+		// remaining weight, until no weight exceeds its cap.
+		newlyCapped := false
+		for i, volume := range volumes {
+			if isCapped[i] {
+				continue
+			}
+
+			weights[i] = remainingWeight * volume / uncappedVolume
+			if caps[i] > 0 && weights[i] > caps[i] {
+				weights[i] = caps[i]
+				isCapped[i] = true
+				remainingWeight -= caps[i]
+				newlyCapped = true
+			}
+		}
+
+		if !newlyCapped {
+			return weights
+		}
+	}
+
+	var totalWeight float64
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+
+	if totalWeight > 0 {
+		for i := range weights {
+			weights[i] /= totalWeight
+		}
+	}
+	return weights
+}
@@ -0,0 +1,178 @@
+// +build unit
+
+package calc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getCappedWeights(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		volumes        []float64
+		caps           []float64
+		expectedOutput []float64
+	}{
+		{
+			desc:           "no volumes",
+			volumes:        nil,
+			caps:           nil,
+			expectedOutput: []float64{},
+		},
+		{
+			desc:           "no caps",
+			volumes:        []float64{1, 3},
+			caps:           []float64{0, 0},
+			expectedOutput: []float64{0.25, 0.75},
+		},
+		{
+			desc:           "cap not reached",
+			volumes:        []float64{1, 3},
+			caps:           []float64{0.5, 0},
+			expectedOutput: []float64{0.25, 0.75},
+		},
+		{
+			desc:           "cap reached",
+			volumes:        []float64{6, 2, 2},
+			caps:           []float64{0.5, 0, 0},
+			expectedOutput: []float64{0.5, 0.25, 0.25},
+		},
+		{
+			desc:           "cap reached after redistribution",
+			volumes:        []float64{6, 3, 1},
+			caps:           []float64{0.5, 0.35, 0},
+			expectedOutput: []float64{0.5, 0.35, 0.15},
+		},
+		{
+			desc:           "all volumes capped",
+			volumes:        []float64{3, 1},
+			caps:           []float64{0.3, 0.2},
+			expectedOutput: []float64{0.6, 0.4},
+		},
+	}
+
+	for _, tc := range testCases {
+		output := getCappedWeights(tc.volumes, tc.caps)
+
+		assert.Equal(t, len(tc.expectedOutput), len(output),
+			"For test %q, got wrong number of weights", tc.desc)
+		for i := range output {
+			assert.InDelta(t, tc.expectedOutput[i], output[i], 1e-9,
+				"For test %q, got wrong weight at %d", tc.desc, i)
+		}
+	}
+}
+
+func Test_getPairSummary(t *testing.T) {
+	windows := map[string]map[string]window{
+		"pair1": {
+			"venue1": &slidingWindow{
+				vwap:            10.0,
+				vwapDenominator: 6,
+				variance:        varianceSums{shift: 10, squareSum: 24},
+				buySums:         vwapSums{numerator: 60, denominator: 6},
+			},
+			"venue2": &slidingWindow{
+				vwap:            20.0,
+				vwapDenominator: 2,
+				variance:        varianceSums{shift: 20},
+				sellSums:        vwapSums{numerator: 40, denominator: 2},
+			},
+			"venue3": &slidingWindow{
+				vwap:            30.0,
+				vwapDenominator: 2,
+				variance:        varianceSums{shift: 30},
+				sellSums:        vwapSums{numerator: 60, denominator: 2},
+			},
+		},
+	}
+
+	testCases := []struct {
+		desc           string
+		venues         []*venueFeed
+		expectedOutput pairSummary
+	}{
+		{
+			desc: "all venues included",
+			venues: []*venueFeed{
+				{name: "venue1"}, {name: "venue2"}, {name: "venue3"},
+			},
+			expectedOutput: pairSummary{
+				vwap:        16.0,
+				volume:      10.0,
+				stdDev:      math.Sqrt(66.4),
+				buySums:     vwapSums{numerator: 60, denominator: 6},
+				sellSums:    vwapSums{numerator: 100, denominator: 4},
+				venueVWAPs:  []float64{10, 20, 30},
+				venueShares: []float64{0.6, 0.2, 0.2},
+			},
+		},
+		{
+			desc: "excluded venue",
+			venues: []*venueFeed{
+				{name: "venue1"}, {name: "venue2"},
+				{name: "venue3", excluded: true},
+			},
+			expectedOutput: pairSummary{
+				vwap:        12.5,
+				volume:      8.0,
+				stdDev:      math.Sqrt(21.75),
+				buySums:     vwapSums{numerator: 60, denominator: 6},
+				sellSums:    vwapSums{numerator: 40, denominator: 2},
+				venueVWAPs:  []float64{10, 20, 30},
+				venueShares: []float64{0.6, 0.2, 0.2},
+			},
+		},
+		{
+			desc: "capped venue",
+			venues: []*venueFeed{
+				{name: "venue1", weightCap: 0.5}, {name: "venue2"},
+				{name: "venue3"},
+			},
+			expectedOutput: pairSummary{
+				vwap:        17.5,
+				volume:      10.0,
+				stdDev:      math.Sqrt(70.75),
+				buySums:     vwapSums{numerator: 60, denominator: 6},
+				sellSums:    vwapSums{numerator: 100, denominator: 4},
+				venueVWAPs:  []float64{10, 20, 30},
+				venueShares: []float64{0.6, 0.2, 0.2},
+			},
+		},
+		{
+			desc:   "venue with no windows",
+			venues: []*venueFeed{{name: "venue1"}, {name: "venue4"}},
+			expectedOutput: pairSummary{
+				vwap:        10.0,
+				volume:      6.0,
+				stdDev:      2,
+				buySums:     vwapSums{numerator: 60, denominator: 6},
+				venueVWAPs:  []float64{10, 0},
+				venueShares: []float64{1, 0},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		e := &Engine{
+			venues:     tc.venues,
+			windowSize: 10,
+			windows:    windows,
+		}
+		output := e.getPairSummary("pair1")
+
+		assert.True(t, math.Abs(tc.expectedOutput.vwap-output.vwap) < 1e-9,
+			"For test %q, got wrong VWAP %v", tc.desc, output.vwap)
+		output.vwap = tc.expectedOutput.vwap
+		assert.InDelta(t, tc.expectedOutput.stdDev, output.stdDev, 1e-9,
+			"For test %q, got wrong standard deviation", tc.desc)
+		output.stdDev = tc.expectedOutput.stdDev
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+		assert.NotContains(t, windows["pair1"], "venue4",
+			"For test %q, window created for venue with no data", tc.desc)
+	}
+}
@@ -0,0 +1,202 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Share of the volume covered by the value area of a volume profile.
+const valueAreaShare float64 = 0.7
+
+// VolumeProfile is the volume traded at each price bucket in the windows of a
+// reported pair, across the included venues.
+type VolumeProfile struct {
+	// Width of the price buckets. Bucket i spans [i * BucketWidth,
+	// (i + 1) * BucketWidth).
+	BucketWidth float64
+
+	// Buckets with volume, sorted by price.
+	Buckets []ProfileBucket
+
+	// Middle price of the bucket with the most volume, or 0 if there is no
+	// volume. The lowest bucket wins ties.
+	PointOfControl float64
+
+	// Price range of the value area, the buckets around the point of control
+	// covering 70% of the volume.
+	ValueAreaLow, ValueAreaHigh float64
+}
+
+// ProfileBucket is the volume traded in a price bucket of a volume profile.
+type ProfileBucket struct {
+	Low, High float64
+	Volume    float64
+}
+
+// profileBucket holds the volume of a price bucket in a window, along with the
+// number of matches in it, so that it can be dropped when empty.
+type profileBucket struct {
+	volume  float64
+	matches int
+}
+
+// volumeProfile maintains the volume at each price bucket for the matches in
+// a sliding window, as they enter and leave it.
+type volumeProfile struct {
+	bucketWidth float64
+	buckets     map[int64]*profileBucket
+}
+
+func newVolumeProfile(bucketWidth float64) *volumeProfile {
+	return &volumeProfile{
+		bucketWidth: bucketWidth,
+		buckets:     make(map[int64]*profileBucket),
+	}
+}
+
+// getBucket returns the index of the bucket of the given price.
+func (p *volumeProfile) getBucket(price float64) int64 {
+	return int64(math.Floor(price / p.bucketWidth))
+}
+
+// add adds the given size traded at the given price to the profile.
+func (p *volumeProfile) add(price, size float64) {
+	i := p.getBucket(price)
+	bucket, hasBucket := p.buckets[i]
+	if !hasBucket {
+		bucket = &profileBucket{}
+		p.buckets[i] = bucket
+	}
+
+	bucket.volume += size
+	bucket.matches++
+}
+
+// subtract removes the given size traded at the given price, which left the
+// window, from the profile.
+func (p *volumeProfile) subtract(price, size float64) {
+	i := p.getBucket(price)
+	bucket, hasBucket := p.buckets[i]
+	if !hasBucket {
+		return
+	}
+
+	bucket.volume -= size
+	bucket.matches--
+	if bucket.matches <= 0 {
+		delete(p.buckets, i)
+	}
+}
+
+// validateVolumeProfiles checks the bucket width of each pair with a volume
+// profile.
+func validateVolumeProfiles(bucketWidths map[string]float64) error {
+	for pair, width := range bucketWidths {
+		if pair == "" {
+			return errors.New("empty volume profile pair")
+		}
+
+		if width <= 0 || math.IsInf(width, 1) {
+			return fmt.Errorf("invalid bucket width %v for %q, must be "+
+				"positive", width, pair)
+		}
+	}
+	return nil
+}
+
+// getVolumeProfile returns the volume profile of the given reported pair,
+// adding up the volume of each bucket across the included venues, or nil if
+// the pair has no volume profile.
+func (e *Engine) getVolumeProfile(pair string) *VolumeProfile {
+	bucketWidth, hasProfile := e.volumeProfiles[pair]
+	if !hasProfile {
+		return nil
+	}
+
+	volumes := make(map[int64]float64)
+	for _, venue := range e.venues {
+		if venue.excluded {
+			continue
+		}
+
+		window, hasWindow := e.windows[pair][venue.name]
+		if !hasWindow {
+			continue
+		}
+
+		profile := window.getVolumeProfile()
+		if profile == nil {
+			continue
+		}
+
+		for i, bucket := range profile.buckets {
+			volumes[i] += bucket.volume
+		}
+	}
+
+	indexes := make([]int64, 0, len(volumes))
+	for i := range volumes {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] < indexes[b] })
+
+	profile := &VolumeProfile{
+		BucketWidth: bucketWidth,
+		Buckets:     make([]ProfileBucket, len(indexes)),
+	}
+	var totalVolume float64
+	pointOfControl := -1
+	for j, i := range indexes {
+		profile.Buckets[j] = ProfileBucket{
+			Low:    float64(i) * bucketWidth,
+			High:   float64(i+1) * bucketWidth,
+			Volume: volumes[i],
+		}
+		totalVolume += volumes[i]
+
+		if pointOfControl < 0 ||
+			volumes[i] > profile.Buckets[pointOfControl].Volume {
+			pointOfControl = j
+		}
+	}
+
+	if pointOfControl < 0 {
+		return profile
+	}
+
+	poc := profile.Buckets[pointOfControl]
+	profile.PointOfControl = (poc.Low + poc.High) / 2
+
+	// The value area grows from the point of control towards the adjacent
+	// bucket with the most volume, the lower one winning ties, until it
+	// covers its share of the volume.
+	low, high := pointOfControl, pointOfControl
+	volume := poc.Volume
+	for volume < valueAreaShare*totalVolume {
+		var below, above float64 = -1, -1
+		if low > 0 {
+			below = profile.Buckets[low-1].Volume
+		}
+		if high < len(profile.Buckets)-1 {
+			above = profile.Buckets[high+1].Volume
+		}
+
+		if below < 0 && above < 0 {
+			break
+		}
+
+		if below >= above {
+			low--
+			volume += below
+		} else {
+			high++
+			volume += above
+		}
+	}
+
+	profile.ValueAreaLow = profile.Buckets[low].Low
+	profile.ValueAreaHigh = profile.Buckets[high].High
+	return profile
+}
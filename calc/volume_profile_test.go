@@ -0,0 +1,130 @@
+// +build unit
+
+package calc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_slidingWindowVolumeProfile(t *testing.T) {
+	window := newSlidingWindow(2)
+	window.profile = newVolumeProfile(10)
+
+	matches := []feed.Match{
+		{Price: 15, Size: 1},
+		{Price: 19, Size: 2},
+		{Price: -1, Size: 3},
+	}
+	for _, match := range matches {
+		assert.Nil(t, window.addMatch(match), "Got error adding match")
+	}
+
+	// The first match left the window, but the second one is still in the
+	// same bucket.
+	assert.Equal(t, map[int64]*profileBucket{
+		-1: {volume: 3, matches: 1},
+		1:  {volume: 2, matches: 1},
+	}, window.getVolumeProfile().buckets, "Got wrong buckets")
+
+	assert.Nil(t, window.addMatch(feed.Match{Price: 5, Size: 1}),
+		"Got error adding match")
+	assert.Equal(t, map[int64]*profileBucket{
+		-1: {volume: 3, matches: 1},
+		0:  {volume: 1, matches: 1},
+	}, window.getVolumeProfile().buckets, "Got wrong buckets after eviction")
+}
+
+func Test_EngineVolumeProfile(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		windowType     WindowType
+		volumeProfiles map[string]float64
+		matches        []feed.Match
+		expectedError  error
+		expectedOutput map[string]*VolumeProfile
+	}{
+		{
+			desc:           "EWMA windows",
+			windowType:     WindowEWMA,
+			volumeProfiles: map[string]float64{"BTC-USD": 10},
+			expectedError: errors.New("volume profiles require " +
+				"\"sliding\" windows"),
+		},
+		{
+			desc:           "invalid bucket width",
+			volumeProfiles: map[string]float64{"BTC-USD": 0},
+			expectedError: errors.New("invalid bucket width 0 for " +
+				"\"BTC-USD\", must be positive"),
+		},
+		{
+			desc:           "no volume",
+			volumeProfiles: map[string]float64{"BTC-USD": 10},
+			expectedOutput: map[string]*VolumeProfile{
+				"BTC-USD": {BucketWidth: 10, Buckets: []ProfileBucket{}},
+				"ETH-USD": nil,
+			},
+		},
+		{
+			desc:           "value area",
+			volumeProfiles: map[string]float64{"BTC-USD": 10},
+			matches: []feed.Match{
+				{Price: 40005, Size: 1},
+				{Price: 40012, Size: 4},
+				{Price: 40018, Size: 2},
+				{Price: 40025, Size: 2},
+				{Price: 40041, Size: 1},
+			},
+			expectedOutput: map[string]*VolumeProfile{
+				"BTC-USD": {
+					BucketWidth: 10,
+					Buckets: []ProfileBucket{
+						{Low: 40000, High: 40010, Volume: 1},
+						{Low: 40010, High: 40020, Volume: 6},
+						{Low: 40020, High: 40030, Volume: 2},
+						{Low: 40040, High: 40050, Volume: 1},
+					},
+					PointOfControl: 40015,
+					ValueAreaLow:   40010,
+					ValueAreaHigh:  40030,
+				},
+				"ETH-USD": nil,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		engine, err := NewEngine([]Venue{{Conn: &feed.Conn{}}}, Config{
+			TradingPairs:   []string{"BTC-USD", "ETH-USD"},
+			WindowType:     tc.windowType,
+			WindowSize:     10,
+			HalfLifeTrades: 10,
+			VolumeProfiles: tc.volumeProfiles,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err != nil {
+			continue
+		}
+
+		for _, match := range tc.matches {
+			match.ProductID = "BTC-USD"
+			engine.handleMatch(match)
+		}
+
+		snapshot := engine.Snapshot()
+		for pair, expectedProfile := range tc.expectedOutput {
+			assert.Equal(t, expectedProfile, snapshot.Pairs[pair].Profile,
+				"For test %q, got wrong profile for %q", tc.desc, pair)
+		}
+
+		// Reading the profiles must not create windows for pairs with no
+		// matches.
+		assert.Empty(t, engine.windows["ETH-USD"],
+			"For test %q, got windows for a pair with no matches", tc.desc)
+	}
+}
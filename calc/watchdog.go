@@ -0,0 +1,180 @@
+package calc
+
+import (
+	"log"
+	"time"
+)
+
+// Bounds for the interval between staleness checks.
+const (
+	minWatchdogInterval time.Duration = 10 * time.Millisecond
+	maxWatchdogInterval time.Duration = time.Second
+)
+
+// now returns the current local time, against which the activity of the
+// trading pairs and the age of the conversion rates are measured.
+var now = time.Now
+
+// StalenessKind identifies what a trading pair stopped receiving.
+type StalenessKind string
+
+// Kinds of staleness.
+const (
+	// HeartbeatStale means no heartbeat was received for longer than the
+	// heartbeat timeout, which usually indicates a dead connection.
+	HeartbeatStale StalenessKind = "heartbeat"
+
+	// TradeStale means no match was received for longer than the trade
+	// timeout, which may simply indicate a quiet trading pair.
+	TradeStale StalenessKind = "trade"
+)
+
+// StalenessEvent is raised when a trading pair goes stale.
+type StalenessEvent struct {
+	ProductID string
+	Kind      StalenessKind
+
+	// Time elapsed since the last heartbeat or match for the trading pair.
+	Silence time.Duration
+}
+
+// pairActivity holds the local time of the most recent heartbeat and match for
+// a trading pair, and whether it is currently considered stale for each.
+type pairActivity struct {
+	lastHeartbeat, lastTrade       time.Time
+	isHeartbeatStale, isTradeStale bool
+}
+
+// getActivity returns the activity for the given trading pair. If none is
+// found, one is created as if the pair were active at the given time.
+func (e *Engine) getActivity(pair string, at time.Time) *pairActivity {
+	activity, hasActivity := e.activity[pair]
+	if !hasActivity {
+		activity = &pairActivity{lastHeartbeat: at, lastTrade: at}
+		e.activity[pair] = activity
+	}
+
+	return activity
+}
+
+// resetActivity marks all trading pairs as active at the given time.
+func (e *Engine) resetActivity(at time.Time) {
+	e.activity = make(map[string]*pairActivity)
+	for _, pair := range e.tradingPairs {
+		e.getActivity(pair, at)
+	}
+}
+
+// recordHeartbeat updates the activity of the given trading pair with a
+// heartbeat received at the given time.
+func (e *Engine) recordHeartbeat(pair string, at time.Time) {
+	activity := e.getActivity(pair, at)
+	activity.lastHeartbeat = at
+
+	if activity.isHeartbeatStale {
+		activity.isHeartbeatStale = false
+		log.Printf("Heartbeats resumed for %q", pair)
+	}
+}
+
+// recordTrade updates the activity of the given trading pair with a match
+// received at the given time.
+func (e *Engine) recordTrade(pair string, at time.Time) {
+	activity := e.getActivity(pair, at)
+	activity.lastTrade = at
+
+	if activity.isTradeStale {
+		activity.isTradeStale = false
+		log.Printf("Matches resumed for %q", pair)
+	}
+}
+
+// checkStaleness returns the staleness events for all the trading pairs that
+// went stale at the given time. Each pair raises a single event for each kind
+// of staleness, until it becomes active again.
+func (e *Engine) checkStaleness(at time.Time) []StalenessEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []StalenessEvent
+	for _, pair := range e.tradingPairs {
+		activity := e.getActivity(pair, at)
+
+		silence := at.Sub(activity.lastHeartbeat)
+		if e.heartbeatTimeout > 0 && !activity.isHeartbeatStale &&
+			silence > e.heartbeatTimeout {
+			activity.isHeartbeatStale = true
+			events = append(events, StalenessEvent{
+				ProductID: pair,
+				Kind:      HeartbeatStale,
+				Silence:   silence,
+			})
+		}
+
+		silence = at.Sub(activity.lastTrade)
+		if e.tradeTimeout > 0 && !activity.isTradeStale &&
+			silence > e.tradeTimeout {
+			activity.isTradeStale = true
+			events = append(events, StalenessEvent{
+				ProductID: pair,
+				Kind:      TradeStale,
+				Silence:   silence,
+			})
+		}
+	}
+
+	return events
+}
+
+// raiseStalenessEvents logs the given events and passes them to the engine's
+// staleness callback. If the engine is configured to do so, it also forces a
+// reconnect to the feed.
+func (e *Engine) raiseStalenessEvents(events []StalenessEvent) {
+	for _, event := range events {
+		log.Printf("Trading pair %q is stale: no %s for %v", event.ProductID,
+			event.Kind, event.Silence)
+
+		if e.onStale != nil {
+			e.onStale(event)
+		}
+	}
+
+	if len(events) > 0 && e.reconnectOnStale {
+		log.Print("Forcing reconnect to feeds due to stale trading pairs")
+		e.closeFeedConns()
+	}
+}
+
+// getWatchdogInterval returns the interval between staleness checks, which is
+// a fraction of the smallest timeout.
+func (e *Engine) getWatchdogInterval() time.Duration {
+	interval := maxWatchdogInterval
+	for _, timeout := range []time.Duration{
+		e.heartbeatTimeout, e.tradeTimeout,
+	} {
+		if timeout > 0 && timeout/4 < interval {
+			interval = timeout / 4
+		}
+	}
+
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	return interval
+}
+
+// watchStaleness periodically checks the trading pairs for staleness, until
+// doneCh is closed.
+func (e *Engine) watchStaleness(doneCh chan struct{}) {
+	ticker := time.NewTicker(e.getWatchdogInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+			e.raiseStalenessEvents(e.checkStaleness(now()))
+		}
+	}
+}
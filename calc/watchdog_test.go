@@ -0,0 +1,247 @@
+// +build unit
+
+package calc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkStaleness(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc             string
+		heartbeatTimeout time.Duration
+		tradeTimeout     time.Duration
+		heartbeats       map[string]time.Time
+		trades           map[string]time.Time
+		checks           []time.Time
+		expectedEvents   []StalenessEvent
+	}{
+		{
+			desc:           "staleness detection disabled",
+			checks:         []time.Time{start.Add(time.Hour)},
+			expectedEvents: nil,
+		},
+		{
+			desc:             "active pairs",
+			heartbeatTimeout: 5 * time.Second,
+			tradeTimeout:     time.Minute,
+			heartbeats: map[string]time.Time{
+				"pair1": start.Add(8 * time.Second),
+				"pair2": start.Add(9 * time.Second),
+			},
+			checks:         []time.Time{start.Add(10 * time.Second)},
+			expectedEvents: nil,
+		},
+		{
+			desc:             "heartbeats stopped, raised once",
+			heartbeatTimeout: 5 * time.Second,
+			heartbeats: map[string]time.Time{
+				"pair1": start.Add(8 * time.Second),
+				"pair2": start.Add(2 * time.Second),
+			},
+			checks: []time.Time{
+				start.Add(10 * time.Second),
+				start.Add(11 * time.Second),
+			},
+			expectedEvents: []StalenessEvent{
+				StalenessEvent{
+					ProductID: "pair2",
+					Kind:      HeartbeatStale,
+					Silence:   8 * time.Second,
+				},
+			},
+		},
+		{
+			desc:             "silent pairs",
+			heartbeatTimeout: 5 * time.Second,
+			tradeTimeout:     time.Minute,
+			heartbeats: map[string]time.Time{
+				"pair1": start.Add(119 * time.Second),
+				"pair2": start.Add(119 * time.Second),
+			},
+			trades: map[string]time.Time{
+				"pair1": start.Add(100 * time.Second),
+			},
+			checks: []time.Time{start.Add(2 * time.Minute)},
+			expectedEvents: []StalenessEvent{
+				StalenessEvent{
+					ProductID: "pair2",
+					Kind:      TradeStale,
+					Silence:   2 * time.Minute,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		e := &Engine{
+			tradingPairs:     []string{"pair1", "pair2"},
+			heartbeatTimeout: tc.heartbeatTimeout,
+			tradeTimeout:     tc.tradeTimeout,
+		}
+		e.resetActivity(start)
+
+		for pair, at := range tc.heartbeats {
+			e.recordHeartbeat(pair, at)
+		}
+
+		for pair, at := range tc.trades {
+			e.recordTrade(pair, at)
+		}
+
+		var events []StalenessEvent
+		for _, at := range tc.checks {
+			events = append(events, e.checkStaleness(at)...)
+		}
+
+		assert.Equal(t, tc.expectedEvents, events,
+			"For test %q, got unexpected staleness events", tc.desc)
+	}
+}
+
+func Test_stalenessRecovery(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	e := &Engine{
+		tradingPairs:     []string{"pair1"},
+		heartbeatTimeout: 5 * time.Second,
+	}
+	e.resetActivity(start)
+
+	events := e.checkStaleness(start.Add(10 * time.Second))
+	assert.Len(t, events, 1, "Expected pair to go stale")
+
+	// A new heartbeat makes the pair active again, so that it can raise a new
+	// event later on.
+	e.recordHeartbeat("pair1", start.Add(11*time.Second))
+	events = e.checkStaleness(start.Add(12 * time.Second))
+	assert.Empty(t, events, "Expected pair to be active")
+
+	events = e.checkStaleness(start.Add(20 * time.Second))
+	assert.Equal(t, []StalenessEvent{
+		StalenessEvent{
+			ProductID: "pair1",
+			Kind:      HeartbeatStale,
+			Silence:   9 * time.Second,
+		},
+	}, events, "Expected pair to go stale again")
+}
+
+func Test_raiseStalenessEvents(t *testing.T) {
+	var raised []StalenessEvent
+	e := &Engine{
+		onStale: func(event StalenessEvent) {
+			raised = append(raised, event)
+		},
+	}
+
+	events := []StalenessEvent{
+		StalenessEvent{ProductID: "pair1", Kind: HeartbeatStale},
+		StalenessEvent{ProductID: "pair2", Kind: TradeStale},
+	}
+	e.raiseStalenessEvents(events)
+
+	assert.Equal(t, events, raised, "Got unexpected events in callback")
+}
+
+func Test_getWatchdogInterval(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		heartbeatTimeout time.Duration
+		tradeTimeout     time.Duration
+		expectedOutput   time.Duration
+	}{
+		{
+			desc:           "no timeouts",
+			expectedOutput: maxWatchdogInterval,
+		},
+		{
+			desc:             "long timeouts",
+			heartbeatTimeout: time.Minute,
+			tradeTimeout:     time.Hour,
+			expectedOutput:   maxWatchdogInterval,
+		},
+		{
+			desc:             "short timeout",
+			heartbeatTimeout: time.Minute,
+			tradeTimeout:     2 * time.Second,
+			expectedOutput:   500 * time.Millisecond,
+		},
+		{
+			desc:             "very short timeout",
+			heartbeatTimeout: time.Millisecond,
+			expectedOutput:   minWatchdogInterval,
+		},
+	}
+
+	for _, tc := range testCases {
+		e := &Engine{
+			heartbeatTimeout: tc.heartbeatTimeout,
+			tradeTimeout:     tc.tradeTimeout,
+		}
+
+		assert.Equal(t, tc.expectedOutput, e.getWatchdogInterval(),
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_recordHeartbeats(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc               string
+		heartbeats         []feed.Heartbeat
+		expectedHeartbeats map[string]time.Time
+	}{
+		{
+			desc: "product heartbeat",
+			heartbeats: []feed.Heartbeat{
+				{ProductID: "pair1", ReceivedAt: start.Add(time.Second)},
+			},
+			expectedHeartbeats: map[string]time.Time{
+				"pair1": start.Add(time.Second),
+				"pair2": start,
+			},
+		},
+		{
+			desc: "unsubscribed product heartbeat",
+			heartbeats: []feed.Heartbeat{
+				{ProductID: "pair3", ReceivedAt: start.Add(time.Second)},
+			},
+			expectedHeartbeats: map[string]time.Time{
+				"pair1": start,
+				"pair2": start,
+			},
+		},
+		{
+			desc: "connection heartbeat",
+			heartbeats: []feed.Heartbeat{
+				{ReceivedAt: start.Add(2 * time.Second)},
+			},
+			expectedHeartbeats: map[string]time.Time{
+				"pair1": start.Add(2 * time.Second),
+				"pair2": start.Add(2 * time.Second),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		e := &Engine{}
+		e.setTradingPairs([]string{"pair1", "pair2"})
+		e.resetActivity(start)
+
+		e.recordHeartbeats(tc.heartbeats)
+
+		heartbeats := make(map[string]time.Time)
+		for pair, activity := range e.activity {
+			heartbeats[pair] = activity.lastHeartbeat
+		}
+		assert.Equal(t, tc.expectedHeartbeats, heartbeats,
+			"For test %q, got unexpected heartbeat times", tc.desc)
+	}
+}
@@ -0,0 +1,93 @@
+package calc
+
+import (
+	"time"
+
+	"github.com/ha2398/vwap/feed"
+)
+
+// WindowType defines how the matches of a trading pair are weighted in its
+// VWAP.
+type WindowType string
+
+// Supported window types.
+const (
+	// WindowSliding weights equally the most recent matches, up to the
+	// window size.
+	WindowSliding WindowType = "sliding"
+
+	// WindowEWMA weights every match by a factor that decays exponentially
+	// with its age, in time or in number of matches.
+	WindowEWMA WindowType = "ewma"
+)
+
+// isValid indicates if the window type is one of the supported ones.
+func (t WindowType) isValid() bool {
+	switch t {
+	case WindowSliding, WindowEWMA:
+		return true
+	default:
+		return false
+	}
+}
+
+// window maintains the calculation data for the matches of a reported pair on
+// a venue.
+type window interface {
+	// addMatch incorporates the given match into the calculation data.
+	addMatch(match feed.Match) error
+
+	// getVWAP returns the current VWAP, or 0 if there is no volume.
+	getVWAP() float64
+
+	// getVolume returns the volume the VWAP is computed over.
+	getVolume() float64
+
+	// getVariance returns the volume-weighted variance of the prices around
+	// the VWAP, or 0 if there is no volume.
+	getVariance() float64
+
+	// getHigh and getLow return the highest and lowest prices of the matches
+	// in the window, or 0 if it does not keep them.
+	getHigh() float64
+	getLow() float64
+
+	// getSortedPrices returns the prices of the matches in the window, sorted
+	// by value, along with their sizes, or nil if it does not keep them.
+	getSortedPrices() []weightedPrice
+
+	// getVolumeProfile returns the volume profile of the matches in the
+	// window, or nil if it does not keep one.
+	getVolumeProfile() *volumeProfile
+
+	// getTakerSums returns the partial sums of taker buy and taker sell
+	// matches.
+	getTakerSums() (buySums, sellSums vwapSums)
+
+	// getTWAP returns the TWAP at the given time.
+	getTWAP(t time.Time) float64
+
+	// isSeed indicates if the window only holds last_match seed data, when
+	// using the LastMatchSeed policy.
+	isSeed() bool
+
+	// setSeed marks the window as only holding seed data.
+	setSeed()
+}
+
+// newWindow returns an empty window of the engine's type for the given
+// reported pair, which keeps a volume profile if the pair has one.
+func (e *Engine) newWindow(pair string) window {
+	if e.windowType == WindowEWMA {
+		window := newEWMAWindow(e.halfLife, e.halfLifeTrades)
+		window.clock = func() time.Time { return e.exchangeTime }
+		return window
+	}
+
+	window := newSlidingWindow(e.windowSize)
+	window.keepSortedPrices = e.priceStats
+	if bucketWidth, hasProfile := e.volumeProfiles[pair]; hasProfile {
+		window.profile = newVolumeProfile(bucketWidth)
+	}
+	return window
+}
@@ -0,0 +1,287 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys used in Advanced Trade messages.
+const (
+	ChannelKey          string = "channel"
+	CurrentTimeKey      string = "current_time"
+	EventsKey           string = "events"
+	HeartbeatCounterKey string = "heartbeat_counter"
+	SequenceNumKey      string = "sequence_num"
+	SubscriptionsKey    string = "subscriptions"
+	TradesKey           string = "trades"
+)
+
+// Advanced Trade channels.
+const (
+	HeartbeatsChannel    string = "heartbeats"
+	MarketTradesChannel  string = "market_trades"
+	SubscriptionsChannel string = "subscriptions"
+)
+
+// Advanced Trade event types.
+const (
+	SnapshotEventType string = "snapshot"
+	UpdateEventType   string = "update"
+)
+
+// Layout of the current time in Advanced Trade heartbeats, which is the
+// default string representation of a Go time, without the monotonic clock
+// reading that may follow it.
+const heartbeatTimeLayout string = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// advancedTradeFormat is the format of the Coinbase Advanced Trade feed. Trades
+// are batched in the events of market_trades messages, and heartbeats are sent
+// for the whole connection, rather than for each product.
+type advancedTradeFormat struct{}
+
+func (advancedTradeFormat) defaultEndpoint() string {
+	return "wss://advanced-trade-ws.coinbase.com"
+}
+
+func (advancedTradeFormat) hasHeartbeats() bool {
+	return true
+}
+
+// newSubscribeMessages returns one message for each channel, as Advanced Trade
+// only takes one channel per subscribe message. Heartbeats are subscribed to
+// for the whole connection, so no products are given for them.
+func (advancedTradeFormat) newSubscribeMessages(
+	productIDs []string, credentials *Credentials,
+) ([]Message, error) {
+	if credentials != nil {
+		return nil, errUnsupportedCredentials(AdvancedTradeFormat)
+	}
+
+	return []Message{
+		{
+			TypeKey:       SubscribeType,
+			ChannelKey:    MarketTradesChannel,
+			ProductIDsKey: productIDs,
+		},
+		{
+			TypeKey:    SubscribeType,
+			ChannelKey: HeartbeatsChannel,
+		},
+	}, nil
+}
+
+// newUnsubscribeMessages only unsubscribes from trades, since heartbeats are
+// still needed for the remaining products.
+func (advancedTradeFormat) newUnsubscribeMessages(
+	productIDs []string,
+) []Message {
+	return []Message{
+		{
+			TypeKey:       UnsubscribeType,
+			ChannelKey:    MarketTradesChannel,
+			ProductIDsKey: productIDs,
+		},
+	}
+}
+
+func (advancedTradeFormat) parseConfirmation(
+	message Message, productIDs []string,
+) (subscriptionStatus, bool, error) {
+	if message.GetValueForKey(TypeKey) == ErrorType {
+		return subscriptionStatus{}, false,
+			parseSubscriptionError(message, productIDs)
+	}
+
+	if message.GetValueForKey(ChannelKey) != SubscriptionsChannel {
+		return subscriptionStatus{}, false, nil
+	}
+
+	// Confirmations list the subscribed products for each channel.
+	confirmed := make(map[string]bool)
+	for _, event := range getObjects(message, EventsKey) {
+		subscriptions, _ := event[SubscriptionsKey].(map[string]interface{})
+		rawProductIDs, _ :=
+			subscriptions[MarketTradesChannel].([]interface{})
+		for _, rawID := range rawProductIDs {
+			if id, ok := rawID.(string); ok {
+				confirmed[id] = true
+			}
+		}
+	}
+
+	status := subscriptionStatus{complete: true}
+	for _, id := range productIDs {
+		if !confirmed[id] {
+			status.rejected = append(status.rejected, id)
+		}
+	}
+	return status, true, nil
+}
+
+func (advancedTradeFormat) parseUpdate(message Message) (Update, error) {
+	switch message.GetValueForKey(ChannelKey) {
+	case MarketTradesChannel:
+		return parseMarketTrades(message)
+	case HeartbeatsChannel:
+		heartbeat, err := parseAdvancedTradeHeartbeat(message)
+		if err != nil {
+			return Update{}, fmt.Errorf("error parsing heartbeat data: %v",
+				err)
+		}
+		return Update{Heartbeats: []Heartbeat{heartbeat}}, nil
+	default:
+		return Update{}, nil
+	}
+}
+
+// parseMarketTrades parses the trades in all events of a market_trades
+// message. Trades in snapshot events happened before the subscription, and
+// are parsed as last matches, sorted from oldest to newest. Invalid trades are
+// skipped, and reported in the returned error.
+func parseMarketTrades(message Message) (Update, error) {
+	sequence, err := parseSequenceNum(message)
+	if err != nil {
+		return Update{}, err
+	}
+
+	var update Update
+	var errs []string
+	for _, rawEvent := range getObjects(message, EventsKey) {
+		event := Message(rawEvent)
+		isSnapshot := event.GetValueForKey(TypeKey) == SnapshotEventType
+
+		var matches []Match
+		for _, trade := range getObjects(event, TradesKey) {
+			match, err := parseTrade(trade)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+
+			match.IsLast = isSnapshot
+			match.Sequence = sequence
+			matches = append(matches, match)
+		}
+
+		if isSnapshot {
+			sort.SliceStable(matches, func(i, j int) bool {
+				return matches[i].Time.Before(matches[j].Time)
+			})
+		}
+		update.Matches = append(update.Matches, matches...)
+	}
+
+	if len(errs) > 0 {
+		return update, fmt.Errorf("error parsing match data: %s",
+			strings.Join(errs, "; "))
+	}
+	return update, nil
+}
+
+// parseTrade parses a single trade of a market_trades event into a Match. The
+// side of the trade is that of the maker order, as in the exchange feed, but
+// in upper case. As in ParseMatch, a malformed time is logged and left at its
+// zero value, rather than dropping the trade.
+func parseTrade(rawTrade map[string]interface{}) (Match, error) {
+	trade := Message(rawTrade)
+
+	price, err := strconv.ParseFloat(trade.GetValueForKey(PriceKey), 64)
+	if err != nil {
+		return Match{}, fmt.Errorf("error parsing %q field: %v", PriceKey,
+			err)
+	}
+
+	size, err := strconv.ParseFloat(trade.GetValueForKey(SizeKey), 64)
+	if err != nil {
+		return Match{}, fmt.Errorf("error parsing %q field: %v", SizeKey, err)
+	}
+
+	tradeTime, err := parseTime(trade)
+	if err != nil {
+		log.Printf("Error parsing match metadata: %v", err)
+	}
+
+	return Match{
+		Price:      price,
+		ProductID:  trade.GetValueForKey(ProductIDKey),
+		ReceivedAt: now(),
+		Side:       strings.ToLower(trade.GetValueForKey(SideKey)),
+		Size:       size,
+		Time:       tradeTime,
+		TradeID:    trade.GetIDForKey(TradeIDKey),
+	}, nil
+}
+
+// parseAdvancedTradeHeartbeat parses a heartbeats message into a Heartbeat with
+// no product ID, as it covers the whole connection.
+func parseAdvancedTradeHeartbeat(message Message) (Heartbeat, error) {
+	events := getObjects(message, EventsKey)
+	if len(events) == 0 {
+		return Heartbeat{}, errors.New("missing heartbeat event")
+	}
+	event := Message(events[0])
+
+	heartbeat := Heartbeat{ReceivedAt: now()}
+
+	counter := event.GetIDForKey(HeartbeatCounterKey)
+	if counter != "" {
+		sequence, err := strconv.ParseInt(counter, 10, 64)
+		if err != nil {
+			return Heartbeat{}, fmt.Errorf("error parsing %q field: %v",
+				HeartbeatCounterKey, err)
+		}
+		heartbeat.Sequence = sequence
+	}
+
+	currentTime := event.GetValueForKey(CurrentTimeKey)
+	if currentTime != "" {
+		if i := strings.Index(currentTime, " m="); i >= 0 {
+			currentTime = currentTime[:i]
+		}
+
+		heartbeatTime, err := time.Parse(heartbeatTimeLayout, currentTime)
+		if err != nil {
+			return Heartbeat{}, fmt.Errorf("error parsing %q field: %v",
+				CurrentTimeKey, err)
+		}
+		heartbeat.Time = heartbeatTime
+	}
+
+	return heartbeat, nil
+}
+
+// parseSequenceNum parses the optional sequence number of an Advanced Trade
+// message, which is 0 when absent.
+func parseSequenceNum(message Message) (int64, error) {
+	sequenceStr := message.GetIDForKey(SequenceNumKey)
+	if sequenceStr == "" {
+		return 0, nil
+	}
+
+	sequence, err := strconv.ParseInt(sequenceStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %q field: %v", SequenceNumKey,
+			err)
+	}
+	return sequence, nil
+}
+
+// getObjects returns the JSON objects in the list under the given key of a
+// message, skipping any other values.
+func getObjects(message map[string]interface{},
+	key string) []map[string]interface{} {
+	rawList, _ := message[key].([]interface{})
+
+	var objects []map[string]interface{}
+	for _, rawObject := range rawList {
+		if object, ok := rawObject.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
@@ -0,0 +1,368 @@
+// +build unit
+
+package feed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_advancedTradeFormatParseUpdate(t *testing.T) {
+	receivedAt := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return receivedAt }
+
+	testCases := []struct {
+		desc           string
+		message        Message
+		expectedUpdate Update
+		expectedError  error
+	}{
+		{
+			desc:           "other channel",
+			message:        Message{ChannelKey: "ticker"},
+			expectedUpdate: Update{},
+			expectedError:  nil,
+		},
+		{
+			desc: "trade update",
+			message: Message{
+				ChannelKey:     MarketTradesChannel,
+				SequenceNumKey: 7.0,
+				EventsKey: []interface{}{
+					map[string]interface{}{
+						TypeKey: UpdateEventType,
+						TradesKey: []interface{}{
+							map[string]interface{}{
+								TradeIDKey:   "11",
+								ProductIDKey: "A-B",
+								PriceKey:     "10.5",
+								SizeKey:      "2",
+								SideKey:      "BUY",
+								TimeKey:      "2022-05-01T17:59:59.5Z",
+							},
+						},
+					},
+				},
+			},
+			expectedUpdate: Update{
+				Matches: []Match{
+					{
+						Price:      10.5,
+						ProductID:  "A-B",
+						ReceivedAt: receivedAt,
+						Sequence:   7,
+						Side:       BuySide,
+						Size:       2,
+						Time:       time.Date(2022, 5, 1, 17, 59, 59, 5e8, time.UTC),
+						TradeID:    "11",
+					},
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "snapshot sorted from oldest to newest",
+			message: Message{
+				ChannelKey: MarketTradesChannel,
+				EventsKey: []interface{}{
+					map[string]interface{}{
+						TypeKey: SnapshotEventType,
+						TradesKey: []interface{}{
+							map[string]interface{}{
+								TradeIDKey:   "2",
+								ProductIDKey: "A-B",
+								PriceKey:     "11",
+								SizeKey:      "1",
+								SideKey:      "SELL",
+								TimeKey:      "2022-05-01T17:00:02Z",
+							},
+							map[string]interface{}{
+								TradeIDKey:   "1",
+								ProductIDKey: "A-B",
+								PriceKey:     "10",
+								SizeKey:      "1",
+								SideKey:      "BUY",
+								TimeKey:      "2022-05-01T17:00:01Z",
+							},
+						},
+					},
+				},
+			},
+			expectedUpdate: Update{
+				Matches: []Match{
+					{
+						IsLast:     true,
+						Price:      10,
+						ProductID:  "A-B",
+						ReceivedAt: receivedAt,
+						Side:       BuySide,
+						Size:       1,
+						Time:       time.Date(2022, 5, 1, 17, 0, 1, 0, time.UTC),
+						TradeID:    "1",
+					},
+					{
+						IsLast:     true,
+						Price:      11,
+						ProductID:  "A-B",
+						ReceivedAt: receivedAt,
+						Side:       SellSide,
+						Size:       1,
+						Time:       time.Date(2022, 5, 1, 17, 0, 2, 0, time.UTC),
+						TradeID:    "2",
+					},
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "invalid trade skipped",
+			message: Message{
+				ChannelKey: MarketTradesChannel,
+				EventsKey: []interface{}{
+					map[string]interface{}{
+						TypeKey: UpdateEventType,
+						TradesKey: []interface{}{
+							map[string]interface{}{
+								ProductIDKey: "A-B",
+								PriceKey:     "abc",
+								SizeKey:      "1",
+							},
+							map[string]interface{}{
+								ProductIDKey: "A-B",
+								PriceKey:     "10",
+								SizeKey:      "1",
+							},
+						},
+					},
+				},
+			},
+			expectedUpdate: Update{
+				Matches: []Match{
+					{
+						Price:      10,
+						ProductID:  "A-B",
+						ReceivedAt: receivedAt,
+						Size:       1,
+					},
+				},
+			},
+			expectedError: errors.New("error parsing match data: error parsing \"price\" field: strconv.ParseFloat: parsing \"abc\": invalid syntax"),
+		},
+		{
+			desc: "invalid trade time kept",
+			message: Message{
+				ChannelKey: MarketTradesChannel,
+				EventsKey: []interface{}{
+					map[string]interface{}{
+						TypeKey: UpdateEventType,
+						TradesKey: []interface{}{
+							map[string]interface{}{
+								ProductIDKey: "A-B",
+								PriceKey:     "10",
+								SizeKey:      "1",
+								TimeKey:      "yesterday",
+							},
+						},
+					},
+				},
+			},
+			expectedUpdate: Update{
+				Matches: []Match{
+					{
+						Price:      10,
+						ProductID:  "A-B",
+						ReceivedAt: receivedAt,
+						Size:       1,
+					},
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "heartbeat",
+			message: Message{
+				ChannelKey: HeartbeatsChannel,
+				EventsKey: []interface{}{
+					map[string]interface{}{
+						CurrentTimeKey:      "2022-05-01 17:59:58.25 +0000 UTC m=+91717.525857105",
+						HeartbeatCounterKey: "3049",
+					},
+				},
+			},
+			expectedUpdate: Update{
+				Heartbeats: []Heartbeat{
+					{
+						ReceivedAt: receivedAt,
+						Sequence:   3049,
+						Time:       time.Date(2022, 5, 1, 17, 59, 58, 25e7, time.UTC),
+					},
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "heartbeat without events",
+			message: Message{
+				ChannelKey: HeartbeatsChannel,
+			},
+			expectedUpdate: Update{},
+			expectedError:  errors.New("error parsing heartbeat data: missing heartbeat event"),
+		},
+	}
+
+	for _, tc := range testCases {
+		update, err := advancedTradeFormat{}.parseUpdate(tc.message)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedUpdate.Matches, update.Matches,
+			"For test %q, got unexpected matches", tc.desc)
+		assert.Equal(t, len(tc.expectedUpdate.Heartbeats),
+			len(update.Heartbeats),
+			"For test %q, got unexpected number of heartbeats", tc.desc)
+		for i := range update.Heartbeats {
+			assert.True(t,
+				tc.expectedUpdate.Heartbeats[i].Time.Equal(
+					update.Heartbeats[i].Time),
+				"For test %q, got unexpected heartbeat time", tc.desc)
+			update.Heartbeats[i].Time = tc.expectedUpdate.Heartbeats[i].Time
+			assert.Equal(t, tc.expectedUpdate.Heartbeats[i],
+				update.Heartbeats[i],
+				"For test %q, got unexpected heartbeat", tc.desc)
+		}
+	}
+}
+
+func Test_advancedTradeFormatSubscribeMessages(t *testing.T) {
+	messages, err := advancedTradeFormat{}.newSubscribeMessages(
+		[]string{"A-B"}, nil)
+	assert.Nil(t, err, "Got unexpected error")
+	assert.Equal(t, []Message{
+		{
+			TypeKey:       SubscribeType,
+			ChannelKey:    MarketTradesChannel,
+			ProductIDsKey: []string{"A-B"},
+		},
+		{
+			TypeKey:    SubscribeType,
+			ChannelKey: HeartbeatsChannel,
+		},
+	}, messages, "Got unexpected subscribe messages")
+
+	_, err = advancedTradeFormat{}.newSubscribeMessages([]string{"A-B"},
+		&Credentials{})
+	assert.Equal(t, errors.New("signed subscriptions are not supported by "+
+		"the \"advanced-trade\" feed format"), err,
+		"Got unexpected error for signed subscription")
+
+	assert.Equal(t, []Message{
+		{
+			TypeKey:       UnsubscribeType,
+			ChannelKey:    MarketTradesChannel,
+			ProductIDsKey: []string{"A-B"},
+		},
+	}, advancedTradeFormat{}.newUnsubscribeMessages([]string{"A-B"}),
+		"Got unexpected unsubscribe messages")
+}
+
+func Test_CreateSubscriptionAdvancedTrade(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		replies       []Message
+		expectedError error
+	}{
+		{
+			desc: "subscription rejected",
+			replies: []Message{
+				{TypeKey: ErrorType, MessageKey: "failure to subscribe"},
+			},
+			expectedError: errors.New("subscription rejected: failure to subscribe: "),
+		},
+		{
+			desc: "product missing from confirmation",
+			replies: []Message{
+				{
+					ChannelKey: SubscriptionsChannel,
+					EventsKey: []interface{}{
+						map[string]interface{}{
+							SubscriptionsKey: map[string]interface{}{
+								MarketTradesChannel: []interface{}{"A-B"},
+							},
+						},
+					},
+				},
+			},
+			expectedError: &RejectedProductsError{ProductIDs: []string{"C-D"}},
+		},
+		{
+			desc: "subscription confirmed",
+			replies: []Message{
+				{
+					ChannelKey: HeartbeatsChannel,
+				},
+				{
+					ChannelKey: SubscriptionsChannel,
+					EventsKey: []interface{}{
+						map[string]interface{}{
+							SubscriptionsKey: map[string]interface{}{
+								HeartbeatsChannel:   []interface{}{"heartbeats"},
+								MarketTradesChannel: []interface{}{"A-B", "C-D"},
+							},
+						},
+					},
+				},
+				{
+					ChannelKey: MarketTradesChannel,
+					EventsKey: []interface{}{
+						map[string]interface{}{
+							TypeKey: UpdateEventType,
+							TradesKey: []interface{}{
+								map[string]interface{}{
+									ProductIDKey: "C-D",
+									PriceKey:     "10",
+									SizeKey:      "1",
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedError: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		testServer := newReplyServer(tc.replies)
+		endpoint := strings.Replace(testServer.URL, "http", "ws", 1)
+
+		conn, err := CreateSubscription(Config{
+			Endpoint:            endpoint,
+			Format:              AdvancedTradeFormat,
+			ProductIDs:          []string{"A-B", "C-D"},
+			ConfirmationTimeout: time.Second,
+			ReadTimeout:         time.Second,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+
+		if err == nil {
+			// The trade following the confirmation is read as a match.
+			var matches []Match
+			conn.ReadUpdates(func(update Update, err error) {
+				if err == nil && len(update.Matches) > 0 {
+					matches = update.Matches
+					conn.Close()
+				}
+			})
+
+			assert.Len(t, matches, 1,
+				"For test %q, got unexpected number of matches", tc.desc)
+		}
+
+		testServer.Close()
+	}
+}
@@ -0,0 +1,118 @@
+package feed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Keys used in authenticated subscribe messages.
+const (
+	KeyKey        string = "key"
+	PassphraseKey string = "passphrase"
+	SignatureKey  string = "signature"
+	TimestampKey  string = "timestamp"
+)
+
+// Environment variables to load credentials from.
+const (
+	APIKeyEnv        string = "VWAP_API_KEY"
+	APISecretEnv     string = "VWAP_API_SECRET"
+	APIPassphraseEnv string = "VWAP_API_PASSPHRASE"
+)
+
+// Request path signed by subscribe messages. The exchange authenticates feed
+// subscriptions as if they were a GET request to this path.
+const signaturePath string = "/users/self/verify"
+
+// Credentials hold the API key used to sign subscribe messages.
+type Credentials struct {
+	Key        string
+	Secret     []byte // Decoded from the base64 secret given by the exchange.
+	Passphrase string
+}
+
+// rawCredentials is the format of a credentials file.
+type rawCredentials struct {
+	Key        string `json:"key"`
+	Secret     string `json:"secret"`
+	Passphrase string `json:"passphrase"`
+}
+
+// LoadCredentials loads the API credentials from the given file, if set, or
+// from the environment otherwise. Since the file holds secrets, it must not be
+// accessible by group or others. No credentials, and no error, are returned if
+// the file is not set and none of the environment variables are.
+func LoadCredentials(file string) (*Credentials, error) {
+	var raw rawCredentials
+
+	if file != "" {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credentials file: %v", err)
+		}
+
+		if info.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("credentials file %q must not be "+
+				"accessible by group or others, got mode %v", file,
+				info.Mode().Perm())
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading credentials file: %v", err)
+		}
+
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("error parsing credentials file %q: %v",
+				file, err)
+		}
+	} else {
+		raw = rawCredentials{
+			Key:        os.Getenv(APIKeyEnv),
+			Secret:     os.Getenv(APISecretEnv),
+			Passphrase: os.Getenv(APIPassphraseEnv),
+		}
+
+		if raw == (rawCredentials{}) {
+			return nil, nil
+		}
+	}
+
+	if raw.Key == "" || raw.Secret == "" || raw.Passphrase == "" {
+		return nil, errors.New("incomplete credentials: key, secret and " +
+			"passphrase are all required")
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(raw.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding credentials secret: %v", err)
+	}
+
+	return &Credentials{
+		Key:        raw.Key,
+		Secret:     secret,
+		Passphrase: raw.Passphrase,
+	}, nil
+}
+
+// sign adds the authentication fields to the given subscribe message. The
+// signature is the base64 encoded HMAC-SHA256, keyed by the secret, of the
+// current timestamp followed by the signed request method and path.
+func (c *Credentials) sign(message Message) {
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(timestamp + "GET" + signaturePath))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	message[KeyKey] = c.Key
+	message[PassphraseKey] = c.Passphrase
+	message[SignatureKey] = signature
+	message[TimestampKey] = timestamp
+}
@@ -0,0 +1,289 @@
+// +build unit
+
+package feed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// Credentials used in tests. The secret decodes to "secret".
+const (
+	testAPIKey        string = "test-key"
+	testAPISecret     string = "c2VjcmV0"
+	testAPIPassphrase string = "test-passphrase"
+)
+
+func Test_LoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), perm); err != nil {
+			t.Fatalf("Error writing %q: %v", path, err)
+		}
+		// Enforce the mode regardless of the umask.
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatalf("Error changing mode of %q: %v", path, err)
+		}
+		return path
+	}
+
+	validFile := writeFile("valid.json", `{"key": "test-key", `+
+		`"secret": "c2VjcmV0", "passphrase": "test-passphrase"}`, 0600)
+	openFile := writeFile("open.json", `{}`, 0644)
+	invalidFile := writeFile("invalid.json", `not json`, 0600)
+	incompleteFile := writeFile("incomplete.json", `{"key": "test-key"}`,
+		0600)
+	badSecretFile := writeFile("bad_secret.json", `{"key": "test-key", `+
+		`"secret": "not base64!", "passphrase": "test-passphrase"}`, 0600)
+
+	expectedCredentials := &Credentials{
+		Key:        testAPIKey,
+		Secret:     []byte("secret"),
+		Passphrase: testAPIPassphrase,
+	}
+
+	testCases := []struct {
+		desc                string
+		file                string
+		env                 map[string]string
+		expectedCredentials *Credentials
+		expectedError       error
+	}{
+		{
+			desc:                "no file and no environment",
+			expectedCredentials: nil,
+			expectedError:       nil,
+		},
+		{
+			desc: "environment",
+			env: map[string]string{
+				APIKeyEnv:        testAPIKey,
+				APISecretEnv:     testAPISecret,
+				APIPassphraseEnv: testAPIPassphrase,
+			},
+			expectedCredentials: expectedCredentials,
+			expectedError:       nil,
+		},
+		{
+			desc: "incomplete environment",
+			env: map[string]string{
+				APIKeyEnv: testAPIKey,
+			},
+			expectedCredentials: nil,
+			expectedError:       errors.New("incomplete credentials: key, secret and passphrase are all required"),
+		},
+		{
+			desc:                "valid file",
+			file:                validFile,
+			expectedCredentials: expectedCredentials,
+			expectedError:       nil,
+		},
+		{
+			desc:                "file takes precedence over environment",
+			file:                validFile,
+			env:                 map[string]string{APIKeyEnv: "other-key"},
+			expectedCredentials: expectedCredentials,
+			expectedError:       nil,
+		},
+		{
+			desc:                "missing file",
+			file:                filepath.Join(dir, "missing.json"),
+			expectedCredentials: nil,
+			expectedError:       errors.New("error reading credentials file: stat " + filepath.Join(dir, "missing.json") + ": no such file or directory"),
+		},
+		{
+			desc:                "file accessible by others",
+			file:                openFile,
+			expectedCredentials: nil,
+			expectedError:       errors.New("credentials file \"" + openFile + "\" must not be accessible by group or others, got mode -rw-r--r--"),
+		},
+		{
+			desc:                "invalid file",
+			file:                invalidFile,
+			expectedCredentials: nil,
+			expectedError:       errors.New("error parsing credentials file \"" + invalidFile + "\": invalid character 'o' in literal null (expecting 'u')"),
+		},
+		{
+			desc:                "incomplete file",
+			file:                incompleteFile,
+			expectedCredentials: nil,
+			expectedError:       errors.New("incomplete credentials: key, secret and passphrase are all required"),
+		},
+		{
+			desc:                "invalid secret",
+			file:                badSecretFile,
+			expectedCredentials: nil,
+			expectedError:       errors.New("error decoding credentials secret: illegal base64 data at input byte 3"),
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, name := range []string{APIKeyEnv, APISecretEnv,
+			APIPassphraseEnv} {
+			t.Setenv(name, tc.env[name])
+		}
+
+		credentials, err := LoadCredentials(tc.file)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedCredentials, credentials,
+			"For test %q, got unexpected credentials", tc.desc)
+	}
+}
+
+func Test_newSubscribeMessageSigned(t *testing.T) {
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return time.Unix(1600000000, 0) }
+
+	credentials := &Credentials{
+		Key:        testAPIKey,
+		Secret:     []byte("secret"),
+		Passphrase: testAPIPassphrase,
+	}
+
+	testCases := []struct {
+		desc            string
+		credentials     *Credentials
+		expectedMessage Message
+	}{
+		{
+			desc:        "unsigned",
+			credentials: nil,
+			expectedMessage: Message{
+				TypeKey:       SubscribeType,
+				ChannelsKey:   []string{"matches"},
+				ProductIDsKey: []string{"A-B"},
+			},
+		},
+		{
+			desc:        "signed",
+			credentials: credentials,
+			expectedMessage: Message{
+				TypeKey:       SubscribeType,
+				ChannelsKey:   []string{"matches"},
+				ProductIDsKey: []string{"A-B"},
+				KeyKey:        testAPIKey,
+				PassphraseKey: testAPIPassphrase,
+				SignatureKey:  "hyKv1TAlU08qsWI+EV8fA85pkTzE8WoSqxRp/4MtO0c=",
+				TimestampKey:  "1600000000",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		message := newSubscribeMessage([]string{"matches"}, []string{"A-B"},
+			tc.credentials)
+		assert.Equal(t, tc.expectedMessage, message,
+			"For test %q, got unexpected message", tc.desc)
+	}
+}
+
+// newAuthServer spins up a test server that checks the signature of the
+// subscribe message against the test credentials, replying with either a
+// subscriptions or an error message.
+func newAuthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			wsUpgrader := ws.Upgrader{}
+			c, err := wsUpgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			defer c.Close()
+			var message Message
+			if err := c.ReadJSON(&message); err != nil {
+				return
+			}
+
+			timestamp := message.GetValueForKey(TimestampKey)
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(timestamp + "GET" + signaturePath))
+			expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+			reply := Message{
+				TypeKey:     SubscriptionsType,
+				ChannelsKey: []string{},
+			}
+			if message.GetValueForKey(KeyKey) != testAPIKey ||
+				message.GetValueForKey(PassphraseKey) != testAPIPassphrase ||
+				message.GetValueForKey(SignatureKey) != expected {
+				reply = Message{
+					TypeKey:    ErrorType,
+					MessageKey: "Authentication Failed",
+				}
+			}
+
+			if err := c.WriteJSON(reply); err != nil {
+				return
+			}
+
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+}
+
+func Test_CreateSubscriptionAuthenticated(t *testing.T) {
+	testServer := newAuthServer()
+	defer testServer.Close()
+	endpoint := strings.Replace(testServer.URL, "http", "ws", 1)
+
+	testCases := []struct {
+		desc          string
+		credentials   *Credentials
+		expectedError error
+	}{
+		{
+			desc:          "no credentials",
+			credentials:   nil,
+			expectedError: errors.New("subscription rejected: Authentication Failed: "),
+		},
+		{
+			desc: "wrong secret",
+			credentials: &Credentials{
+				Key:        testAPIKey,
+				Secret:     []byte("wrong"),
+				Passphrase: testAPIPassphrase,
+			},
+			expectedError: errors.New("subscription rejected: Authentication Failed: "),
+		},
+		{
+			desc: "valid credentials",
+			credentials: &Credentials{
+				Key:        testAPIKey,
+				Secret:     []byte("secret"),
+				Passphrase: testAPIPassphrase,
+			},
+			expectedError: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		conn, err := CreateSubscription(Config{
+			Endpoint:            endpoint,
+			ConfirmationTimeout: time.Second,
+			Credentials:         tc.credentials,
+		})
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
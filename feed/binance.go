@@ -0,0 +1,160 @@
+package feed
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Keys used in Binance trade messages.
+const (
+	binanceBuyerIsMakerKey string = "m"
+	binanceEventTypeKey    string = "e"
+	binancePriceKey        string = "p"
+	binanceQuantityKey     string = "q"
+	binanceSymbolKey       string = "s"
+	binanceTradeIDKey      string = "t"
+	binanceTradeTimeKey    string = "T"
+)
+
+// Binance methods and event types.
+const (
+	binanceSubscribeMethod   string = "SUBSCRIBE"
+	binanceUnsubscribeMethod string = "UNSUBSCRIBE"
+	binanceTradeEventType    string = "trade"
+)
+
+// ID of Binance requests. Replies carry the ID of their request, but there is
+// only ever one outstanding subscribe request to confirm.
+const binanceRequestID float64 = 1
+
+// binanceFormat is the format of the Binance raw trade streams. Symbols are
+// the base and quote currencies concatenated, which cannot be split back, so
+// they are mapped to the product IDs they were subscribed for. Binance sends
+// no heartbeats.
+type binanceFormat struct {
+	symbols *symbolMap
+}
+
+func newBinanceFormat() binanceFormat {
+	return binanceFormat{symbols: newSymbolMap()}
+}
+
+func (binanceFormat) defaultEndpoint() string {
+	return "wss://stream.binance.com:9443/ws"
+}
+
+func (binanceFormat) hasHeartbeats() bool {
+	return false
+}
+
+func (f binanceFormat) newSubscribeMessages(
+	productIDs []string, credentials *Credentials,
+) ([]Message, error) {
+	if credentials != nil {
+		return nil, errUnsupportedCredentials(BinanceFormat)
+	}
+
+	symbols, err := getSymbols(productIDs, toBinanceSymbol)
+	if err != nil {
+		return nil, err
+	}
+	f.symbols.add(symbols, productIDs)
+
+	return []Message{newBinanceMessage(binanceSubscribeMethod, symbols)}, nil
+}
+
+func (binanceFormat) newUnsubscribeMessages(productIDs []string) []Message {
+	// Invalid product IDs could never be subscribed to, so they are skipped.
+	var symbols []string
+	for _, id := range productIDs {
+		if base, quote, err := splitProductID(id); err == nil {
+			symbols = append(symbols, toBinanceSymbol(base, quote))
+		}
+	}
+
+	return []Message{newBinanceMessage(binanceUnsubscribeMethod, symbols)}
+}
+
+// parseConfirmation parses the reply to a subscribe request, which either
+// confirms or rejects all of its streams.
+func (binanceFormat) parseConfirmation(
+	message Message, productIDs []string,
+) (subscriptionStatus, bool, error) {
+	if id, _ := message[IDKey].(float64); id != binanceRequestID {
+		return subscriptionStatus{}, false, nil
+	}
+
+	if rawError, ok := message[ErrorKey].(map[string]interface{}); ok {
+		replyError := Message(rawError)
+		return subscriptionStatus{}, false, fmt.Errorf("subscription "+
+			"rejected: %s", replyError.GetValueForKey(MsgKey))
+	}
+
+	if _, ok := message[ResultKey]; !ok {
+		return subscriptionStatus{}, false, nil
+	}
+	return subscriptionStatus{complete: true}, true, nil
+}
+
+// parseUpdate parses trade messages. The side of the maker order follows from
+// whether the buyer is the maker.
+func (f binanceFormat) parseUpdate(message Message) (Update, error) {
+	if message.GetValueForKey(binanceEventTypeKey) != binanceTradeEventType {
+		return Update{}, nil
+	}
+
+	price, err := message.GetFloatForKey(binancePriceKey)
+	if err != nil {
+		return Update{}, fmt.Errorf("error parsing match data: %v", err)
+	}
+
+	size, err := message.GetFloatForKey(binanceQuantityKey)
+	if err != nil {
+		return Update{}, fmt.Errorf("error parsing match data: %v", err)
+	}
+
+	side := SellSide
+	if buyerIsMaker, _ := message[binanceBuyerIsMakerKey].(bool); buyerIsMaker {
+		side = BuySide
+	}
+
+	var tradeTime time.Time
+	if ms, ok := message[binanceTradeTimeKey].(float64); ok {
+		tradeTime = time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+	}
+
+	return Update{
+		Matches: []Match{
+			{
+				Price: price,
+				ProductID: f.symbols.getProductID(
+					message.GetValueForKey(binanceSymbolKey)),
+				ReceivedAt: now(),
+				Side:       side,
+				Size:       size,
+				Time:       tradeTime,
+				TradeID:    message.GetIDForKey(binanceTradeIDKey),
+			},
+		},
+	}, nil
+}
+
+// newBinanceMessage returns a request with the given method for the trade
+// streams of the given symbols.
+func newBinanceMessage(method string, symbols []string) Message {
+	streams := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		streams = append(streams, strings.ToLower(symbol)+"@trade")
+	}
+
+	return Message{
+		MethodKey: method,
+		ParamsKey: streams,
+		IDKey:     binanceRequestID,
+	}
+}
+
+func toBinanceSymbol(base, quote string) string {
+	return strings.ToUpper(base + quote)
+}
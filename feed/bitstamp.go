@@ -0,0 +1,178 @@
+package feed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys used in Bitstamp trade messages.
+const (
+	bitstampAmountKey         string = "amount_str"
+	bitstampMicrotimestampKey string = "microtimestamp"
+	bitstampPriceKey          string = "price_str"
+)
+
+// Bitstamp events.
+const (
+	bitstampErrorEvent       string = "bts:error"
+	bitstampSubscribeEvent   string = "bts:subscribe"
+	bitstampSubscribedEvent  string = "bts:subscription_succeeded"
+	bitstampTradeEvent       string = "trade"
+	bitstampUnsubscribeEvent string = "bts:unsubscribe"
+)
+
+// Prefix of the names of Bitstamp trade channels.
+const bitstampTradeChannelPrefix string = "live_trades_"
+
+// Bitstamp trade types, which are the side of the taker order.
+const (
+	bitstampBuyType  float64 = 0
+	bitstampSellType float64 = 1
+)
+
+// bitstampFormat is the format of the Bitstamp WebSocket API v2. Each product
+// has its own trade channel, named after the base and quote currencies
+// concatenated, so channels are mapped to the product IDs they were
+// subscribed for. Bitstamp sends no heartbeats.
+type bitstampFormat struct {
+	channels *symbolMap
+}
+
+func newBitstampFormat() bitstampFormat {
+	return bitstampFormat{channels: newSymbolMap()}
+}
+
+func (bitstampFormat) defaultEndpoint() string {
+	return "wss://ws.bitstamp.net"
+}
+
+func (bitstampFormat) hasHeartbeats() bool {
+	return false
+}
+
+// newSubscribeMessages returns one message for each product, as Bitstamp only
+// takes one channel per subscribe message.
+func (f bitstampFormat) newSubscribeMessages(
+	productIDs []string, credentials *Credentials,
+) ([]Message, error) {
+	if credentials != nil {
+		return nil, errUnsupportedCredentials(BitstampFormat)
+	}
+
+	channels, err := getSymbols(productIDs, toBitstampChannel)
+	if err != nil {
+		return nil, err
+	}
+	f.channels.add(channels, productIDs)
+
+	return newBitstampMessages(bitstampSubscribeEvent, channels), nil
+}
+
+func (bitstampFormat) newUnsubscribeMessages(productIDs []string) []Message {
+	// Invalid product IDs could never be subscribed to, so they are skipped.
+	var channels []string
+	for _, id := range productIDs {
+		if base, quote, err := splitProductID(id); err == nil {
+			channels = append(channels, toBitstampChannel(base, quote))
+		}
+	}
+
+	return newBitstampMessages(bitstampUnsubscribeEvent, channels)
+}
+
+// parseConfirmation parses the confirmation the exchange sends for each
+// channel subscribed to.
+func (f bitstampFormat) parseConfirmation(
+	message Message, productIDs []string,
+) (subscriptionStatus, bool, error) {
+	switch message.GetValueForKey(EventKey) {
+	case bitstampSubscribedEvent:
+		channel := message.GetValueForKey(ChannelKey)
+		return subscriptionStatus{
+			confirmed: []string{f.channels.getProductID(channel)},
+		}, true, nil
+	case bitstampErrorEvent:
+		rawData, _ := message[DataKey].(map[string]interface{})
+		data := Message(rawData)
+		return subscriptionStatus{}, false, fmt.Errorf("subscription "+
+			"rejected: %s", data.GetValueForKey(MessageKey))
+	default:
+		return subscriptionStatus{}, false, nil
+	}
+}
+
+// parseUpdate parses trade messages. Bitstamp reports the side of the taker
+// order, so the maker side is its opposite.
+func (f bitstampFormat) parseUpdate(message Message) (Update, error) {
+	if message.GetValueForKey(EventKey) != bitstampTradeEvent {
+		return Update{}, nil
+	}
+
+	rawData, _ := message[DataKey].(map[string]interface{})
+	data := Message(rawData)
+
+	price, err := data.GetFloatForKey(bitstampPriceKey)
+	if err != nil {
+		return Update{}, fmt.Errorf("error parsing match data: %v", err)
+	}
+
+	size, err := data.GetFloatForKey(bitstampAmountKey)
+	if err != nil {
+		return Update{}, fmt.Errorf("error parsing match data: %v", err)
+	}
+
+	var side string
+	switch data[TypeKey] {
+	case bitstampBuyType:
+		side = SellSide
+	case bitstampSellType:
+		side = BuySide
+	}
+
+	var tradeTime time.Time
+	if microtimestamp := data.GetValueForKey(
+		bitstampMicrotimestampKey); microtimestamp != "" {
+		us, err := strconv.ParseInt(microtimestamp, 10, 64)
+		if err != nil {
+			return Update{}, fmt.Errorf("error parsing match data: error "+
+				"parsing %q field: %v", bitstampMicrotimestampKey, err)
+		}
+		tradeTime = time.Unix(0, us*int64(time.Microsecond)).UTC()
+	}
+
+	return Update{
+		Matches: []Match{
+			{
+				Price: price,
+				ProductID: f.channels.getProductID(
+					message.GetValueForKey(ChannelKey)),
+				ReceivedAt: now(),
+				Side:       side,
+				Size:       size,
+				Time:       tradeTime,
+				TradeID:    data.GetIDForKey(IDKey),
+			},
+		},
+	}, nil
+}
+
+// newBitstampMessages returns one message with the given event for each of
+// the given channels.
+func newBitstampMessages(event string, channels []string) []Message {
+	messages := make([]Message, 0, len(channels))
+	for _, channel := range channels {
+		messages = append(messages, Message{
+			EventKey: event,
+			DataKey: map[string]interface{}{
+				ChannelKey: channel,
+			},
+		})
+	}
+	return messages
+}
+
+func toBitstampChannel(base, quote string) string {
+	return bitstampTradeChannelPrefix + strings.ToLower(base+quote)
+}
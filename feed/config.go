@@ -0,0 +1,75 @@
+package feed
+
+import (
+	"net/http"
+	"time"
+)
+
+// Config holds the parameters for a feed subscription.
+type Config struct {
+	// WebSocket endpoint to connect to.
+	Endpoint string
+
+	// Wire format of the feed, e.g. ExchangeFormat, which is the default if
+	// empty.
+	Format string
+
+	// Name of the venue, set in every match read from the feed. Defaults to
+	// the format name if empty.
+	Venue string
+
+	// Product IDs to subscribe to.
+	ProductIDs []string
+
+	// Maximum time to wait for the exchange to confirm the subscription. The
+	// confirmation is not awaited if zero.
+	ConfirmationTimeout time.Duration
+
+	// Indicates if products missing from the exchange confirmation should only
+	// be logged, instead of failing the subscription. The subscription still
+	// fails with a RejectedProductsError if every product is rejected, either
+	// one by one or by an error rejecting the whole subscription, since the
+	// connection would then carry no matches.
+	AllowRejectedProducts bool
+
+	// Interval between pings sent to keep the connection alive. No pings are
+	// sent if zero.
+	PingInterval time.Duration
+
+	// Maximum time without receiving any message or pong, after which reads
+	// fail and the connection is considered dead. Reads never time out if
+	// zero. It must be longer than the ping interval.
+	ReadTimeout time.Duration
+
+	// Maximum time for writing a message, including pings. Writes never time
+	// out if zero.
+	WriteTimeout time.Duration
+
+	// URL of the HTTP or SOCKS5 proxy to connect through, e.g.
+	// socks5://proxy:1080. Proxies set in the environment are used if empty.
+	ProxyURL string
+
+	// PEM file with the certificate authorities to verify the exchange with,
+	// instead of the system ones.
+	CAFile string
+
+	// PEM files with the client certificate and key to present to the
+	// exchange, if any. Both must be set together.
+	CertFile string
+	KeyFile  string
+
+	// Server name to verify the exchange certificate against, if different
+	// from the endpoint host.
+	ServerName string
+
+	// Maximum time for the WebSocket opening handshake. The default dialer
+	// timeout is used if zero.
+	HandshakeTimeout time.Duration
+
+	// Additional headers to send in the opening handshake.
+	Headers http.Header
+
+	// Credentials to sign subscribe messages with, for channels that require
+	// authentication. Subscriptions are not signed if nil.
+	Credentials *Credentials
+}
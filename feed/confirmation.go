@@ -0,0 +1,178 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// RejectedProductsError is returned when the exchange does not confirm the
+// subscription for some of the requested products, e.g. due to misspelled
+// product IDs.
+type RejectedProductsError struct {
+	ProductIDs []string
+}
+
+func (e *RejectedProductsError) Error() string {
+	return fmt.Sprintf("subscription rejected for products %v", e.ProductIDs)
+}
+
+// subscriptionStatus holds the products whose subscription is confirmed or
+// rejected by a message.
+type subscriptionStatus struct {
+	confirmed []string
+	rejected  []string
+
+	// Indicates if the message settles the whole subscription, confirming
+	// every product not rejected.
+	complete bool
+}
+
+// awaitConfirmation reads messages from the given connection until the
+// exchange either confirms or rejects the subscription to each of the given
+// products, or the timeout expires. It returns the rejected products, along
+// with the other messages received meanwhile, e.g. the trade snapshot sent
+// after each product is confirmed, so that they can still be read.
+func awaitConfirmation(
+	conn *ws.Conn, f format, productIDs []string, timeout time.Duration,
+) ([]string, []Message, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, nil, fmt.Errorf("error setting read deadline: %v", err)
+	}
+
+	pending := make(map[string]bool, len(productIDs))
+	for _, id := range productIDs {
+		pending[id] = true
+	}
+	rejected := make(map[string]bool)
+	var messages []Message
+
+	// At least one confirmation is awaited, even with no products, so that
+	// a rejection of the whole subscription is not missed.
+	for settled := false; !settled; {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			return nil, nil, fmt.Errorf("error waiting for subscription "+
+				"confirmation: %v", err)
+		}
+
+		status, isConfirmation, err := f.parseConfirmation(message,
+			productIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !isConfirmation {
+			messages = append(messages, message)
+			continue
+		}
+
+		for _, id := range status.confirmed {
+			delete(pending, id)
+		}
+		for _, id := range status.rejected {
+			delete(pending, id)
+			rejected[id] = true
+		}
+		settled = status.complete || len(pending) == 0
+	}
+
+	// Clear the deadline, so that it does not affect later reads.
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, nil, fmt.Errorf("error clearing read deadline: %v", err)
+	}
+
+	var rejectedIDs []string
+	for _, id := range productIDs {
+		if rejected[id] {
+			rejectedIDs = append(rejectedIDs, id)
+		}
+	}
+	return rejectedIDs, messages, nil
+}
+
+// parseSubscriptionError returns the error for a message rejecting the
+// subscription to the given products. The exchange rejects the whole
+// subscription if any product is invalid, naming the offending product in the
+// reason.
+func parseSubscriptionError(message Message, productIDs []string) error {
+	reason := message.GetValueForKey(ReasonKey)
+	var rejected []string
+	for _, id := range productIDs {
+		if strings.Contains(reason, id) {
+			rejected = append(rejected, id)
+		}
+	}
+
+	if len(rejected) > 0 {
+		return &RejectedProductsError{ProductIDs: rejected}
+	}
+
+	return fmt.Errorf("subscription rejected: %s: %s",
+		message.GetValueForKey(MessageKey), reason)
+}
+
+// getRejectedProducts returns the product IDs that are not confirmed for every
+// exchange channel in the given subscriptions message.
+func getRejectedProducts(message Message, productIDs []string) []string {
+	confirmed, err := parseSubscriptions(message)
+	if err != nil {
+		log.Printf("Error parsing subscription confirmation: %v", err)
+	}
+
+	var rejected []string
+	for _, id := range productIDs {
+		for _, channel := range exchangeChannels {
+			if !confirmed[channel][id] {
+				rejected = append(rejected, id)
+				break
+			}
+		}
+	}
+	return rejected
+}
+
+// parseSubscriptions parses a subscriptions message, returning the set of
+// confirmed product IDs for each channel. Channels may be either objects with
+// their own product IDs, or plain names, using the product IDs at the top
+// level of the message.
+func parseSubscriptions(message Message) (map[string]map[string]bool, error) {
+	confirmed := make(map[string]map[string]bool)
+
+	rawChannels, ok := message[ChannelsKey].([]interface{})
+	if !ok {
+		return confirmed, errors.New("missing channels list")
+	}
+
+	for _, rawChannel := range rawChannels {
+		var name string
+		var rawProductIDs interface{}
+
+		switch channel := rawChannel.(type) {
+		case string:
+			name, rawProductIDs = channel, message[ProductIDsKey]
+		case map[string]interface{}:
+			name, _ = channel[NameKey].(string)
+			rawProductIDs = channel[ProductIDsKey]
+		default:
+			return confirmed, fmt.Errorf("invalid channel %v", rawChannel)
+		}
+
+		if confirmed[name] == nil {
+			confirmed[name] = make(map[string]bool)
+		}
+
+		productIDs, _ := rawProductIDs.([]interface{})
+		for _, rawID := range productIDs {
+			if id, ok := rawID.(string); ok {
+				confirmed[name][id] = true
+			}
+		}
+	}
+
+	return confirmed, nil
+}
@@ -0,0 +1,270 @@
+// +build unit
+
+package feed
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReplyServer spins up a test server that reads the subscribe message and
+// replies with the given messages, keeping the connection open until the
+// client closes it.
+func newReplyServer(replies []Message) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			wsUpgrader := ws.Upgrader{}
+			c, err := wsUpgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			defer c.Close()
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+
+			for _, reply := range replies {
+				if err := c.WriteJSON(reply); err != nil {
+					return
+				}
+			}
+
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+}
+
+func Test_CreateSubscriptionConfirmation(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		replies         []Message
+		allowRejected   bool
+		expectedPending []Message
+		expectedError   error
+	}{
+		{
+			desc:          "no confirmation",
+			replies:       []Message{},
+			expectedError: errors.New("error waiting for subscription confirmation: read tcp"),
+		},
+		{
+			desc: "all products confirmed, after other messages",
+			replies: []Message{
+				Message{TypeKey: "someType"},
+				Message{
+					TypeKey: SubscriptionsType,
+					ChannelsKey: []interface{}{
+						map[string]interface{}{
+							NameKey:       "matches",
+							ProductIDsKey: []interface{}{"A-B", "C-D"},
+						},
+						map[string]interface{}{
+							NameKey:       "heartbeat",
+							ProductIDsKey: []interface{}{"A-B", "C-D"},
+						},
+					},
+				},
+			},
+			expectedPending: []Message{Message{TypeKey: "someType"}},
+			expectedError:   nil,
+		},
+		{
+			desc: "products missing from confirmation",
+			replies: []Message{
+				Message{
+					TypeKey: SubscriptionsType,
+					ChannelsKey: []interface{}{
+						map[string]interface{}{
+							NameKey:       "matches",
+							ProductIDsKey: []interface{}{"A-B", "C-D"},
+						},
+						map[string]interface{}{
+							NameKey:       "heartbeat",
+							ProductIDsKey: []interface{}{"A-B"},
+						},
+					},
+				},
+			},
+			expectedError: &RejectedProductsError{ProductIDs: []string{"C-D"}},
+		},
+		{
+			desc: "products missing from confirmation, allowed",
+			replies: []Message{
+				Message{
+					TypeKey:       SubscriptionsType,
+					ChannelsKey:   []interface{}{"matches", "heartbeat"},
+					ProductIDsKey: []interface{}{"C-D"},
+				},
+			},
+			allowRejected: true,
+			expectedError: nil,
+		},
+		{
+			desc: "all products missing from confirmation, allowed",
+			replies: []Message{
+				Message{
+					TypeKey:       SubscriptionsType,
+					ChannelsKey:   []interface{}{"matches", "heartbeat"},
+					ProductIDsKey: []interface{}{},
+				},
+			},
+			allowRejected: true,
+			expectedError: &RejectedProductsError{
+				ProductIDs: []string{"A-B", "C-D"},
+			},
+		},
+		{
+			desc: "error naming invalid product",
+			replies: []Message{
+				Message{
+					TypeKey:    ErrorType,
+					MessageKey: "Failed to subscribe",
+					ReasonKey:  "C-D is not a valid product",
+				},
+			},
+			allowRejected: true,
+			expectedError: &RejectedProductsError{ProductIDs: []string{"C-D"}},
+		},
+		{
+			desc: "error for unknown reason",
+			replies: []Message{
+				Message{
+					TypeKey:    ErrorType,
+					MessageKey: "Failed to subscribe",
+					ReasonKey:  "some reason",
+				},
+			},
+			expectedError: errors.New("subscription rejected: Failed to subscribe: some reason"),
+		},
+	}
+
+	for _, tc := range testCases {
+		server := newReplyServer(tc.replies)
+		endpoint := strings.Replace(server.URL, "http", "ws", 1)
+
+		conn, err := CreateSubscription(Config{
+			Endpoint:              endpoint,
+			ProductIDs:            []string{"A-B", "C-D"},
+			ConfirmationTimeout:   100 * time.Millisecond,
+			AllowRejectedProducts: tc.allowRejected,
+		})
+
+		if tc.expectedError == nil {
+			assert.Nil(t, err,
+				"For test %q, got unexpected error value", tc.desc)
+		} else if assert.NotNil(t, err,
+			"For test %q, expected error", tc.desc) {
+			assert.Contains(t, err.Error(), tc.expectedError.Error(),
+				"For test %q, got unexpected error value", tc.desc)
+		}
+
+		if conn != nil {
+			assert.Equal(t, tc.expectedPending, conn.pending,
+				"For test %q, got unexpected pending messages", tc.desc)
+			conn.Close()
+		}
+		server.Close()
+	}
+}
+
+func Test_getRejectedProducts(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		message        Message
+		productIDs     []string
+		expectedOutput []string
+	}{
+		{
+			desc:           "missing channels",
+			message:        Message{TypeKey: SubscriptionsType},
+			productIDs:     []string{"A-B"},
+			expectedOutput: []string{"A-B"},
+		},
+		{
+			desc: "invalid channel",
+			message: Message{
+				TypeKey:     SubscriptionsType,
+				ChannelsKey: []interface{}{42.0},
+			},
+			productIDs:     []string{"A-B"},
+			expectedOutput: []string{"A-B"},
+		},
+		{
+			desc: "other channels only",
+			message: Message{
+				TypeKey: SubscriptionsType,
+				ChannelsKey: []interface{}{
+					map[string]interface{}{
+						NameKey:       "ticker",
+						ProductIDsKey: []interface{}{"A-B"},
+					},
+				},
+			},
+			productIDs:     []string{"A-B"},
+			expectedOutput: []string{"A-B"},
+		},
+		{
+			desc: "named channel with top level products",
+			message: Message{
+				TypeKey:       SubscriptionsType,
+				ChannelsKey:   []interface{}{"matches", "heartbeat"},
+				ProductIDsKey: []interface{}{"A-B", "E-F"},
+			},
+			productIDs:     []string{"A-B", "C-D"},
+			expectedOutput: []string{"C-D"},
+		},
+		{
+			desc: "product missing from one channel",
+			message: Message{
+				TypeKey: SubscriptionsType,
+				ChannelsKey: []interface{}{
+					map[string]interface{}{
+						NameKey:       "matches",
+						ProductIDsKey: []interface{}{"A-B", "C-D"},
+					},
+					map[string]interface{}{
+						NameKey:       "heartbeat",
+						ProductIDsKey: []interface{}{"C-D"},
+					},
+				},
+			},
+			productIDs:     []string{"A-B", "C-D"},
+			expectedOutput: []string{"A-B"},
+		},
+		{
+			desc: "all confirmed",
+			message: Message{
+				TypeKey: SubscriptionsType,
+				ChannelsKey: []interface{}{
+					map[string]interface{}{
+						NameKey:       "matches",
+						ProductIDsKey: []interface{}{"A-B", "C-D"},
+					},
+					map[string]interface{}{
+						NameKey:       "heartbeat",
+						ProductIDsKey: []interface{}{"A-B", "C-D"},
+					},
+				},
+			},
+			productIDs:     []string{"A-B", "C-D"},
+			expectedOutput: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		output := getRejectedProducts(tc.message, tc.productIDs)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
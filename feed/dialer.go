@@ -0,0 +1,83 @@
+package feed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// newDialer returns a WebSocket dialer for the given config, along with the
+// headers to send in the opening handshake. Settings left empty fall back to
+// those of the default dialer, including proxies set in the environment.
+func newDialer(config Config) (*ws.Dialer, http.Header, error) {
+	dialer := *ws.DefaultDialer
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing proxy URL %q: %v",
+				config.ProxyURL, err)
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = config.HandshakeTimeout
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer.TLSClientConfig = tlsConfig
+
+	return &dialer, config.Headers.Clone(), nil
+}
+
+// newTLSConfig returns the TLS settings for the given config, or nil if it
+// does not customize TLS.
+func newTLSConfig(config Config) (*tls.Config, error) {
+	if config.CAFile == "" && config.CertFile == "" && config.KeyFile == "" &&
+		config.ServerName == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: config.ServerName}
+
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file %q: %v",
+				config.CAFile, err)
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %q",
+				config.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("client certificate and key files must be " +
+			"set together")
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v",
+				err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,313 @@
+// +build unit
+
+package feed
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePEMFile writes a single PEM block of the given type to a new file in
+// dir, returning its path.
+func writePEMFile(t *testing.T, dir, name, blockType string,
+	bytes []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Error writing %q: %v", path, err)
+	}
+	return path
+}
+
+// generateClientCert generates a self-signed client certificate, returning
+// the paths of its certificate and key files.
+func generateClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vwap"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+
+	return writePEMFile(t, dir, "client.pem", "CERTIFICATE", certDER),
+		writePEMFile(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func Test_newDialerErrors(t *testing.T) {
+	dir := t.TempDir()
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatalf("Error writing empty file: %v", err)
+	}
+	missingFile := filepath.Join(dir, "missing.pem")
+
+	testCases := []struct {
+		desc          string
+		config        Config
+		expectedError error
+	}{
+		{
+			desc:          "invalid proxy URL",
+			config:        Config{ProxyURL: "://proxy"},
+			expectedError: errors.New("error parsing proxy URL \"://proxy\": parse \"://proxy\": missing protocol scheme"),
+		},
+		{
+			desc:          "missing CA file",
+			config:        Config{CAFile: missingFile},
+			expectedError: errors.New("error reading CA file \"" + missingFile + "\": open " + missingFile + ": no such file or directory"),
+		},
+		{
+			desc:          "CA file without certificates",
+			config:        Config{CAFile: emptyFile},
+			expectedError: errors.New("no certificates found in CA file \"" + emptyFile + "\""),
+		},
+		{
+			desc:          "client certificate without key",
+			config:        Config{CertFile: emptyFile},
+			expectedError: errors.New("client certificate and key files must be set together"),
+		},
+		{
+			desc:          "invalid client certificate",
+			config:        Config{CertFile: emptyFile, KeyFile: emptyFile},
+			expectedError: errors.New("error loading client certificate: tls: failed to find any PEM data in certificate input"),
+		},
+	}
+
+	for _, tc := range testCases {
+		_, _, err := newDialer(tc.config)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+	}
+}
+
+func Test_newDialerDefaults(t *testing.T) {
+	dialer, headers, err := newDialer(Config{})
+	assert.Nil(t, err, "Got unexpected error")
+	assert.Nil(t, dialer.TLSClientConfig, "Got unexpected TLS config")
+	assert.NotNil(t, dialer.Proxy, "Expected environment proxy")
+	assert.Equal(t, 45*time.Second, dialer.HandshakeTimeout,
+		"Got unexpected handshake timeout")
+	assert.Nil(t, headers, "Got unexpected headers")
+}
+
+func Test_CreateSubscriptionTLS(t *testing.T) {
+	// The handler only accepts connections with the expected header, if the
+	// path asks for it.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/header" && r.Header.Get("X-Client") != "vwap" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		testEchoServerHandler(w, r)
+	}
+
+	testServer := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer testServer.Close()
+	endpoint := strings.Replace(testServer.URL, "https", "wss", 1)
+
+	caFile := writePEMFile(t, t.TempDir(), "ca.pem", "CERTIFICATE",
+		testServer.Certificate().Raw)
+
+	testCases := []struct {
+		desc        string
+		path        string
+		config      Config
+		expectError bool
+	}{
+		{
+			desc:        "untrusted server certificate",
+			config:      Config{},
+			expectError: true,
+		},
+		{
+			desc:        "trusted server certificate",
+			config:      Config{CAFile: caFile},
+			expectError: false,
+		},
+		{
+			desc:        "matching server name",
+			config:      Config{CAFile: caFile, ServerName: "example.com"},
+			expectError: false,
+		},
+		{
+			desc:        "mismatching server name",
+			config:      Config{CAFile: caFile, ServerName: "example.org"},
+			expectError: true,
+		},
+		{
+			desc:        "missing header",
+			path:        "/header",
+			config:      Config{CAFile: caFile},
+			expectError: true,
+		},
+		{
+			desc: "custom header",
+			path: "/header",
+			config: Config{
+				CAFile:  caFile,
+				Headers: http.Header{"X-Client": []string{"vwap"}},
+			},
+			expectError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		config := tc.config
+		config.Endpoint = endpoint + tc.path
+		config.HandshakeTimeout = time.Second
+
+		conn, err := CreateSubscription(config)
+		assert.Equal(t, tc.expectError, err != nil,
+			"For test %q, got unexpected error value: %v", tc.desc, err)
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+func Test_CreateSubscriptionClientCert(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(
+		http.HandlerFunc(testEchoServerHandler))
+	testServer.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	testServer.StartTLS()
+	defer testServer.Close()
+	endpoint := strings.Replace(testServer.URL, "https", "wss", 1)
+
+	dir := t.TempDir()
+	caFile := writePEMFile(t, dir, "ca.pem", "CERTIFICATE",
+		testServer.Certificate().Raw)
+	certFile, keyFile := generateClientCert(t, dir)
+
+	testCases := []struct {
+		desc        string
+		config      Config
+		expectError bool
+	}{
+		{
+			desc:        "no client certificate",
+			config:      Config{CAFile: caFile},
+			expectError: true,
+		},
+		{
+			desc: "client certificate",
+			config: Config{
+				CAFile:   caFile,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			},
+			expectError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		config := tc.config
+		config.Endpoint = endpoint
+
+		conn, err := CreateSubscription(config)
+		if err == nil {
+			// With TLS 1.3, a missing client certificate is only reported
+			// on the first read.
+			var message Message
+			err = conn.conn.ReadJSON(&message)
+			conn.Close()
+		}
+
+		assert.Equal(t, tc.expectError, err != nil,
+			"For test %q, got unexpected error value: %v", tc.desc, err)
+	}
+}
+
+// testProxyHandler is a minimal HTTP CONNECT proxy, which counts the tunnels it
+// opens.
+type testProxyHandler struct {
+	tunnels int32
+}
+
+func (p *testProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	w.WriteHeader(http.StatusOK)
+	client, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	atomic.AddInt32(&p.tunnels, 1)
+
+	go io.Copy(target, client)
+	io.Copy(client, target)
+}
+
+func Test_CreateSubscriptionProxy(t *testing.T) {
+	echoServer := httptest.NewServer(http.HandlerFunc(testEchoServerHandler))
+	defer echoServer.Close()
+	echoEndpoint := strings.Replace(echoServer.URL, "http", "ws", 1)
+
+	proxy := &testProxyHandler{}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	conn, err := CreateSubscription(Config{
+		Endpoint: echoEndpoint,
+		ProxyURL: proxyServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("Error creating subscription through proxy: %v", err)
+		return
+	}
+
+	var message Message
+	err = conn.conn.ReadJSON(&message)
+	conn.Close()
+
+	assert.Nil(t, err, "Got unexpected error reading through proxy")
+	assert.Equal(t, SubscribeType, message[TypeKey],
+		"Got unexpected message type from echo server")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&proxy.tunnels),
+		"Got unexpected number of proxy tunnels")
+}
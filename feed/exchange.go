@@ -0,0 +1,69 @@
+package feed
+
+import "fmt"
+
+// Channels to subscribe to for each product in the exchange feed. Heartbeats
+// allow telling a quiet product apart from a dead connection.
+var exchangeChannels = []string{"matches", "heartbeat"}
+
+// exchangeFormat is the format of the Coinbase Exchange feed, with match,
+// last_match and heartbeat messages.
+type exchangeFormat struct{}
+
+func (exchangeFormat) defaultEndpoint() string {
+	return "wss://ws-feed.exchange.coinbase.com"
+}
+
+func (exchangeFormat) hasHeartbeats() bool {
+	return true
+}
+
+func (exchangeFormat) newSubscribeMessages(
+	productIDs []string, credentials *Credentials,
+) ([]Message, error) {
+	return []Message{
+		newSubscribeMessage(exchangeChannels, productIDs, credentials),
+	}, nil
+}
+
+func (exchangeFormat) newUnsubscribeMessages(productIDs []string) []Message {
+	return []Message{newUnsubscribeMessage(exchangeChannels, productIDs)}
+}
+
+func (exchangeFormat) parseConfirmation(
+	message Message, productIDs []string,
+) (subscriptionStatus, bool, error) {
+	switch message.GetValueForKey(TypeKey) {
+	case SubscriptionsType:
+		return subscriptionStatus{
+			rejected: getRejectedProducts(message, productIDs),
+			complete: true,
+		}, true, nil
+	case ErrorType:
+		return subscriptionStatus{}, false,
+			parseSubscriptionError(message, productIDs)
+	default:
+		return subscriptionStatus{}, false, nil
+	}
+}
+
+func (exchangeFormat) parseUpdate(message Message) (Update, error) {
+	heartbeat, isHeartbeat, err := ParseHeartbeat(message)
+	if isHeartbeat {
+		if err != nil {
+			return Update{}, fmt.Errorf("error parsing heartbeat data: %v",
+				err)
+		}
+		return Update{Heartbeats: []Heartbeat{heartbeat}}, nil
+	}
+
+	match, isMatch, err := ParseMatch(message)
+	if !isMatch {
+		return Update{}, nil
+	}
+
+	if err != nil {
+		return Update{}, fmt.Errorf("error parsing match data: %v", err)
+	}
+	return Update{Matches: []Match{match}}, nil
+}
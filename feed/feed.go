@@ -1,54 +1,238 @@
 // Package feed provides the object model and operations for sending and
-// receiving WebSocket messages through the Coinbase exchange feed.
+// receiving WebSocket messages through exchange feeds, normalizing the
+// messages of each supported venue.
 package feed
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	ws "github.com/gorilla/websocket"
 )
 
-// CreateSubscription takes as arguments the WebSocket endpoint to connect to,
-// the channels to subscribe to, and product IDs of interest. It creates and
-// returns a connection to the given endpoint, and subscribes to the given
-// channels/products.
-func CreateSubscription(
-	endpoint string, productIDs []string,
-) (*ws.Conn, error) {
+// Conn is a subscription to the exchange feed over a WebSocket connection.
+type Conn struct {
+	// Underlying WebSocket connection.
+	conn *ws.Conn
+
+	// Config used to create the subscription.
+	config Config
+
+	// Wire format of the feed.
+	format format
+
+	// Guards writes to the connection, which may come from several
+	// goroutines.
+	writeMu sync.Mutex
+
+	// Messages read while awaiting the subscription confirmation, passed on
+	// first by ReadMessages.
+	pending []Message
+}
+
+// CreateSubscription takes as argument the subscription config, holding the
+// WebSocket endpoint to connect to and the product IDs of interest. It creates
+// and returns a connection to the given endpoint, and subscribes to the feed
+// channels for the given products. If a confirmation timeout is set, it also
+// waits for the exchange to confirm the subscription. Finally, if a ping
+// interval is set, the connection is kept alive with pings until closed.
+func CreateSubscription(config Config) (*Conn, error) {
+	if config.PingInterval > 0 && config.ReadTimeout > 0 &&
+		config.PingInterval >= config.ReadTimeout {
+		return nil, errors.New("ping interval must be shorter than read " +
+			"timeout")
+	}
+
+	f, err := getFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Venue == "" {
+		config.Venue = config.Format
+		if config.Venue == "" {
+			config.Venue = ExchangeFormat
+		}
+	}
+
+	dialer, headers, err := newDialer(config)
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to WebSocket endpoint.
-	c, _, err := ws.DefaultDialer.Dial(endpoint, nil)
+	wsConn, _, err := dialer.Dial(config.Endpoint, headers)
 	if err != nil {
 		return nil, fmt.Errorf("error dialing WebSocket endpoint %q: %v",
-			endpoint, err)
+			config.Endpoint, err)
 	}
 
+	c := &Conn{conn: wsConn, config: config, format: f}
+
 	// Subscribe to channels.
-	subscribeMessage := newSubscribeMessage([]string{"matches"}, productIDs)
-	if err := c.WriteJSON(subscribeMessage); err != nil {
+	if err := c.Subscribe(config.ProductIDs); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("error writing subscribe message to WebSocket "+
-			"endpoint: %v", err)
+		return nil, err
+	}
+
+	if config.ConfirmationTimeout > 0 {
+		// Check the subscription confirmation.
+		rejected, pending, err := awaitConfirmation(wsConn, f,
+			config.ProductIDs, config.ConfirmationTimeout)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.pending = pending
+
+		if len(rejected) > 0 {
+			if !config.AllowRejectedProducts ||
+				len(rejected) == len(config.ProductIDs) {
+				c.Close()
+				return nil, &RejectedProductsError{ProductIDs: rejected}
+			}
+
+			log.Printf("Warning: subscription not confirmed for products %v",
+				rejected)
+		}
+	}
+
+	if err := c.startKeepalive(); err != nil {
+		c.Close()
+		return nil, err
 	}
 
 	return c, nil
 }
 
-// ReadMessages takes a WebSocket connection and reads incoming messages from
-// it. For each message received, it calls the messageCallback function.
-func ReadMessages(conn *ws.Conn, messageCallback func(Message, error)) {
+// startKeepalive sets the initial read deadline, extends it whenever a pong is
+// received, and spins up a goroutine that sends pings until a ping fails, e.g.
+// because the connection is closed.
+func (c *Conn) startKeepalive() error {
+	if err := c.extendReadDeadline(); err != nil {
+		return fmt.Errorf("error setting read deadline: %v", err)
+	}
+
+	c.conn.SetPongHandler(func(string) error {
+		return c.extendReadDeadline()
+	})
+
+	if c.config.PingInterval <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := c.conn.WriteControl(ws.PingMessage, nil,
+				c.getWriteDeadline()); err != nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// extendReadDeadline moves the read deadline to the read timeout from now, if
+// the connection has a read timeout.
+func (c *Conn) extendReadDeadline() error {
+	if c.config.ReadTimeout <= 0 {
+		return nil
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
+}
+
+// getWriteDeadline returns the deadline for a write started now, which is the
+// zero time if the connection has no write timeout.
+func (c *Conn) getWriteDeadline() time.Time {
+	if c.config.WriteTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.config.WriteTimeout)
+}
+
+// writeJSON writes the given message to the connection, within the write
+// timeout.
+func (c *Conn) writeJSON(message Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(c.getWriteDeadline()); err != nil {
+		return err
+	}
+	return c.conn.WriteJSON(message)
+}
+
+// Subscribe sends the subscribe messages for the given product IDs through the
+// connection, signed if the connection has credentials.
+func (c *Conn) Subscribe(productIDs []string) error {
+	subscribeMessages, err := c.format.newSubscribeMessages(productIDs,
+		c.config.Credentials)
+	if err != nil {
+		return err
+	}
+
+	for _, subscribeMessage := range subscribeMessages {
+		if err := c.writeJSON(subscribeMessage); err != nil {
+			return fmt.Errorf("error writing subscribe message to WebSocket "+
+				"endpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribe sends the unsubscribe messages for the given product IDs through
+// the connection.
+func (c *Conn) Unsubscribe(productIDs []string) error {
+	for _, unsubscribeMessage := range c.format.newUnsubscribeMessages(
+		productIDs) {
+		if err := c.writeJSON(unsubscribeMessage); err != nil {
+			return fmt.Errorf("error writing unsubscribe message to "+
+				"WebSocket endpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection, which also stops the keepalive pings.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessages reads incoming messages from the connection. For each message
+// received, it calls the messageCallback function, starting with the ones
+// read while awaiting the subscription confirmation. Every message received
+// extends the read deadline, so that only a silent connection times out.
+func (c *Conn) ReadMessages(messageCallback func(Message, error)) {
+	pending := c.pending
+	c.pending = nil
+	for _, message := range pending {
+		err := getMessageError(message)
+		messageCallback(message, err)
+		if err != nil {
+			log.Printf("Error reading messages: %v", err)
+			return
+		}
+	}
+
 	for {
 		var message Message
-		err := conn.ReadJSON(&message)
+		err := c.conn.ReadJSON(&message)
 
 		if err != nil {
 			err = fmt.Errorf("error reading JSON WebSocket message: %v", err)
+		} else if err = c.extendReadDeadline(); err != nil {
+			err = fmt.Errorf("error extending read deadline: %v", err)
 		} else {
-			messageType := message.GetValueForKey(TypeKey)
-			if messageType == ErrorType {
-				reason := message.GetValueForKey(ReasonKey)
-				err = fmt.Errorf("error message received: %s", reason)
-			}
+			err = getMessageError(message)
 		}
 
 		messageCallback(message, err)
@@ -58,3 +242,44 @@ func ReadMessages(conn *ws.Conn, messageCallback func(Message, error)) {
 		}
 	}
 }
+
+// getMessageError returns the error carried by the given message, if it is an
+// error message.
+func getMessageError(message Message) error {
+	if message.GetValueForKey(TypeKey) != ErrorType {
+		return nil
+	}
+
+	reason := message.GetValueForKey(ReasonKey)
+	return fmt.Errorf("error message received: %s", reason)
+}
+
+// ReadUpdates reads incoming messages from the connection, like ReadMessages,
+// and normalizes them according to the feed format, with matches labeled by
+// the venue. For each message received, it calls the updateCallback function,
+// with an empty update for messages carrying no matches or heartbeats. Parsing
+// errors are only logged, and the data that could be parsed is still passed
+// on.
+func (c *Conn) ReadUpdates(updateCallback func(Update, error)) {
+	c.ReadMessages(func(message Message, err error) {
+		if err != nil {
+			updateCallback(Update{}, err)
+			return
+		}
+
+		update, err := c.format.parseUpdate(message)
+		if err != nil {
+			log.Printf("Error parsing feed message: %v", err)
+		}
+
+		for i := range update.Matches {
+			update.Matches[i].Venue = c.config.Venue
+		}
+		updateCallback(update, nil)
+	})
+}
+
+// Venue returns the name of the venue the connection reads from.
+func (c *Conn) Venue() string {
+	return c.config.Venue
+}
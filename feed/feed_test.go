@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	ws "github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -69,7 +70,10 @@ func Test_CreateSubscription(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		conn, err := CreateSubscription(tc.endpoint, tc.productIDs)
+		conn, err := CreateSubscription(Config{
+			Endpoint:   tc.endpoint,
+			ProductIDs: tc.productIDs,
+		})
 
 		assert.Equal(t, tc.expectedError, err,
 			"For test %q, got unexpected error value", tc.desc)
@@ -78,13 +82,75 @@ func Test_CreateSubscription(t *testing.T) {
 		}
 
 		var message Message
-		err = conn.ReadJSON(&message)
+		err = conn.conn.ReadJSON(&message)
 		assert.Nil(t, err,
 			"For test %q, got unexpected error reading from WebSocket connection",
 			tc.desc)
 
 		assert.Equal(t, SubscribeType, message[TypeKey],
 			"For test %q, got unexpected message type from echo server", tc.desc)
+		conn.Close()
+	}
+}
+
+func Test_SubscribeAndUnsubscribe(t *testing.T) {
+	// Spin up test server.
+	echoServer := httptest.NewServer(http.HandlerFunc(testEchoServerHandler))
+	defer echoServer.Close()
+	echoEndpoint := strings.Replace(echoServer.URL, "http", "ws", 1)
+
+	c, err := CreateSubscription(Config{Endpoint: echoEndpoint})
+	if err != nil {
+		t.Fatalf("Error creating test subscription: %v", err)
+		return
+	}
+	defer c.Close()
+
+	// Discard the echoed initial subscription.
+	var message Message
+	if err := c.conn.ReadJSON(&message); err != nil {
+		t.Fatalf("Error reading initial subscription: %v", err)
+		return
+	}
+
+	testCases := []struct {
+		desc         string
+		send         func(*Conn, []string) error
+		productIDs   []string
+		expectedType string
+	}{
+		{
+			desc:         "subscribe",
+			send:         (*Conn).Subscribe,
+			productIDs:   []string{"product1", "product2"},
+			expectedType: SubscribeType,
+		},
+		{
+			desc:         "unsubscribe",
+			send:         (*Conn).Unsubscribe,
+			productIDs:   []string{"product1"},
+			expectedType: UnsubscribeType,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.send(c, tc.productIDs)
+		assert.Nil(t, err,
+			"For test %q, got unexpected error sending message", tc.desc)
+
+		var message Message
+		err = c.conn.ReadJSON(&message)
+		assert.Nil(t, err,
+			"For test %q, got unexpected error reading from WebSocket connection",
+			tc.desc)
+
+		assert.Equal(t, tc.expectedType, message[TypeKey],
+			"For test %q, got unexpected message type from echo server", tc.desc)
+		assert.Equal(t, []interface{}{"matches", "heartbeat"},
+			message[ChannelsKey],
+			"For test %q, got unexpected channels from echo server", tc.desc)
+		assert.Len(t, message[ProductIDsKey], len(tc.productIDs),
+			"For test %q, got unexpected product IDs from echo server", tc.desc)
 	}
 }
 
@@ -140,7 +206,8 @@ func Test_ReadMessages(t *testing.T) {
 
 	// output will be built using the individual messages received from the server.
 	var output string
-	ReadMessages(c, func(msg Message, err error) {
+	conn := &Conn{conn: c}
+	conn.ReadMessages(func(msg Message, err error) {
 		if err != nil {
 			return
 		}
@@ -183,7 +250,96 @@ func Test_ReadMessagesError(t *testing.T) {
 	}
 
 	// output will be built using the individual messages received from the server.
-	ReadMessages(c, func(msg Message, err error) {
+	conn := &Conn{conn: c}
+	conn.ReadMessages(func(msg Message, err error) {
 		assert.NotNil(t, err)
 	})
 }
+
+func Test_CreateSubscriptionInvalidKeepalive(t *testing.T) {
+	_, err := CreateSubscription(Config{
+		Endpoint:     "ws://localhost",
+		PingInterval: time.Second,
+		ReadTimeout:  time.Second,
+	})
+
+	assert.Equal(t,
+		errors.New("ping interval must be shorter than read timeout"), err,
+		"Got unexpected error value")
+}
+
+func Test_Keepalive(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		answerPings bool
+		expectError bool
+	}{
+		{
+			desc:        "pongs extend the read deadline",
+			answerPings: true,
+			expectError: false,
+		},
+		{
+			desc:        "silent connection times out",
+			answerPings: false,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		doneCh := make(chan struct{})
+
+		// The test server never sends messages. Pings are only answered with
+		// pongs while reading from the connection.
+		testServerHandler := func(w http.ResponseWriter, r *http.Request) {
+			wsUpgrader := ws.Upgrader{}
+			c, err := wsUpgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			defer c.Close()
+			if tc.answerPings {
+				for {
+					if _, _, err := c.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}
+			<-doneCh
+		}
+
+		testServer := httptest.NewServer(http.HandlerFunc(testServerHandler))
+		endpoint := strings.Replace(testServer.URL, "http", "ws", 1)
+
+		conn, err := CreateSubscription(Config{
+			Endpoint:     endpoint,
+			PingInterval: 20 * time.Millisecond,
+			ReadTimeout:  100 * time.Millisecond,
+			WriteTimeout: 100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("For test %q, error creating subscription: %v", tc.desc,
+				err)
+			return
+		}
+
+		errCh := make(chan error, 1)
+		go conn.ReadMessages(func(msg Message, err error) {
+			errCh <- err
+		})
+
+		select {
+		case err := <-errCh:
+			assert.True(t, tc.expectError,
+				"For test %q, got unexpected error: %v", tc.desc, err)
+		case <-time.After(500 * time.Millisecond):
+			assert.False(t, tc.expectError,
+				"For test %q, expected read to time out", tc.desc)
+		}
+
+		conn.Close()
+		close(doneCh)
+		testServer.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package feed
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Feed formats.
+const (
+	AdvancedTradeFormat string = "advanced-trade"
+	BinanceFormat       string = "binance"
+	BitstampFormat      string = "bitstamp"
+	ExchangeFormat      string = "exchange"
+	KrakenFormat        string = "kraken"
+)
+
+// Update holds the normalized data carried by a single feed message.
+type Update struct {
+	Matches    []Match
+	Heartbeats []Heartbeat
+}
+
+// format translates between the wire format of a feed and the normalized
+// messages and data used by the rest of the package.
+type format interface {
+	// defaultEndpoint returns the public WebSocket endpoint of the feed.
+	defaultEndpoint() string
+
+	// newSubscribeMessages returns the messages to send to subscribe to the
+	// given products, signed with the given credentials unless they are nil.
+	newSubscribeMessages(productIDs []string,
+		credentials *Credentials) ([]Message, error)
+
+	// newUnsubscribeMessages returns the messages to send to unsubscribe from
+	// the given products.
+	newUnsubscribeMessages(productIDs []string) []Message
+
+	// hasHeartbeats indicates if the feed sends heartbeats.
+	hasHeartbeats() bool
+
+	// parseConfirmation checks if the given message confirms or rejects the
+	// subscription to some of the given products. If so, it returns their
+	// status. An error is returned if the message rejects the subscription
+	// as a whole.
+	parseConfirmation(message Message, productIDs []string) (
+		subscriptionStatus, bool, error)
+
+	// parseUpdate parses the matches and heartbeats in the given message. In
+	// case of error, the data that could be parsed is still returned.
+	parseUpdate(message Message) (Update, error)
+}
+
+// formats maps each supported format name to a function creating its
+// implementation. Formats may keep state for a single connection, such as the
+// venue symbols of the subscribed products.
+var formats = map[string]func() format{
+	AdvancedTradeFormat: func() format { return advancedTradeFormat{} },
+	BinanceFormat:       func() format { return newBinanceFormat() },
+	BitstampFormat:      func() format { return newBitstampFormat() },
+	ExchangeFormat:      func() format { return exchangeFormat{} },
+	KrakenFormat:        func() format { return krakenFormat{} },
+}
+
+// Formats returns the names of the supported feed formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getFormat returns a new instance of the format with the given name, which
+// defaults to the exchange format if empty.
+func getFormat(name string) (format, error) {
+	if name == "" {
+		name = ExchangeFormat
+	}
+
+	newFormat, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown feed format %q", name)
+	}
+	return newFormat(), nil
+}
+
+// DefaultEndpoint returns the public WebSocket endpoint for the feed format
+// with the given name.
+func DefaultEndpoint(formatName string) (string, error) {
+	f, err := getFormat(formatName)
+	if err != nil {
+		return "", err
+	}
+	return f.defaultEndpoint(), nil
+}
+
+// HasHeartbeats indicates if the feed format with the given name sends
+// heartbeats.
+func HasHeartbeats(formatName string) (bool, error) {
+	f, err := getFormat(formatName)
+	if err != nil {
+		return false, err
+	}
+	return f.hasHeartbeats(), nil
+}
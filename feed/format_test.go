@@ -0,0 +1,46 @@
+// +build unit
+
+package feed
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DefaultEndpoint(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		format           string
+		expectedEndpoint string
+		expectedError    error
+	}{
+		{
+			desc:             "default format",
+			format:           "",
+			expectedEndpoint: "wss://ws-feed.exchange.coinbase.com",
+			expectedError:    nil,
+		},
+		{
+			desc:             "advanced trade format",
+			format:           AdvancedTradeFormat,
+			expectedEndpoint: "wss://advanced-trade-ws.coinbase.com",
+			expectedError:    nil,
+		},
+		{
+			desc:             "unknown format",
+			format:           "other",
+			expectedEndpoint: "",
+			expectedError:    errors.New("unknown feed format \"other\""),
+		},
+	}
+
+	for _, tc := range testCases {
+		endpoint, err := DefaultEndpoint(tc.format)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+		assert.Equal(t, tc.expectedEndpoint, endpoint,
+			"For test %q, got unexpected endpoint", tc.desc)
+	}
+}
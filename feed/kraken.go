@@ -0,0 +1,203 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keys used in Kraken, Binance and Bitstamp messages.
+const (
+	DataKey     string = "data"
+	ErrorKey    string = "error"
+	EventKey    string = "event"
+	IDKey       string = "id"
+	MethodKey   string = "method"
+	MsgKey      string = "msg"
+	ParamsKey   string = "params"
+	QtyKey      string = "qty"
+	ResultKey   string = "result"
+	SnapshotKey string = "snapshot"
+	SuccessKey  string = "success"
+	SymbolKey   string = "symbol"
+)
+
+// Kraken channels and methods.
+const (
+	krakenHeartbeatChannel  string = "heartbeat"
+	krakenTradeChannel      string = "trade"
+	krakenSubscribeMethod   string = "subscribe"
+	krakenUnsubscribeMethod string = "unsubscribe"
+)
+
+// krakenFormat is the format of the Kraken WebSocket API v2. Symbols are
+// BASE/QUOTE pairs, and heartbeats are sent for the whole connection.
+type krakenFormat struct{}
+
+func (krakenFormat) defaultEndpoint() string {
+	return "wss://ws.kraken.com/v2"
+}
+
+func (krakenFormat) hasHeartbeats() bool {
+	return true
+}
+
+func (krakenFormat) newSubscribeMessages(
+	productIDs []string, credentials *Credentials,
+) ([]Message, error) {
+	if credentials != nil {
+		return nil, errUnsupportedCredentials(KrakenFormat)
+	}
+
+	symbols, err := getSymbols(productIDs, toKrakenSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Message{newKrakenMessage(krakenSubscribeMethod, symbols)}, nil
+}
+
+func (krakenFormat) newUnsubscribeMessages(productIDs []string) []Message {
+	// Invalid product IDs could never be subscribed to, so they are skipped.
+	var symbols []string
+	for _, id := range productIDs {
+		if base, quote, err := splitProductID(id); err == nil {
+			symbols = append(symbols, toKrakenSymbol(base, quote))
+		}
+	}
+
+	return []Message{newKrakenMessage(krakenUnsubscribeMethod, symbols)}
+}
+
+// parseConfirmation parses the acknowledgement the exchange sends for each
+// symbol in a subscribe request.
+func (krakenFormat) parseConfirmation(
+	message Message, productIDs []string,
+) (subscriptionStatus, bool, error) {
+	if message.GetValueForKey(MethodKey) != krakenSubscribeMethod {
+		return subscriptionStatus{}, false, nil
+	}
+
+	if success, _ := message[SuccessKey].(bool); success {
+		rawResult, _ := message[ResultKey].(map[string]interface{})
+		result := Message(rawResult)
+		symbol := result.GetValueForKey(SymbolKey)
+		return subscriptionStatus{
+			confirmed: []string{fromKrakenSymbol(symbol)},
+		}, true, nil
+	}
+
+	symbol := message.GetValueForKey(SymbolKey)
+	if symbol == "" {
+		return subscriptionStatus{}, false, fmt.Errorf("subscription "+
+			"rejected: %s", message.GetValueForKey(ErrorKey))
+	}
+
+	return subscriptionStatus{
+		rejected: []string{fromKrakenSymbol(symbol)},
+	}, true, nil
+}
+
+// parseUpdate parses trade and heartbeat messages. Trades in snapshot messages
+// happened before the subscription, and are parsed as last matches, sorted
+// from oldest to newest. Invalid trades are skipped, and reported in the
+// returned error.
+func (krakenFormat) parseUpdate(message Message) (Update, error) {
+	switch message.GetValueForKey(ChannelKey) {
+	case krakenHeartbeatChannel:
+		return Update{Heartbeats: []Heartbeat{{ReceivedAt: now()}}}, nil
+	case krakenTradeChannel:
+	default:
+		return Update{}, nil
+	}
+
+	isSnapshot := message.GetValueForKey(TypeKey) == SnapshotEventType
+
+	var update Update
+	var errs []string
+	for _, trade := range getObjects(message, DataKey) {
+		match, err := parseKrakenTrade(trade)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		match.IsLast = isSnapshot
+		update.Matches = append(update.Matches, match)
+	}
+
+	if isSnapshot {
+		sort.SliceStable(update.Matches, func(i, j int) bool {
+			return update.Matches[i].Time.Before(update.Matches[j].Time)
+		})
+	}
+
+	if len(errs) > 0 {
+		return update, fmt.Errorf("error parsing match data: %s",
+			strings.Join(errs, "; "))
+	}
+	return update, nil
+}
+
+// parseKrakenTrade parses a single Kraken trade into a Match. Kraken reports
+// the side of the taker order, so the maker side is its opposite.
+func parseKrakenTrade(rawTrade map[string]interface{}) (Match, error) {
+	trade := Message(rawTrade)
+
+	price, err := trade.GetFloatForKey(PriceKey)
+	if err != nil {
+		return Match{}, err
+	}
+
+	size, err := trade.GetFloatForKey(QtyKey)
+	if err != nil {
+		return Match{}, err
+	}
+
+	tradeTime, err := parseTimeForKey(trade, TimestampKey)
+	if err != nil {
+		return Match{}, err
+	}
+
+	symbol := trade.GetValueForKey(SymbolKey)
+	if symbol == "" {
+		return Match{}, errors.New("missing symbol")
+	}
+
+	return Match{
+		Price:      price,
+		ProductID:  fromKrakenSymbol(symbol),
+		ReceivedAt: now(),
+		Side:       oppositeSide(trade.GetValueForKey(SideKey)),
+		Size:       size,
+		Time:       tradeTime,
+		TradeID:    trade.GetIDForKey(TradeIDKey),
+	}, nil
+}
+
+// newKrakenMessage returns a request with the given method for the trade
+// channel of the given symbols. Subscriptions include a snapshot of recent
+// trades.
+func newKrakenMessage(method string, symbols []string) Message {
+	params := map[string]interface{}{
+		ChannelKey: krakenTradeChannel,
+		SymbolKey:  symbols,
+	}
+	if method == krakenSubscribeMethod {
+		params[SnapshotKey] = true
+	}
+
+	return Message{
+		MethodKey: method,
+		ParamsKey: params,
+	}
+}
+
+func toKrakenSymbol(base, quote string) string {
+	return base + "/" + quote
+}
+
+func fromKrakenSymbol(symbol string) string {
+	return strings.Replace(symbol, "/", "-", 1)
+}
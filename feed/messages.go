@@ -2,31 +2,53 @@ package feed
 
 import (
 	"fmt"
+	"log"
 	"strconv"
+	"time"
 )
 
 // Keys used in messages.
 const (
-	ChannelsKey   string = "channels"
-	MessageKey    string = "message"
-	PriceKey      string = "price"
-	ProductIDKey  string = "product_id"
-	ProductIDsKey string = "product_ids"
-	ReasonKey     string = "reason"
-	SideKey       string = "side"
-	SizeKey       string = "size"
-	TypeKey       string = "type"
+	ChannelsKey     string = "channels"
+	LastTradeIDKey  string = "last_trade_id"
+	MakerOrderIDKey string = "maker_order_id"
+	MessageKey      string = "message"
+	NameKey         string = "name"
+	PriceKey        string = "price"
+	ProductIDKey    string = "product_id"
+	ProductIDsKey   string = "product_ids"
+	ReasonKey       string = "reason"
+	SequenceKey     string = "sequence"
+	SideKey         string = "side"
+	SizeKey         string = "size"
+	TakerOrderIDKey string = "taker_order_id"
+	TimeKey         string = "time"
+	TradeIDKey      string = "trade_id"
+	TypeKey         string = "type"
 )
 
 // Message types.
 const (
-	ErrorType     string = "error"
-	MatchType     string = "match"
-	LastMatchType string = "last_match"
-	SubscribeType string = "subscribe"
-	UnknownType   string = "unknown"
+	ErrorType         string = "error"
+	HeartbeatType     string = "heartbeat"
+	MatchType         string = "match"
+	LastMatchType     string = "last_match"
+	SubscribeType     string = "subscribe"
+	SubscriptionsType string = "subscriptions"
+	UnknownType       string = "unknown"
+	UnsubscribeType   string = "unsubscribe"
 )
 
+// Order sides.
+const (
+	BuySide  string = "buy"
+	SellSide string = "sell"
+)
+
+// now returns the current local time, recorded as the time each match and
+// heartbeat is received.
+var now = time.Now
+
 //
 // Messages.
 //
@@ -51,26 +73,109 @@ func (m *Message) GetValueForKey(key string) string {
 	return value
 }
 
-func newSubscribeMessage(channels, productIDs []string) Message {
-	return Message{
+// GetIDForKey returns the value for the given key as a string. Identifiers such
+// as trade IDs and sequence numbers may be sent either as strings or as JSON
+// numbers, so both are accepted. An empty string is returned if the key is
+// absent or holds any other type.
+func (m *Message) GetIDForKey(key string) string {
+	if m == nil {
+		return ""
+	}
+
+	switch value := (*m)[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// GetFloatForKey returns the value for the given key as a float64. Prices and
+// sizes may be sent either as strings or as JSON numbers, so both are
+// accepted.
+func (m *Message) GetFloatForKey(key string) (float64, error) {
+	if m != nil {
+		if value, ok := (*m)[key].(float64); ok {
+			return value, nil
+		}
+	}
+
+	value, err := strconv.ParseFloat(m.GetValueForKey(key), 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %q field: %v", key, err)
+	}
+	return value, nil
+}
+
+// newSubscribeMessage returns a subscribe message for the given channels and
+// products, signed with the given credentials unless they are nil.
+func newSubscribeMessage(
+	channels, productIDs []string, credentials *Credentials,
+) Message {
+	message := Message{
 		TypeKey:       SubscribeType,
 		ChannelsKey:   channels,
 		ProductIDsKey: productIDs,
 	}
+
+	if credentials != nil {
+		credentials.sign(message)
+	}
+	return message
+}
+
+func newUnsubscribeMessage(channels, productIDs []string) Message {
+	return Message{
+		TypeKey:       UnsubscribeType,
+		ChannelsKey:   channels,
+		ProductIDsKey: productIDs,
+	}
 }
 
-// Match represents the relevant data contained in a match for VWAP
-// calculation.
+// Match represents the data contained in a match message, along with the local
+// time at which it was received.
 type Match struct {
-	IsLast    bool // Indicates if this is data from a last_match message.
-	Price     float64
-	ProductID string
-	Size      float64
+	IsLast            bool // Indicates if this is data from a last_match message.
+	MakerOrderID      string
+	OriginalProductID string // Product ID reported by the venue, before mapping.
+	Price             float64
+	ProductID         string
+	ReceivedAt        time.Time // Local time at which the match was parsed.
+	Sequence          int64
+	Side              string // Side of the maker order, either "buy" or "sell".
+	Size              float64
+	TakerOrderID      string
+	Time              time.Time // Exchange timestamp of the match.
+	TradeID           string
+	Venue             string // Venue the match was received from.
+}
+
+// TakerSide returns the side of the taker order for the match, which is the
+// opposite of the maker side reported by the exchange. An empty string is
+// returned if the maker side is unknown.
+func (m Match) TakerSide() string {
+	return oppositeSide(m.Side)
+}
+
+// oppositeSide returns the side opposite to the given one, or an empty string
+// if the given side is unknown.
+func oppositeSide(side string) string {
+	switch side {
+	case BuySide:
+		return SellSide
+	case SellSide:
+		return BuySide
+	default:
+		return ""
+	}
 }
 
 // ParseMatch tries and parses a Match from the given message passed as
 // argument. It returns the parsed match, a bool indicating if the given
 // message contains a match at all, and any error found in the parsing process.
+// Only the price and size must be valid, malformed metadata is logged.
 func ParseMatch(msg Message) (Match, bool, error) {
 	msgType := msg.GetValueForKey(TypeKey)
 	if msgType != MatchType && msgType != LastMatchType {
@@ -91,10 +196,104 @@ func ParseMatch(msg Message) (Match, bool, error) {
 			SizeKey, err)
 	}
 
+	// The fields below are metadata, not needed for the VWAP itself. A
+	// malformed one is left at its zero value, rather than dropping the match.
+	sequence, err := parseSequence(msg)
+	if err != nil {
+		log.Printf("Error parsing match metadata: %v", err)
+	}
+
+	matchTime, err := parseTime(msg)
+	if err != nil {
+		log.Printf("Error parsing match metadata: %v", err)
+	}
+
 	return Match{
-		IsLast:    msgType == LastMatchType,
-		Price:     price,
-		ProductID: msg.GetValueForKey(ProductIDKey),
-		Size:      size,
+		IsLast:       msgType == LastMatchType,
+		MakerOrderID: msg.GetValueForKey(MakerOrderIDKey),
+		Price:        price,
+		ProductID:    msg.GetValueForKey(ProductIDKey),
+		ReceivedAt:   now(),
+		Sequence:     sequence,
+		Side:         msg.GetValueForKey(SideKey),
+		Size:         size,
+		TakerOrderID: msg.GetValueForKey(TakerOrderIDKey),
+		Time:         matchTime,
+		TradeID:      msg.GetIDForKey(TradeIDKey),
 	}, true, nil
 }
+
+// Heartbeat represents the data contained in a heartbeat message, which the
+// exchange sends periodically for each product, along with the local time at
+// which it was received.
+type Heartbeat struct {
+	LastTradeID string
+	ProductID   string
+	ReceivedAt  time.Time // Local time at which the heartbeat was parsed.
+	Sequence    int64
+	Time        time.Time // Exchange timestamp of the heartbeat.
+}
+
+// ParseHeartbeat tries and parses a Heartbeat from the given message passed as
+// argument. It returns the parsed heartbeat, a bool indicating if the given
+// message is a heartbeat at all, and any error found in the parsing process.
+func ParseHeartbeat(msg Message) (Heartbeat, bool, error) {
+	if msg.GetValueForKey(TypeKey) != HeartbeatType {
+		return Heartbeat{}, false, nil
+	}
+
+	// A malformed field is left at its zero value, as for matches.
+	sequence, err := parseSequence(msg)
+	if err != nil {
+		log.Printf("Error parsing heartbeat metadata: %v", err)
+	}
+
+	heartbeatTime, err := parseTime(msg)
+	if err != nil {
+		log.Printf("Error parsing heartbeat metadata: %v", err)
+	}
+
+	return Heartbeat{
+		LastTradeID: msg.GetIDForKey(LastTradeIDKey),
+		ProductID:   msg.GetValueForKey(ProductIDKey),
+		ReceivedAt:  now(),
+		Sequence:    sequence,
+		Time:        heartbeatTime,
+	}, true, nil
+}
+
+// parseSequence parses the optional sequence number of a message, which is 0
+// when absent.
+func parseSequence(msg Message) (int64, error) {
+	sequenceStr := msg.GetIDForKey(SequenceKey)
+	if sequenceStr == "" {
+		return 0, nil
+	}
+
+	sequence, err := strconv.ParseInt(sequenceStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %q field: %v", SequenceKey, err)
+	}
+	return sequence, nil
+}
+
+// parseTime parses the optional exchange timestamp of a message, which is the
+// zero time when absent.
+func parseTime(msg Message) (time.Time, error) {
+	return parseTimeForKey(msg, TimeKey)
+}
+
+// parseTimeForKey parses the optional RFC 3339 timestamp under the given key of
+// a message, which is the zero time when absent.
+func parseTimeForKey(msg Message, key string) (time.Time, error) {
+	timeStr := msg.GetValueForKey(key)
+	if timeStr == "" {
+		return time.Time{}, nil
+	}
+
+	msgTime, err := time.Parse(time.RFC3339Nano, timeStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing %q field: %v", key, err)
+	}
+	return msgTime, nil
+}
@@ -5,6 +5,7 @@ package feed
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
@@ -62,7 +63,65 @@ func Test_GetValueForKey(t *testing.T) {
 	}
 }
 
+func Test_GetIDForKey(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		message        *Message
+		key            string
+		expectedOutput string
+	}{
+		{
+			desc:           "nil message",
+			message:        nil,
+			key:            "",
+			expectedOutput: "",
+		},
+		{
+			desc: "absent key",
+			message: &Message{
+				"someKey": "someValue",
+			},
+			key:            "myKey",
+			expectedOutput: "",
+		},
+		{
+			desc: "key present, string value",
+			message: &Message{
+				"someKey": "123",
+			},
+			key:            "someKey",
+			expectedOutput: "123",
+		},
+		{
+			desc: "key present, number value",
+			message: &Message{
+				"someKey": 34906214137.0,
+			},
+			key:            "someKey",
+			expectedOutput: "34906214137",
+		},
+		{
+			desc: "key present, non-string and non-number value",
+			message: &Message{
+				"someKey": true,
+			},
+			key:            "someKey",
+			expectedOutput: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		output := tc.message.GetIDForKey(tc.key)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
 func Test_ParseMatch(t *testing.T) {
+	receivedAt := time.Date(2022, 5, 1, 18, 9, 25, 0, time.UTC)
+	now = func() time.Time { return receivedAt }
+	defer func() { now = time.Now }()
+
 	testCases := []struct {
 		desc             string
 		message          Message
@@ -116,10 +175,73 @@ func Test_ParseMatch(t *testing.T) {
 				ProductIDKey: "myProduct",
 			},
 			expectedMatch: Match{
-				IsLast:    false,
-				Price:     1.23,
-				Size:      4.56,
-				ProductID: "myProduct",
+				IsLast:     false,
+				Price:      1.23,
+				Size:       4.56,
+				ProductID:  "myProduct",
+				ReceivedAt: receivedAt,
+			},
+			expectedHasMatch: true,
+			expectedError:    nil,
+		},
+		{
+			desc: "Match message invalid sequence value",
+			message: Message{
+				TypeKey:     MatchType,
+				PriceKey:    "1.23",
+				SizeKey:     "4.56",
+				SequenceKey: "hello world",
+			},
+			expectedMatch: Match{
+				Price:      1.23,
+				Size:       4.56,
+				ReceivedAt: receivedAt,
+			},
+			expectedHasMatch: true,
+			expectedError:    nil,
+		},
+		{
+			desc: "Match message invalid time value",
+			message: Message{
+				TypeKey:  MatchType,
+				PriceKey: "1.23",
+				SizeKey:  "4.56",
+				TimeKey:  "hello world",
+			},
+			expectedMatch: Match{
+				Price:      1.23,
+				Size:       4.56,
+				ReceivedAt: receivedAt,
+			},
+			expectedHasMatch: true,
+			expectedError:    nil,
+		},
+		{
+			desc: "Last match message with full metadata",
+			message: Message{
+				TypeKey:         LastMatchType,
+				MakerOrderIDKey: "ac928c66-ca53-498f-9c13-a110027a60e8",
+				PriceKey:        "400.23",
+				ProductIDKey:    "BTC-USD",
+				SequenceKey:     50.0,
+				SideKey:         SellSide,
+				SizeKey:         "5.23512",
+				TakerOrderIDKey: "132fb6ae-456b-4654-b4e0-d681ac05cea1",
+				TimeKey:         "2014-11-07T08:19:27.028459Z",
+				TradeIDKey:      10.0,
+			},
+			expectedMatch: Match{
+				IsLast:       true,
+				MakerOrderID: "ac928c66-ca53-498f-9c13-a110027a60e8",
+				Price:        400.23,
+				ProductID:    "BTC-USD",
+				ReceivedAt:   receivedAt,
+				Sequence:     50,
+				Side:         SellSide,
+				Size:         5.23512,
+				TakerOrderID: "132fb6ae-456b-4654-b4e0-d681ac05cea1",
+				Time:         time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC),
+				TradeID:      "10",
 			},
 			expectedHasMatch: true,
 			expectedError:    nil,
@@ -136,3 +258,94 @@ func Test_ParseMatch(t *testing.T) {
 			"For test %q, got unexpected error value", tc.desc)
 	}
 }
+
+func Test_TakerSide(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		match          Match
+		expectedOutput string
+	}{
+		{
+			desc:           "unknown maker side",
+			match:          Match{Side: "someSide"},
+			expectedOutput: "",
+		},
+		{
+			desc:           "maker buy",
+			match:          Match{Side: BuySide},
+			expectedOutput: SellSide,
+		},
+		{
+			desc:           "maker sell",
+			match:          Match{Side: SellSide},
+			expectedOutput: BuySide,
+		},
+	}
+
+	for _, tc := range testCases {
+		output := tc.match.TakerSide()
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got wrong output", tc.desc)
+	}
+}
+
+func Test_ParseHeartbeat(t *testing.T) {
+	receivedAt := time.Date(2022, 5, 1, 18, 9, 25, 0, time.UTC)
+	now = func() time.Time { return receivedAt }
+	defer func() { now = time.Now }()
+
+	testCases := []struct {
+		desc                 string
+		message              Message
+		expectedHeartbeat    Heartbeat
+		expectedHasHeartbeat bool
+		expectedError        error
+	}{
+		{
+			desc:                 "message with other type",
+			message:              Message{TypeKey: MatchType},
+			expectedHeartbeat:    Heartbeat{},
+			expectedHasHeartbeat: false,
+			expectedError:        nil,
+		},
+		{
+			desc: "heartbeat with invalid time",
+			message: Message{
+				TypeKey: HeartbeatType,
+				TimeKey: "hello world",
+			},
+			expectedHeartbeat:    Heartbeat{ReceivedAt: receivedAt},
+			expectedHasHeartbeat: true,
+			expectedError:        nil,
+		},
+		{
+			desc: "valid heartbeat",
+			message: Message{
+				TypeKey:        HeartbeatType,
+				LastTradeIDKey: 20.0,
+				ProductIDKey:   "BTC-USD",
+				SequenceKey:    90.0,
+				TimeKey:        "2014-11-07T08:19:28.464459Z",
+			},
+			expectedHeartbeat: Heartbeat{
+				LastTradeID: "20",
+				ProductID:   "BTC-USD",
+				ReceivedAt:  receivedAt,
+				Sequence:    90,
+				Time:        time.Date(2014, 11, 7, 8, 19, 28, 464459000, time.UTC),
+			},
+			expectedHasHeartbeat: true,
+			expectedError:        nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		heartbeat, hasHeartbeat, err := ParseHeartbeat(tc.message)
+		assert.True(t, cmp.Equal(heartbeat, tc.expectedHeartbeat),
+			"For test %q, got wrong Heartbeat", tc.desc)
+		assert.Equal(t, tc.expectedHasHeartbeat, hasHeartbeat,
+			"For test %q, got wrong hasHeartbeat", tc.desc)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+	}
+}
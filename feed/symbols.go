@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// splitProductID splits a canonical BASE-QUOTE product ID into its base and
+// quote currencies.
+func splitProductID(productID string) (string, string, error) {
+	parts := strings.Split(productID, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid product ID %q, expected "+
+			"BASE-QUOTE", productID)
+	}
+	return parts[0], parts[1], nil
+}
+
+// getSymbols returns the venue symbols for the given product IDs, built by
+// toSymbol from their base and quote currencies.
+func getSymbols(
+	productIDs []string, toSymbol func(base, quote string) string,
+) ([]string, error) {
+	symbols := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		base, quote, err := splitProductID(id)
+		if err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, toSymbol(base, quote))
+	}
+	return symbols, nil
+}
+
+// symbolMap maps venue symbols back to the product IDs they were subscribed
+// for, for venues whose symbols cannot be split into base and quote
+// currencies. It is safe for concurrent use, as products may be subscribed to
+// while messages are parsed.
+type symbolMap struct {
+	mu         sync.Mutex
+	productIDs map[string]string
+}
+
+func newSymbolMap() *symbolMap {
+	return &symbolMap{productIDs: make(map[string]string)}
+}
+
+// add maps the given symbols to the product IDs at the same positions.
+func (m *symbolMap) add(symbols, productIDs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, symbol := range symbols {
+		m.productIDs[symbol] = productIDs[i]
+	}
+}
+
+// getProductID returns the product ID for the given symbol, or the symbol
+// itself if it was never subscribed to.
+func (m *symbolMap) getProductID(symbol string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if productID, ok := m.productIDs[symbol]; ok {
+		return productID
+	}
+	return symbol
+}
+
+// errUnsupportedCredentials returns the error for signed subscriptions in a
+// format that does not support them.
+func errUnsupportedCredentials(formatName string) error {
+	return fmt.Errorf("signed subscriptions are not supported by the %q "+
+		"feed format", formatName)
+}
@@ -0,0 +1,345 @@
+// +build unit
+
+package feed
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loadFixture loads the messages recorded, one per line, in the given file of
+// the testdata directory.
+func loadFixture(t *testing.T, name string) []Message {
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Error opening fixture %q: %v", name, err)
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			t.Fatalf("Error parsing fixture %q: %v", name, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func Test_splitProductID(t *testing.T) {
+	testCases := []struct {
+		productID     string
+		expectedBase  string
+		expectedQuote string
+		expectedError error
+	}{
+		{"BTC-USD", "BTC", "USD", nil},
+		{"BTCUSD", "", "", errors.New("invalid product ID \"BTCUSD\", expected BASE-QUOTE")},
+		{"BTC-", "", "", errors.New("invalid product ID \"BTC-\", expected BASE-QUOTE")},
+		{"A-B-C", "", "", errors.New("invalid product ID \"A-B-C\", expected BASE-QUOTE")},
+	}
+
+	for _, tc := range testCases {
+		base, quote, err := splitProductID(tc.productID)
+		assert.Equal(t, tc.expectedError, err,
+			"For product %q, got unexpected error value", tc.productID)
+		assert.Equal(t, tc.expectedBase, base,
+			"For product %q, got unexpected base", tc.productID)
+		assert.Equal(t, tc.expectedQuote, quote,
+			"For product %q, got unexpected quote", tc.productID)
+	}
+}
+
+func Test_venueFormatsSubscribeMessages(t *testing.T) {
+	testCases := []struct {
+		format                 string
+		expectedSubscribe      []Message
+		expectedUnsubscribeLen int
+	}{
+		{
+			format: KrakenFormat,
+			expectedSubscribe: []Message{
+				{
+					MethodKey: "subscribe",
+					ParamsKey: map[string]interface{}{
+						ChannelKey:  "trade",
+						SymbolKey:   []string{"BTC/USD", "ETH/BTC"},
+						SnapshotKey: true,
+					},
+				},
+			},
+			expectedUnsubscribeLen: 1,
+		},
+		{
+			format: BinanceFormat,
+			expectedSubscribe: []Message{
+				{
+					MethodKey: "SUBSCRIBE",
+					ParamsKey: []string{"btcusd@trade", "ethbtc@trade"},
+					IDKey:     1.0,
+				},
+			},
+			expectedUnsubscribeLen: 1,
+		},
+		{
+			format: BitstampFormat,
+			expectedSubscribe: []Message{
+				{
+					EventKey: "bts:subscribe",
+					DataKey: map[string]interface{}{
+						ChannelKey: "live_trades_btcusd",
+					},
+				},
+				{
+					EventKey: "bts:subscribe",
+					DataKey: map[string]interface{}{
+						ChannelKey: "live_trades_ethbtc",
+					},
+				},
+			},
+			expectedUnsubscribeLen: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		f, err := getFormat(tc.format)
+		if err != nil {
+			t.Fatalf("Error getting format %q: %v", tc.format, err)
+		}
+
+		messages, err := f.newSubscribeMessages(
+			[]string{"BTC-USD", "ETH-BTC"}, nil)
+		assert.Nil(t, err, "For format %q, got unexpected error", tc.format)
+		assert.Equal(t, tc.expectedSubscribe, messages,
+			"For format %q, got unexpected subscribe messages", tc.format)
+
+		assert.Len(t, f.newUnsubscribeMessages(
+			[]string{"BTC-USD", "ETH-BTC"}), tc.expectedUnsubscribeLen,
+			"For format %q, got unexpected unsubscribe messages", tc.format)
+
+		_, err = f.newSubscribeMessages([]string{"BTCUSD"}, nil)
+		assert.Equal(t, errors.New("invalid product ID \"BTCUSD\", expected "+
+			"BASE-QUOTE"), err,
+			"For format %q, got unexpected error for invalid product",
+			tc.format)
+
+		_, err = f.newSubscribeMessages([]string{"BTC-USD"}, &Credentials{})
+		assert.Equal(t, errUnsupportedCredentials(tc.format), err,
+			"For format %q, got unexpected error for signed subscription",
+			tc.format)
+	}
+}
+
+func Test_venueFormatsRejectSubscription(t *testing.T) {
+	testCases := []struct {
+		format        string
+		reply         Message
+		expectedError error
+	}{
+		{
+			format: KrakenFormat,
+			reply: Message{
+				MethodKey:  "subscribe",
+				SuccessKey: false,
+				ErrorKey:   "Malformed request",
+			},
+			expectedError: errors.New("subscription rejected: Malformed request"),
+		},
+		{
+			format: BinanceFormat,
+			reply: Message{
+				IDKey: 1.0,
+				ErrorKey: map[string]interface{}{
+					"code": 2.0,
+					MsgKey: "Invalid request: unknown stream",
+				},
+			},
+			expectedError: errors.New("subscription rejected: Invalid request: unknown stream"),
+		},
+		{
+			format: BitstampFormat,
+			reply: Message{
+				EventKey:   "bts:error",
+				ChannelKey: "",
+				DataKey: map[string]interface{}{
+					MessageKey: "Bad subscription string.",
+				},
+			},
+			expectedError: errors.New("subscription rejected: Bad subscription string."),
+		},
+	}
+
+	for _, tc := range testCases {
+		f, err := getFormat(tc.format)
+		if err != nil {
+			t.Fatalf("Error getting format %q: %v", tc.format, err)
+		}
+
+		_, _, err = f.parseConfirmation(tc.reply, []string{"BTC-USD"})
+		assert.Equal(t, tc.expectedError, err,
+			"For format %q, got unexpected error value", tc.format)
+	}
+}
+
+func Test_CreateSubscriptionVenues(t *testing.T) {
+	receivedAt := time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return receivedAt }
+
+	testCases := []struct {
+		format             string
+		fixture            string
+		productIDs         []string
+		expectedRejected   []string
+		expectedMatches    []Match
+		expectedHeartbeats int
+	}{
+		{
+			format:           KrakenFormat,
+			fixture:          "kraken.jsonl",
+			productIDs:       []string{"BTC-USD", "DOGE-XYZ"},
+			expectedRejected: []string{"DOGE-XYZ"},
+			expectedMatches: []Match{
+				{
+					IsLast:     true,
+					Price:      63049.9,
+					ProductID:  "BTC-USD",
+					ReceivedAt: receivedAt,
+					Side:       SellSide,
+					Size:       0.05,
+					Time:       time.Date(2024, 5, 1, 11, 59, 57, 1000, time.UTC),
+					TradeID:    "71338001",
+				},
+				{
+					IsLast:     true,
+					Price:      63050.1,
+					ProductID:  "BTC-USD",
+					ReceivedAt: receivedAt,
+					Side:       BuySide,
+					Size:       0.00118,
+					Time:       time.Date(2024, 5, 1, 11, 59, 58, 123456000, time.UTC),
+					TradeID:    "71338002",
+				},
+				{
+					Price:      63051,
+					ProductID:  "BTC-USD",
+					ReceivedAt: receivedAt,
+					Side:       SellSide,
+					Size:       0.2,
+					Time:       time.Date(2024, 5, 1, 12, 0, 1, 5e8, time.UTC),
+					TradeID:    "71338003",
+				},
+			},
+			expectedHeartbeats: 1,
+		},
+		{
+			format:           BinanceFormat,
+			fixture:          "binance.jsonl",
+			productIDs:       []string{"BTC-USDT", "ETH-BTC"},
+			expectedRejected: nil,
+			expectedMatches: []Match{
+				{
+					Price:      63050.1,
+					ProductID:  "BTC-USDT",
+					ReceivedAt: receivedAt,
+					Side:       BuySide,
+					Size:       0.00118,
+					Time:       time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+					TradeID:    "3571928001",
+				},
+				{
+					Price:      0.0475,
+					ProductID:  "ETH-BTC",
+					ReceivedAt: receivedAt,
+					Side:       SellSide,
+					Size:       1.2,
+					Time:       time.Date(2024, 5, 1, 12, 0, 1, 5e8, time.UTC),
+					TradeID:    "443210",
+				},
+			},
+		},
+		{
+			format:           BitstampFormat,
+			fixture:          "bitstamp.jsonl",
+			productIDs:       []string{"BTC-USD", "ETH-USD"},
+			expectedRejected: nil,
+			expectedMatches: []Match{
+				{
+					Price:      63050,
+					ProductID:  "BTC-USD",
+					ReceivedAt: receivedAt,
+					Side:       SellSide,
+					Size:       0.0115,
+					Time:       time.Date(2024, 5, 1, 12, 0, 1, 123456000, time.UTC),
+					TradeID:    "336562385",
+				},
+				{
+					Price:      3010.5,
+					ProductID:  "ETH-USD",
+					ReceivedAt: receivedAt,
+					Side:       BuySide,
+					Size:       1.5,
+					Time:       time.Date(2024, 5, 1, 12, 0, 2, 250000, time.UTC),
+					TradeID:    "336562390",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		testServer := newReplyServer(loadFixture(t, tc.fixture))
+		endpoint := strings.Replace(testServer.URL, "http", "ws", 1)
+
+		config := Config{
+			Endpoint:            endpoint,
+			Format:              tc.format,
+			ProductIDs:          tc.productIDs,
+			ConfirmationTimeout: time.Second,
+			ReadTimeout:         time.Second,
+		}
+
+		if tc.expectedRejected != nil {
+			_, err := CreateSubscription(config)
+			assert.Equal(t,
+				&RejectedProductsError{ProductIDs: tc.expectedRejected}, err,
+				"For format %q, got unexpected error value", tc.format)
+			config.AllowRejectedProducts = true
+		}
+
+		conn, err := CreateSubscription(config)
+		if err != nil {
+			t.Fatalf("For format %q, error creating subscription: %v",
+				tc.format, err)
+		}
+
+		var matches []Match
+		var heartbeats int
+		conn.ReadUpdates(func(update Update, err error) {
+			matches = append(matches, update.Matches...)
+			heartbeats += len(update.Heartbeats)
+			if err != nil || len(matches) >= len(tc.expectedMatches) {
+				conn.Close()
+			}
+		})
+
+		// Matches are labeled with the venue, named after the format.
+		for i := range tc.expectedMatches {
+			tc.expectedMatches[i].Venue = tc.format
+		}
+		assert.Equal(t, tc.expectedMatches, matches,
+			"For format %q, got unexpected matches", tc.format)
+		assert.Equal(t, tc.expectedHeartbeats, heartbeats,
+			"For format %q, got unexpected number of heartbeats", tc.format)
+		testServer.Close()
+	}
+}
@@ -2,32 +2,161 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ha2398/vwap/calc"
+	"github.com/ha2398/vwap/feed"
+	"github.com/ha2398/vwap/index"
+	"github.com/ha2398/vwap/products"
 )
 
 // Defaults.
 var defaultTradingPairs strSlice = strSlice{"BTC-USD", "ETH-USD", "ETH-BTC"}
 
 const (
-	defaultFeedEndpoint string = "wss://ws-feed.exchange.coinbase.com"
-	defaultWindowSize   int    = 200
+	defaultAdminAddress        string        = ""
+	defaultAllowRejectedPairs  bool          = false
+	defaultFeedEndpoint        string        = ""
+	defaultFeedFormat          string        = feed.ExchangeFormat
+	defaultHandshakeTimeout    time.Duration = 45 * time.Second
+	defaultHeartbeatTimeout    time.Duration = 10 * time.Second
+	defaultIndexInterval       time.Duration = time.Second
+	defaultLastMatchPolicy     string        = string(calc.LastMatchInclude)
+	defaultPingInterval        time.Duration = 10 * time.Second
+	defaultProductsEndpoint    string        = "https://api.exchange.coinbase.com"
+	defaultReconnect           bool          = false
+	defaultReadTimeout         time.Duration = 30 * time.Second
+	defaultReconnectOnStale    bool          = false
+	defaultSubscriptionTimeout time.Duration = 10 * time.Second
+	defaultTradeTimeout        time.Duration = 0
+	defaultWindowSize          int           = 200
+	defaultWindowType          string        = string(calc.WindowSliding)
+	defaultWriteTimeout        time.Duration = 10 * time.Second
 )
 
 // Parameters.
 var (
-	feedEndpoint string
-	tradingPairs strSlice
-	windowSize   int
+	adminAddress        string
+	allowRejectedPairs  bool
+	bars                barSpecSlice
+	caFile              string
+	certFile            string
+	credentialsFile     string
+	crossRates          stringMap
+	excludedVenues      strSlice
+	feedEndpoint        string
+	feedFormat          string
+	feedHeaders         headerSlice
+	halfLife            time.Duration
+	halfLifeTrades      float64
+	handshakeTimeout    time.Duration
+	heartbeatTimeout    time.Duration
+	indexFile           string
+	indexInterval       time.Duration
+	keyFile             string
+	lastMatchPolicy     string
+	pingInterval        time.Duration
+	priceStats          bool
+	productAliases      stringMap
+	productsCache       string
+	productsEndpoint    string
+	proxyURL            string
+	quoteEquivalents    stringMap
+	readTimeout         time.Duration
+	reconnect           bool
+	reconnectOnStale    bool
+	reportingCurrency   string
+	serverName          string
+	derivedSeries       seriesSlice
+	subscriptionTimeout time.Duration
+	symbolAliases       stringMap
+	tradeTimeout        time.Duration
+	tradingPairs        strSlice
+	twapPairs           strSlice
+	venueFormats        strSlice
+	venueWeightCaps     weightCapMap
+	volumeProfiles      bucketWidthMap
+	vwapBands           bool
+	vwapHorizons        durationSlice
+	windowSize          int
+	windowType          string
+	writeTimeout        time.Duration
 )
 
 // Flag names.
 const (
-	feedEndpointFlag string = "feed-endpoint"
-	tradingPairsFlag string = "trading-pairs"
-	windowSizeFlag   string = "window-size"
+	adminAddressFlag        string = "admin-address"
+	allowRejectedPairsFlag  string = "allow-rejected-pairs"
+	barsFlag                string = "bars"
+	caFileFlag              string = "ca-file"
+	certFileFlag            string = "cert-file"
+	credentialsFileFlag     string = "credentials-file"
+	crossRatesFlag          string = "cross-rates"
+	excludedVenuesFlag      string = "excluded-venues"
+	feedEndpointFlag        string = "feed-endpoint"
+	feedFormatFlag          string = "feed-format"
+	feedHeaderFlag          string = "feed-header"
+	halfLifeFlag            string = "half-life"
+	halfLifeTradesFlag      string = "half-life-trades"
+	handshakeTimeoutFlag    string = "handshake-timeout"
+	heartbeatTimeoutFlag    string = "heartbeat-timeout"
+	indexFileFlag           string = "index-file"
+	indexIntervalFlag       string = "index-interval"
+	keyFileFlag             string = "key-file"
+	lastMatchPolicyFlag     string = "last-match-policy"
+	pingIntervalFlag        string = "ping-interval"
+	priceStatsFlag          string = "price-stats"
+	productAliasesFlag      string = "product-aliases"
+	productsCacheFlag       string = "products-cache"
+	productsEndpointFlag    string = "products-endpoint"
+	proxyURLFlag            string = "proxy-url"
+	quoteEquivalentsFlag    string = "quote-equivalents"
+	readTimeoutFlag         string = "read-timeout"
+	reconnectFlag           string = "reconnect"
+	reconnectOnStaleFlag    string = "reconnect-on-stale"
+	reportingCurrencyFlag   string = "reporting-currency"
+	serverNameFlag          string = "server-name"
+	seriesFlag              string = "series"
+	subscriptionTimeoutFlag string = "subscription-timeout"
+	symbolAliasesFlag       string = "symbol-aliases"
+	tradeTimeoutFlag        string = "trade-timeout"
+	tradingPairsFlag        string = "trading-pairs"
+	twapPairsFlag           string = "twap-pairs"
+	venuesFlag              string = "venues"
+	venueWeightCapsFlag     string = "venue-weight-caps"
+	volumeProfilesFlag      string = "volume-profiles"
+	vwapBandsFlag           string = "vwap-bands"
+	vwapHorizonsFlag        string = "vwap-horizons"
+	windowSizeFlag          string = "window-size"
+	windowTypeFlag          string = "window-type"
+	writeTimeoutFlag        string = "write-timeout"
 )
 
+// Environment variable holding the token required by the admin API, if any.
+const adminTokenEnv string = "VWAP_ADMIN_TOKEN"
+
+// Token required by the admin API, if any. It is read from the environment
+// rather than from a flag, so that it is not shown in the process list.
+var adminToken string
+
+// Credentials to sign feed subscriptions with, if any.
+var feedCredentials *feed.Credentials
+
+// Indexes to compute from the VWAPs, if any.
+var indexDefinitions []index.Definition
+
+// Product catalog used to validate the trading pairs, if enabled.
+var productCatalog *products.Catalog
+
 type strSlice []string
 
 func (ss *strSlice) String() string {
@@ -43,30 +172,605 @@ func (ss *strSlice) String() string {
 	return output
 }
 
+// Set parses a comma separated list, skipping empty elements, so that an empty
+// value, as passed by the Makefile for unset variables, gives an empty list.
 func (ss *strSlice) Set(value string) error {
 	*ss = nil
 
 	for _, i := range strings.Split(value, ",") {
-		*ss = append(*ss, strings.TrimSpace(i))
+		if element := strings.TrimSpace(i); element != "" {
+			*ss = append(*ss, element)
+		}
 	}
 	return nil
 }
 
+// contains indicates if the list holds the given element.
+func (ss strSlice) contains(element string) bool {
+	for _, s := range ss {
+		if s == element {
+			return true
+		}
+	}
+	return false
+}
+
+// headerSlice is a repeatable flag holding HTTP headers, each given as
+// "Name: value".
+type headerSlice http.Header
+
+func (hs *headerSlice) String() string {
+	var output []string
+	for name, values := range *hs {
+		for _, value := range values {
+			output = append(output, name+": "+value)
+		}
+	}
+	return strings.Join(output, ",")
+}
+
+func (hs *headerSlice) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"",
+			value)
+	}
+
+	if *hs == nil {
+		*hs = headerSlice{}
+	}
+	http.Header(*hs).Add(strings.TrimSpace(parts[0]),
+		strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// weightCapMap is a flag holding the weight cap of each venue, given as a comma
+// separated list of "venue=cap".
+type weightCapMap map[string]float64
+
+func (wm *weightCapMap) String() string {
+	var output []string
+	for venue, weightCap := range *wm {
+		output = append(output, fmt.Sprintf("%s=%v", venue, weightCap))
+	}
+	sort.Strings(output)
+	return strings.Join(output, ",")
+}
+
+func (wm *weightCapMap) Set(value string) error {
+	*wm = weightCapMap{}
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid weight cap %q, expected \"venue=cap\"",
+				entry)
+		}
+
+		weightCap, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return fmt.Errorf("error parsing weight cap %q: %v", entry, err)
+		}
+		(*wm)[strings.TrimSpace(parts[0])] = weightCap
+	}
+	return nil
+}
+
+// bucketWidthMap is a flag holding the bucket width of the volume profile of
+// each trading pair, given as a comma separated list of "pair=width".
+type bucketWidthMap map[string]float64
+
+func (bm *bucketWidthMap) String() string {
+	var output []string
+	for pair, width := range *bm {
+		output = append(output, fmt.Sprintf("%s=%v", pair, width))
+	}
+	sort.Strings(output)
+	return strings.Join(output, ",")
+}
+
+func (bm *bucketWidthMap) Set(value string) error {
+	*bm = bucketWidthMap{}
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid volume profile %q, expected "+
+				"\"pair=width\"", entry)
+		}
+
+		width, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return fmt.Errorf("error parsing bucket width %q: %v", entry, err)
+		}
+		(*bm)[strings.TrimSpace(parts[0])] = width
+	}
+	return nil
+}
+
+// stringMap is a flag holding a mapping between strings, given as a comma
+// separated list of "from=to".
+type stringMap map[string]string
+
+func (sm *stringMap) String() string {
+	var output []string
+	for from, to := range *sm {
+		output = append(output, from+"="+to)
+	}
+	sort.Strings(output)
+	return strings.Join(output, ",")
+}
+
+func (sm *stringMap) Set(value string) error {
+	*sm = stringMap{}
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" ||
+			strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("invalid mapping %q, expected \"from=to\"",
+				entry)
+		}
+		(*sm)[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return nil
+}
+
+// durationSlice is a flag holding a comma separated list of durations.
+type durationSlice []time.Duration
+
+func (ds *durationSlice) String() string {
+	var output []string
+	for _, duration := range *ds {
+		output = append(output, duration.String())
+	}
+	return strings.Join(output, ",")
+}
+
+func (ds *durationSlice) Set(value string) error {
+	*ds = nil
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("error parsing duration %q: %v", entry, err)
+		}
+		*ds = append(*ds, duration)
+	}
+	return nil
+}
+
+// barSpecSlice is a flag holding the OHLCV bars to build, given as a comma
+// separated list of durations, "volume=threshold" or "dollar=threshold".
+type barSpecSlice []calc.BarSpec
+
+func (bs *barSpecSlice) String() string {
+	var output []string
+	for _, spec := range *bs {
+		output = append(output, spec.String())
+	}
+	return strings.Join(output, ",")
+}
+
+func (bs *barSpecSlice) Set(value string) error {
+	*bs = nil
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		spec, err := calc.ParseBarSpec(strings.TrimSpace(entry))
+		if err != nil {
+			return err
+		}
+		*bs = append(*bs, spec)
+	}
+	return nil
+}
+
+// seriesSlice is a repeatable flag holding derived series, each given as
+// "name=expression".
+type seriesSlice []calc.Series
+
+func (ss *seriesSlice) String() string {
+	var output []string
+	for _, s := range *ss {
+		output = append(output, s.Name+"="+s.Expression)
+	}
+	return strings.Join(output, ",")
+}
+
+func (ss *seriesSlice) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("invalid series %q, expected \"name=expression\"",
+			value)
+	}
+
+	*ss = append(*ss, calc.Series{
+		Name:       strings.TrimSpace(parts[0]),
+		Expression: strings.TrimSpace(parts[1]),
+	})
+	return nil
+}
+
+// getCrossRates returns the cross rates to imply, sorted by pair.
+func getCrossRates() []calc.CrossRate {
+	var rates []calc.CrossRate
+	for pair, via := range crossRates {
+		rates = append(rates, calc.CrossRate{Pair: pair, Via: via})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Pair < rates[j].Pair
+	})
+	return rates
+}
+
+// getSymbolMapping returns the mapping to apply to the product IDs of matches.
+func getSymbolMapping() calc.SymbolMapping {
+	return calc.SymbolMapping{
+		Products:         productAliases,
+		Assets:           symbolAliases,
+		QuoteEquivalents: quoteEquivalents,
+	}
+}
+
+// getVenueEndpoint returns the WebSocket endpoint to use for the venue with the
+// given feed format.
+func getVenueEndpoint(format string) (string, error) {
+	if feedEndpoint != "" {
+		return feedEndpoint, nil
+	}
+	return feed.DefaultEndpoint(format)
+}
+
+// getDefaultProductsCache returns the default path of the product catalog cache,
+// in the user's cache directory.
+func getDefaultProductsCache() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "vwap", "products.json")
+}
+
 func initFlags() {
 	flag.StringVar(&feedEndpoint, feedEndpointFlag, defaultFeedEndpoint,
-		"WebSocket endpoint to get match data from")
+		"WebSocket endpoint to get match data from. Defaults to the public "+
+			"endpoint for the feed format. Only allowed for a single venue")
+	flag.StringVar(&feedFormat, feedFormatFlag, defaultFeedFormat,
+		"Format of the feed, selecting the venue and its protocol. One of: "+
+			strings.Join(feed.Formats(), ", "))
+	flag.Var(&venueFormats, venuesFlag, "comma separated list of feed "+
+		"formats to read matches from, one venue each, for a consolidated "+
+		"VWAP. Defaults to the feed format")
+	flag.Var(&excludedVenues, excludedVenuesFlag, "comma separated list of "+
+		"venues left out of the consolidated VWAP, which are still reported")
+	flag.Var(&venueWeightCaps, venueWeightCapsFlag, "comma separated list "+
+		"of maximum weights of venues in the consolidated VWAP, as "+
+		"\"venue=cap\", with caps between 0 and 1")
 	flag.Var(&tradingPairs, tradingPairsFlag,
 		"comma separated list of trading pairs to calculate VWAP for")
+	flag.Var(&productAliases, productAliasesFlag, "comma separated list of "+
+		"products to report under another product ID, as \"from=to\", "+
+		"e.g. BTCUSD-PERP=BTC-PERP")
+	flag.Var(&symbolAliases, symbolAliasesFlag, "comma separated list of "+
+		"asset symbols to rename in the reported products, as \"from=to\", "+
+		"e.g. XBT=BTC")
+	flag.Var(&quoteEquivalents, quoteEquivalentsFlag, "comma separated list "+
+		"of quote currencies to merge into an equivalent one, as "+
+		"\"from=to\", e.g. USDC=USD,USDT=USD")
+	flag.Var(&crossRates, crossRatesFlag, "comma separated list of cross "+
+		"rates to imply from the VWAPs of two trading pairs, as "+
+		"\"pair=currency\", e.g. ETH-BTC=USD for ETH-USD / BTC-USD")
+	flag.Var(&derivedSeries, seriesFlag, "Derived series to log along with "+
+		"the trading pairs, as \"name=expression\", e.g. "+
+		"\"basis=(BTC-USDT.vwap - BTC-USD.vwap) / BTC-USD.vwap\". May be "+
+		"repeated")
+	flag.Var(&vwapHorizons, vwapHorizonsFlag, "comma separated list of time "+
+		"horizons to also calculate the VWAP of every trading pair over, "+
+		"e.g. 5m,1h, available to derived series as vwap5m and vwap1h")
+	flag.Var(&twapPairs, twapPairsFlag, "comma separated list of trading "+
+		"pairs to also log the TWAP of, over the same windows as the VWAP")
+	flag.BoolVar(&priceStats, priceStatsFlag, false, "Whether to log the "+
+		"highest and lowest prices in the windows of each trading pair, "+
+		"and their volume-weighted median, along with its VWAP. Requires "+
+		"sliding windows")
+	flag.Var(&volumeProfiles, volumeProfilesFlag, "comma separated list of "+
+		"trading pairs to keep a volume profile for, available in the "+
+		"engine snapshots, as \"pair=width\", with the width of the "+
+		"price buckets, e.g. BTC-USD=10. Requires sliding windows")
+	flag.Var(&bars, barsFlag, "comma separated list of OHLCV bars to build "+
+		"for every trading pair, each either a duration for time bars, "+
+		"e.g. 1m, \"volume=threshold\" or \"dollar=threshold\"")
+	flag.BoolVar(&vwapBands, vwapBandsFlag, false, "Whether to log the "+
+		"volume-weighted standard deviation of prices around each VWAP, "+
+		"along with the bands at 1 and 2 standard deviations from it")
+	flag.StringVar(&reportingCurrency, reportingCurrencyFlag, "", "Currency "+
+		"to also express the VWAP of every trading pair in, e.g. USD. The "+
+		"pairs needed for the conversion are subscribed to automatically. "+
+		"Disabled if empty")
+	flag.StringVar(&indexFile, indexFileFlag, "", "JSON file with the "+
+		"definitions of the indexes to compute from the VWAPs. Their "+
+		"constituents are subscribed to automatically. Disabled if empty")
+	flag.DurationVar(&indexInterval, indexIntervalFlag, defaultIndexInterval,
+		"Interval between index levels")
 	flag.IntVar(&windowSize, windowSizeFlag, defaultWindowSize,
 		"Size of the sliding window to use for VWAP calculation")
+	flag.StringVar(&windowType, windowTypeFlag, defaultWindowType,
+		"Type of the windows to use for VWAP calculation: sliding, or ewma "+
+			"for an exponentially weighted VWAP")
+	flag.DurationVar(&halfLife, halfLifeFlag, 0, "Half-life of the weight "+
+		"of matches in ewma windows, in time. Exclusive with --"+
+		halfLifeTradesFlag)
+	flag.Float64Var(&halfLifeTrades, halfLifeTradesFlag, 0, "Half-life of "+
+		"the weight of matches in ewma windows, in number of matches. "+
+		"Exclusive with --"+halfLifeFlag)
+	flag.StringVar(&lastMatchPolicy, lastMatchPolicyFlag,
+		defaultLastMatchPolicy, "How to handle last_match messages: "+
+			"include, exclude, or seed")
+	flag.StringVar(&adminAddress, adminAddressFlag, defaultAdminAddress,
+		"Address for the admin HTTP API to listen on, e.g. localhost:8080. "+
+			"The API is disabled if empty")
+	flag.DurationVar(&subscriptionTimeout, subscriptionTimeoutFlag,
+		defaultSubscriptionTimeout, "Maximum time to wait for the exchange "+
+			"to confirm the subscription. Not awaited if zero")
+	flag.BoolVar(&allowRejectedPairs, allowRejectedPairsFlag,
+		defaultAllowRejectedPairs, "Only log a warning, instead of exiting, "+
+			"if the exchange rejects some, but not all, of the trading pairs")
+	flag.StringVar(&productsEndpoint, productsEndpointFlag,
+		defaultProductsEndpoint, "REST endpoint to load product metadata "+
+			"from, used to validate trading pairs and round VWAPs. Only "+
+			"used if a venue uses the exchange format. Disabled if empty")
+	flag.StringVar(&productsCache, productsCacheFlag,
+		getDefaultProductsCache(), "File to cache product metadata in, "+
+			"used when the products endpoint cannot be reached")
+	flag.DurationVar(&heartbeatTimeout, heartbeatTimeoutFlag,
+		defaultHeartbeatTimeout, "Maximum time without heartbeats for a "+
+			"trading pair before it is considered stale. Disabled if zero")
+	flag.DurationVar(&tradeTimeout, tradeTimeoutFlag, defaultTradeTimeout,
+		"Maximum time without matches for a trading pair before it is "+
+			"considered silent. Disabled if zero")
+	flag.BoolVar(&reconnect, reconnectFlag, defaultReconnect,
+		"Reconnect to the feed when the connection fails, instead of exiting")
+	flag.BoolVar(&reconnectOnStale, reconnectOnStaleFlag,
+		defaultReconnectOnStale, "Force a reconnect to the feed when a "+
+			"trading pair goes stale. Implies --"+reconnectFlag)
+	flag.DurationVar(&pingInterval, pingIntervalFlag, defaultPingInterval,
+		"Interval between pings sent to keep the feed connection alive. "+
+			"Disabled if zero")
+	flag.DurationVar(&readTimeout, readTimeoutFlag, defaultReadTimeout,
+		"Maximum time without any message or pong from the feed before the "+
+			"connection is considered dead. Disabled if zero")
+	flag.DurationVar(&writeTimeout, writeTimeoutFlag, defaultWriteTimeout,
+		"Maximum time for writing a message to the feed. Disabled if zero")
+	flag.StringVar(&proxyURL, proxyURLFlag, "", "URL of the HTTP or SOCKS5 "+
+		"proxy to connect to the feed through, e.g. socks5://proxy:1080. "+
+		"Proxies set in the environment are used if empty")
+	flag.StringVar(&caFile, caFileFlag, "", "PEM file with the certificate "+
+		"authorities to verify the feed with, instead of the system ones")
+	flag.StringVar(&certFile, certFileFlag, "", "PEM file with the client "+
+		"certificate to present to the feed. Requires --"+keyFileFlag)
+	flag.StringVar(&keyFile, keyFileFlag, "", "PEM file with the key of the "+
+		"client certificate. Requires --"+certFileFlag)
+	flag.StringVar(&serverName, serverNameFlag, "", "Server name to verify "+
+		"the feed certificate against, if different from the endpoint host")
+	flag.DurationVar(&handshakeTimeout, handshakeTimeoutFlag,
+		defaultHandshakeTimeout, "Maximum time for the WebSocket opening "+
+			"handshake with the feed")
+	flag.Var(&feedHeaders, feedHeaderFlag, "Additional header to send when "+
+		"connecting to the feed, as \"Name: value\". May be repeated")
+	flag.StringVar(&credentialsFile, credentialsFileFlag, "", "JSON file "+
+		"with the API key, secret and passphrase to sign feed subscriptions "+
+		"with. It must not be accessible by group or others. If empty, "+
+		"credentials are read from the "+feed.APIKeyEnv+", "+
+		feed.APISecretEnv+" and "+feed.APIPassphraseEnv+" environment "+
+		"variables, if set")
 	flag.Parse()
 
 	if len(tradingPairs) == 0 {
 		tradingPairs = defaultTradingPairs
 	}
 
+	if len(venueFormats) == 0 {
+		venueFormats = strSlice{feedFormat}
+	}
+
+	if feedEndpoint != "" && len(venueFormats) > 1 {
+		log.Fatalf("--%s cannot be set for several venues", feedEndpointFlag)
+	}
+
+	// Every pair would go stale if heartbeats were expected from feeds that
+	// never send them. Heartbeats from any venue keep a pair active.
+	hasHeartbeats := false
+	isVenue := make(map[string]bool, len(venueFormats))
+	for _, format := range venueFormats {
+		if isVenue[format] {
+			log.Fatalf("Duplicate venue %q", format)
+		}
+		isVenue[format] = true
+
+		if _, err := getVenueEndpoint(format); err != nil {
+			log.Fatalf("Error getting feed endpoint: %v", err)
+		}
+
+		venueHasHeartbeats, err := feed.HasHeartbeats(format)
+		if err != nil {
+			log.Fatalf("Error checking feed heartbeats: %v", err)
+		}
+		hasHeartbeats = hasHeartbeats || venueHasHeartbeats
+	}
+	if !hasHeartbeats && heartbeatTimeout > 0 {
+		log.Printf("Warning: the feed formats %v send no heartbeats, "+
+			"disabling the heartbeat timeout", venueFormats)
+		heartbeatTimeout = 0
+	}
+
+	for _, venue := range excludedVenues {
+		if !isVenue[venue] {
+			log.Fatalf("Unknown excluded venue %q", venue)
+		}
+	}
+	if len(excludedVenues) >= len(venueFormats) {
+		log.Fatal("Every venue is excluded from the consolidated VWAP")
+	}
+	for venue := range venueWeightCaps {
+		if !isVenue[venue] {
+			log.Fatalf("Unknown venue %q in weight caps", venue)
+		}
+	}
+
+	// Subscribe to the constituents of the indexes.
+	if indexFile != "" {
+		var err error
+		indexDefinitions, err = index.LoadDefinitions(indexFile,
+			getSymbolMapping())
+		if err != nil {
+			log.Fatalf("Error loading index definitions: %v", err)
+		}
+
+		if indexInterval <= 0 {
+			log.Fatalf("--%s must be positive", indexIntervalFlag)
+		}
+
+		for _, pair := range index.Pairs(indexDefinitions) {
+			if !tradingPairs.contains(pair) {
+				tradingPairs = append(tradingPairs, pair)
+			}
+		}
+	}
+
+	// Load the product catalog, which lists the products of the exchange
+	// format, so it is only used if a venue uses that format.
+	if productsEndpoint != "" &&
+		!venueFormats.contains(feed.ExchangeFormat) {
+		log.Printf("Product catalog disabled, since no venue uses the %q "+
+			"format", feed.ExchangeFormat)
+	} else if productsEndpoint != "" {
+		var err error
+		productCatalog, err = products.Load(productsEndpoint, productsCache)
+		if err != nil {
+			log.Fatalf("Error loading product catalog: %v", err)
+		}
+	}
+
+	// Subscribe to the pairs needed to convert the VWAPs to the reporting
+	// currency, among the ones listed by the catalog, if loaded.
+	var validatePairs func(tradingPairs []string) error
+	if productCatalog != nil {
+		validatePairs = productCatalog.Validate
+	}
+	tradingPairs = calc.WithConversionPairs(tradingPairs, reportingCurrency,
+		getSymbolMapping(), validatePairs)
+
+	if productCatalog != nil {
+		if err := productCatalog.Validate(tradingPairs); err != nil {
+			log.Fatalf("Error validating trading pairs: %v", err)
+		}
+	}
+
 	// Print values for each parameter.
 	log.Printf("WebSocket feed endpoint: %q", feedEndpoint)
+	log.Printf("Venues: %v", venueFormats)
+	log.Printf("Excluded venues: %v", excludedVenues)
+	log.Printf("Venue weight caps: %s", venueWeightCaps.String())
 	log.Printf("Trading pairs: %v", tradingPairs)
+	log.Printf("Product aliases: %s", productAliases.String())
+	log.Printf("Symbol aliases: %s", symbolAliases.String())
+	log.Printf("Quote equivalents: %s", quoteEquivalents.String())
+	log.Printf("Cross rates: %s", crossRates.String())
+	log.Printf("Derived series: %d", len(derivedSeries))
+	log.Printf("VWAP horizons: %s", vwapHorizons.String())
+	log.Printf("TWAP pairs: %v", twapPairs)
+	log.Printf("VWAP bands: %t", vwapBands)
+	log.Printf("Price statistics: %t", priceStats)
+	log.Printf("Volume profiles: %s", volumeProfiles.String())
+	log.Printf("Bars: %s", bars.String())
+	log.Printf("Reporting currency: %q", reportingCurrency)
+	log.Printf("Index file: %q", indexFile)
+	log.Printf("Indexes: %d", len(indexDefinitions))
+	log.Printf("Index interval: %v", indexInterval)
 	log.Printf("Window size: %d", windowSize)
+	log.Printf("Window type: %q", windowType)
+	log.Printf("Half-life: %v", halfLife)
+	log.Printf("Half-life in matches: %v", halfLifeTrades)
+	log.Printf("last_match policy: %q", lastMatchPolicy)
+	log.Printf("Admin API address: %q", adminAddress)
+	log.Printf("Subscription timeout: %v", subscriptionTimeout)
+	log.Printf("Allow rejected pairs: %t", allowRejectedPairs)
+	log.Printf("Heartbeat timeout: %v", heartbeatTimeout)
+	log.Printf("Trade timeout: %v", tradeTimeout)
+	log.Printf("Reconnect: %t", reconnect)
+	log.Printf("Reconnect on stale: %t", reconnectOnStale)
+	log.Printf("Ping interval: %v", pingInterval)
+	log.Printf("Read timeout: %v", readTimeout)
+	log.Printf("Write timeout: %v", writeTimeout)
+	log.Printf("Proxy URL: %q", proxyURL)
+	log.Printf("CA file: %q", caFile)
+	log.Printf("Client certificate file: %q", certFile)
+	log.Printf("Client key file: %q", keyFile)
+	log.Printf("Server name: %q", serverName)
+	log.Printf("Handshake timeout: %v", handshakeTimeout)
+	log.Printf("Feed headers: %d", len(feedHeaders))
+	log.Printf("Credentials file: %q", credentialsFile)
+	log.Printf("Products endpoint: %q", productsEndpoint)
+	log.Printf("Products cache: %q", productsCache)
+
+	// The admin API has no access control other than its token.
+	adminToken = os.Getenv(adminTokenEnv)
+	if adminAddress != "" && adminToken == "" &&
+		!isLoopbackAddress(adminAddress) {
+		log.Printf("Warning: the admin API listens on %q with no token, "+
+			"set %s or listen on a loopback address", adminAddress,
+			adminTokenEnv)
+	}
+
+	// Load credentials, so that a misconfiguration is caught before
+	// connecting.
+	var err error
+	feedCredentials, err = feed.LoadCredentials(credentialsFile)
+	if err != nil {
+		log.Fatalf("Error loading feed credentials: %v", err)
+	}
+	if feedCredentials != nil {
+		log.Printf("Feed subscriptions are signed with API key %q",
+			feedCredentials.Key)
+	}
+}
+
+// isLoopbackAddress indicates if the given listen address, e.g.
+// "localhost:8080", only accepts connections from the local host.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
@@ -0,0 +1,350 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ha2398/vwap/calc"
+)
+
+// Contribution holds the part of an index level due to one of its
+// constituents.
+type Contribution struct {
+	Pair   string
+	Weight float64
+
+	// Price used for the constituent, which is its last usable VWAP if it is
+	// stale under the StaleLastPrice policy.
+	Price float64
+
+	// Weighted price of the constituent divided by the divisor of the index.
+	// Contributions add up to the level.
+	Contribution float64
+
+	// Indicates if the constituent has no usable VWAP.
+	Stale bool
+
+	// Indicates if the constituent is left out of the level, under the
+	// StaleExclude policy.
+	Excluded bool
+}
+
+// Level is the value of an index at the time of a snapshot.
+type Level struct {
+	Name    string
+	Time    time.Time
+	Value   float64
+	Divisor float64
+
+	// Indicates if the level could not be computed, either because of the
+	// stale policy or because a constituent never had a usable VWAP. Value is
+	// then the last computed level, if any.
+	Halted bool
+
+	Contributions []Contribution
+}
+
+// String returns the level in the format used for logging.
+func (l Level) String() string {
+	if l.Halted {
+		return fmt.Sprintf("Index %q: halted (last: %f)", l.Name, l.Value)
+	}
+
+	contributions := make([]string, len(l.Contributions))
+	for i, c := range l.Contributions {
+		switch {
+		case c.Excluded:
+			contributions[i] = fmt.Sprintf("%q: excluded", c.Pair)
+		case c.Stale:
+			contributions[i] = fmt.Sprintf("%q: %f x %f = %f (stale)", c.Pair,
+				c.Price, c.Weight, c.Contribution)
+		default:
+			contributions[i] = fmt.Sprintf("%q: %f x %f = %f", c.Pair,
+				c.Price, c.Weight, c.Contribution)
+		}
+	}
+
+	return fmt.Sprintf("Index %q: %f (divisor: %f, constituents: {%s})",
+		l.Name, l.Value, l.Divisor, strings.Join(contributions, ", "))
+}
+
+// indexState holds the current parameters of an index, which change with
+// rebalances and divisor adjustments.
+type indexState struct {
+	definition    Definition
+	constituents  []Constituent
+	divisor       float64
+	nextRebalance int
+
+	// Last usable VWAP of each trading pair of the index.
+	lastPrices map[string]float64
+
+	// Constituents left out of the index under the StaleExclude policy.
+	excluded map[string]bool
+
+	// Last computed level, and whether there is one.
+	level    float64
+	hasLevel bool
+}
+
+// Calculator computes the levels of a set of indexes from snapshots of the
+// calculation engine. It is not safe for concurrent use.
+type Calculator struct {
+	indexes []*indexState
+}
+
+// NewCalculator returns a calculator for the given index definitions. The
+// trading pairs of the constituents are mapped to the pairs they are reported
+// as in snapshots, through the given symbol mapping.
+func NewCalculator(
+	definitions []Definition, symbols calc.SymbolMapping,
+) (*Calculator, error) {
+	if len(definitions) == 0 {
+		return nil, errors.New("no index definitions")
+	}
+
+	names := make(map[string]bool, len(definitions))
+	c := &Calculator{}
+	for _, definition := range definitions {
+		if err := definition.validate(); err != nil {
+			return nil, err
+		}
+
+		definition, err := mapDefinition(definition, symbols)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[definition.Name] {
+			return nil, fmt.Errorf("duplicate index %q", definition.Name)
+		}
+		names[definition.Name] = true
+
+		c.indexes = append(c.indexes, &indexState{
+			definition:   definition,
+			constituents: definition.Constituents,
+			divisor:      definition.Divisor,
+			lastPrices:   make(map[string]float64),
+			excluded:     make(map[string]bool),
+		})
+	}
+	return c, nil
+}
+
+// mapDefinition returns a copy of the given definition, with the trading pairs
+// of its constituents mapped to their reported pairs. The reported pairs must
+// all be quoted in the same currency.
+func mapDefinition(
+	definition Definition, symbols calc.SymbolMapping,
+) (Definition, error) {
+	constituents, err := mapConstituents(definition.Constituents, symbols)
+	if err != nil {
+		return Definition{}, fmt.Errorf("invalid constituents for index %q: "+
+			"%v", definition.Name, err)
+	}
+	definition.Constituents = constituents
+
+	rebalances := make([]Rebalance, len(definition.Rebalances))
+	for i, rebalance := range definition.Rebalances {
+		rebalance.Constituents, err = mapConstituents(rebalance.Constituents,
+			symbols)
+		if err != nil {
+			return Definition{}, fmt.Errorf("invalid constituents for "+
+				"rebalance %d of index %q: %v", i, definition.Name, err)
+		}
+		rebalances[i] = rebalance
+	}
+	definition.Rebalances = rebalances
+
+	pairs := Pairs([]Definition{definition})
+	if err := validateQuoteCurrency(pairs); err != nil {
+		return Definition{}, fmt.Errorf("invalid constituents for index %q: "+
+			"%v", definition.Name, err)
+	}
+	return definition, nil
+}
+
+// mapConstituents returns the given constituents with their trading pairs
+// mapped to their reported pairs. Constituents reported as the same pair are
+// rejected, as they would be priced alike.
+func mapConstituents(
+	constituents []Constituent, symbols calc.SymbolMapping,
+) ([]Constituent, error) {
+	mapped := make([]Constituent, len(constituents))
+	pairs := make(map[string]string, len(constituents))
+	for i, constituent := range constituents {
+		reportedPair := symbols.ReportedPair(constituent.Pair)
+		if pair, ok := pairs[reportedPair]; ok {
+			return nil, fmt.Errorf("trading pairs %q and %q are both "+
+				"reported as %q", pair, constituent.Pair, reportedPair)
+		}
+		pairs[reportedPair] = constituent.Pair
+
+		mapped[i] = Constituent{Pair: reportedPair, Weight: constituent.Weight}
+	}
+	return mapped, nil
+}
+
+// Update computes the level of each index from the given snapshot, applying
+// the rebalances due by the time of the snapshot.
+func (c *Calculator) Update(snapshot calc.Snapshot) []Level {
+	levels := make([]Level, 0, len(c.indexes))
+	for _, index := range c.indexes {
+		levels = append(levels, index.update(snapshot))
+	}
+	return levels
+}
+
+// update computes the level of the index from the given snapshot.
+func (s *indexState) update(snapshot calc.Snapshot) Level {
+	available := s.updatePrices(snapshot)
+	s.applyRebalances(snapshot.Time)
+
+	level := Level{
+		Name:          s.definition.Name,
+		Time:          snapshot.Time,
+		Contributions: make([]Contribution, len(s.constituents)),
+	}
+
+	excluded := make(map[string]bool)
+	for i, constituent := range s.constituents {
+		price, hasPrice := s.lastPrices[constituent.Pair]
+		stale := !available[constituent.Pair]
+		level.Contributions[i] = Contribution{
+			Pair:   constituent.Pair,
+			Weight: constituent.Weight,
+			Price:  price,
+			Stale:  stale,
+		}
+
+		switch {
+		case stale && s.definition.StalePolicy == StaleExclude:
+			excluded[constituent.Pair] = true
+			level.Contributions[i].Excluded = true
+		case stale && s.definition.StalePolicy == StaleHalt, !hasPrice:
+			level.Halted = true
+		}
+	}
+
+	if len(excluded) == len(s.constituents) {
+		level.Halted = true
+	}
+
+	if level.Halted {
+		level.Value, level.Divisor = s.level, s.divisor
+		return level
+	}
+
+	// Keep the level continuous when constituents are left out or come back.
+	if !sameKeys(excluded, s.excluded) && s.hasLevel {
+		s.adjustDivisor(s.excluded, s.constituents, excluded)
+	}
+	s.excluded = excluded
+
+	for i, contribution := range level.Contributions {
+		if contribution.Excluded {
+			continue
+		}
+
+		level.Contributions[i].Contribution =
+			contribution.Weight * contribution.Price / s.divisor
+		level.Value += level.Contributions[i].Contribution
+	}
+
+	level.Divisor = s.divisor
+	s.level, s.hasLevel = level.Value, true
+	return level
+}
+
+// updatePrices records the usable VWAPs in the snapshot for the trading pairs
+// of the index. It returns the set of pairs which have one. A VWAP is usable if
+// it is positive and the pair is not stale.
+func (s *indexState) updatePrices(snapshot calc.Snapshot) map[string]bool {
+	available := make(map[string]bool)
+	for _, pair := range Pairs([]Definition{s.definition}) {
+		pairSnapshot, ok := snapshot.Pairs[pair]
+		if !ok || pairSnapshot.Stale || pairSnapshot.VWAP <= 0 {
+			continue
+		}
+
+		available[pair] = true
+		s.lastPrices[pair] = pairSnapshot.VWAP
+	}
+	return available
+}
+
+// applyRebalances replaces the constituents of the index with the ones of the
+// rebalances due by the given time. Unless the rebalance sets a divisor, the
+// divisor is adjusted so that the level is continuous, as long as the index
+// has a level already.
+func (s *indexState) applyRebalances(t time.Time) {
+	rebalances := s.definition.Rebalances
+	for ; s.nextRebalance < len(rebalances); s.nextRebalance++ {
+		rebalance := rebalances[s.nextRebalance]
+		if t.Before(rebalance.At) {
+			return
+		}
+
+		if rebalance.Divisor > 0 {
+			s.divisor = rebalance.Divisor
+		} else if s.hasLevel {
+			s.adjustDivisor(s.excluded, rebalance.Constituents, nil)
+		}
+
+		s.constituents = rebalance.Constituents
+		s.excluded = make(map[string]bool)
+	}
+}
+
+// adjustDivisor changes the divisor so that the value of the index is the
+// same, at the last usable prices, after its constituents change. The divisor
+// is kept if some of those prices are not known.
+func (s *indexState) adjustDivisor(
+	oldExcluded map[string]bool,
+	newConstituents []Constituent,
+	newExcluded map[string]bool,
+) {
+	oldSum, oldOK := s.getWeightedSum(s.constituents, oldExcluded)
+	newSum, newOK := s.getWeightedSum(newConstituents, newExcluded)
+	if !oldOK || !newOK || oldSum == 0 || newSum == 0 {
+		return
+	}
+	s.divisor *= newSum / oldSum
+}
+
+// getWeightedSum returns the sum of the weighted last usable prices of the
+// given constituents, leaving out the excluded ones. The returned bool is false
+// if some of the prices are not known.
+func (s *indexState) getWeightedSum(
+	constituents []Constituent, excluded map[string]bool,
+) (float64, bool) {
+	sum := 0.0
+	for _, constituent := range constituents {
+		if excluded[constituent.Pair] {
+			continue
+		}
+
+		price, ok := s.lastPrices[constituent.Pair]
+		if !ok {
+			return 0, false
+		}
+		sum += constituent.Weight * price
+	}
+	return sum, true
+}
+
+// sameKeys indicates if the given sets have the same elements.
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,329 @@
+// +build unit
+
+package index
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/calc"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSnapshot returns a snapshot at the given time, with the given VWAPs. Pairs
+// whose VWAP is negative are marked as stale, with the opposite VWAP.
+func newSnapshot(t time.Time, vwaps map[string]float64) calc.Snapshot {
+	snapshot := calc.Snapshot{Time: t, Pairs: map[string]calc.PairSnapshot{}}
+	for pair, vwap := range vwaps {
+		if vwap < 0 {
+			snapshot.Pairs[pair] = calc.PairSnapshot{VWAP: -vwap, Stale: true}
+			continue
+		}
+		snapshot.Pairs[pair] = calc.PairSnapshot{VWAP: vwap}
+	}
+	return snapshot
+}
+
+func Test_NewCalculator(t *testing.T) {
+	definition := Definition{
+		Name:         "I",
+		Divisor:      1,
+		Constituents: []Constituent{{Pair: "BTC-USD", Weight: 1}},
+	}
+
+	testCases := []struct {
+		desc          string
+		definitions   []Definition
+		symbols       calc.SymbolMapping
+		expectedError error
+	}{
+		{
+			desc:          "no definitions",
+			expectedError: errors.New("no index definitions"),
+		},
+		{
+			desc:          "duplicate index",
+			definitions:   []Definition{definition, definition},
+			expectedError: errors.New("duplicate index \"I\""),
+		},
+		{
+			desc: "constituents reported as the same pair",
+			definitions: []Definition{
+				{
+					Name:    "I",
+					Divisor: 1,
+					Constituents: []Constituent{
+						{Pair: "BTC-USD", Weight: 1},
+						{Pair: "BTC-USDT", Weight: 1},
+					},
+				},
+			},
+			symbols: calc.SymbolMapping{
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+			expectedError: errors.New("invalid constituents for index \"I\": " +
+				"trading pairs \"BTC-USD\" and \"BTC-USDT\" are both " +
+				"reported as \"BTC-USD\""),
+		},
+		{
+			desc: "mixed quote currencies",
+			definitions: []Definition{
+				{
+					Name:    "I",
+					Divisor: 1,
+					Constituents: []Constituent{
+						{Pair: "BTC-USD", Weight: 1},
+						{Pair: "ETH-EUR", Weight: 1},
+					},
+				},
+			},
+			expectedError: errors.New("invalid constituents for index \"I\": " +
+				"trading pairs \"BTC-USD\" and \"ETH-EUR\" are quoted in " +
+				"different currencies"),
+		},
+		{
+			desc:        "valid definitions",
+			definitions: []Definition{definition},
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := NewCalculator(tc.definitions, tc.symbols)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+	}
+}
+
+func Test_CalculatorUpdate(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	constituents := []Constituent{
+		{Pair: "BTC-USD", Weight: 1},
+		{Pair: "ETH-USD", Weight: 10},
+	}
+
+	testCases := []struct {
+		desc           string
+		policy         StalePolicy
+		rebalances     []Rebalance
+		snapshots      []map[string]float64
+		expectedLevels []float64
+		expectedHalted []bool
+	}{
+		{
+			desc:   "weighted sum over divisor",
+			policy: StaleLastPrice,
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000, "ETH-USD": 2000},
+				{"BTC-USD": 42000, "ETH-USD": 2200},
+			},
+			expectedLevels: []float64{600, 640},
+			expectedHalted: []bool{false, false},
+		},
+		{
+			desc:   "last price policy",
+			policy: StaleLastPrice,
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000},
+				{"BTC-USD": 40000, "ETH-USD": 2000},
+				{"BTC-USD": 42000, "ETH-USD": -1000},
+			},
+			expectedLevels: []float64{0, 600, 620},
+			expectedHalted: []bool{true, false, false},
+		},
+		{
+			desc:   "halt policy",
+			policy: StaleHalt,
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000, "ETH-USD": 2000},
+				{"BTC-USD": 42000, "ETH-USD": -2200},
+				{"BTC-USD": 42000, "ETH-USD": 2200},
+			},
+			expectedLevels: []float64{600, 600, 640},
+			expectedHalted: []bool{false, true, false},
+		},
+		{
+			desc:   "exclude policy keeps the level continuous",
+			policy: StaleExclude,
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000, "ETH-USD": 2000},
+				{"BTC-USD": 40000, "ETH-USD": -2200},
+				{"BTC-USD": 44000, "ETH-USD": -2200},
+				{"BTC-USD": 44000, "ETH-USD": 2000},
+				{"ETH-USD": -2000},
+			},
+			expectedLevels: []float64{600, 600, 660, 660, 660},
+			expectedHalted: []bool{false, false, false, false, true},
+		},
+		{
+			desc:   "rebalance keeps the level continuous",
+			policy: StaleLastPrice,
+			rebalances: []Rebalance{
+				{
+					At: start.Add(time.Minute),
+					Constituents: []Constituent{
+						{Pair: "BTC-USD", Weight: 2},
+						{Pair: "SOL-USD", Weight: 100},
+					},
+				},
+			},
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000, "ETH-USD": 2000, "SOL-USD": 100},
+				{"BTC-USD": 40000, "ETH-USD": 2000, "SOL-USD": 100},
+				{"BTC-USD": 34000, "ETH-USD": 2000, "SOL-USD": 100},
+			},
+			expectedLevels: []float64{600, 600, 520},
+			expectedHalted: []bool{false, false, false},
+		},
+		{
+			desc:   "rebalance with a new divisor",
+			policy: StaleLastPrice,
+			rebalances: []Rebalance{
+				{
+					At:           start.Add(time.Minute),
+					Constituents: []Constituent{{Pair: "SOL-USD", Weight: 1}},
+					Divisor:      2,
+				},
+			},
+			snapshots: []map[string]float64{
+				{"BTC-USD": 40000, "ETH-USD": 2000, "SOL-USD": 100},
+				{"BTC-USD": 40000, "ETH-USD": 2000, "SOL-USD": 100},
+			},
+			expectedLevels: []float64{600, 50},
+			expectedHalted: []bool{false, false},
+		},
+	}
+
+	for _, tc := range testCases {
+		calculator, err := NewCalculator([]Definition{
+			{
+				Name:         "I",
+				Divisor:      100,
+				Constituents: constituents,
+				Rebalances:   tc.rebalances,
+				StalePolicy:  tc.policy,
+			},
+		}, calc.SymbolMapping{})
+		assert.Nil(t, err, "For test %q, got error creating calculator",
+			tc.desc)
+
+		for i, vwaps := range tc.snapshots {
+			snapshot := newSnapshot(start.Add(time.Duration(i)*time.Minute),
+				vwaps)
+			levels := calculator.Update(snapshot)
+			if !assert.Len(t, levels, 1, "For test %q, got wrong levels",
+				tc.desc) {
+				break
+			}
+
+			assert.InDelta(t, tc.expectedLevels[i], levels[0].Value, 1e-9,
+				"For test %q, got wrong level %d", tc.desc, i)
+			assert.Equal(t, tc.expectedHalted[i], levels[0].Halted,
+				"For test %q, got wrong halted value for level %d", tc.desc,
+				i)
+		}
+	}
+}
+
+func Test_CalculatorUpdateSymbolMapping(t *testing.T) {
+	start := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	definitions := []Definition{
+		{
+			Name:    "I",
+			Divisor: 100,
+			Constituents: []Constituent{
+				{Pair: "XBT-USDT", Weight: 1},
+				{Pair: "ETH-USD", Weight: 10},
+			},
+			Rebalances: []Rebalance{
+				{
+					At: start.Add(time.Minute),
+					Constituents: []Constituent{
+						{Pair: "XBT-USDT", Weight: 2},
+					},
+				},
+			},
+		},
+	}
+
+	calculator, err := NewCalculator(definitions, calc.SymbolMapping{
+		Assets:           map[string]string{"XBT": "BTC"},
+		QuoteEquivalents: map[string]string{"USDT": "USD"},
+	})
+	assert.Nil(t, err, "Got error creating calculator")
+	assert.Equal(t, "XBT-USDT", definitions[0].Constituents[0].Pair,
+		"Definitions changed by the symbol mapping")
+
+	vwaps := map[string]float64{"BTC-USD": 40000, "ETH-USD": 2000}
+	for i, expectedLevel := range []float64{600, 600} {
+		snapshot := newSnapshot(start.Add(time.Duration(i)*time.Minute),
+			vwaps)
+		levels := calculator.Update(snapshot)
+		if !assert.Len(t, levels, 1, "Got wrong levels") {
+			return
+		}
+
+		assert.False(t, levels[0].Halted, "Got halted level %d", i)
+		assert.InDelta(t, expectedLevel, levels[0].Value, 1e-9,
+			"Got wrong level %d", i)
+		assert.Equal(t, "BTC-USD", levels[0].Contributions[0].Pair,
+			"Got wrong pair for level %d", i)
+	}
+}
+
+func Test_LevelString(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		level          Level
+		expectedOutput string
+	}{
+		{
+			desc: "halted",
+			level: Level{
+				Name:   "I",
+				Value:  600,
+				Halted: true,
+			},
+			expectedOutput: "Index \"I\": halted (last: 600.000000)",
+		},
+		{
+			desc: "contributions",
+			level: Level{
+				Name:    "I",
+				Value:   600,
+				Divisor: 100,
+				Contributions: []Contribution{
+					{
+						Pair:         "BTC-USD",
+						Weight:       1,
+						Price:        40000,
+						Contribution: 400,
+					},
+					{
+						Pair:         "ETH-USD",
+						Weight:       10,
+						Price:        2000,
+						Contribution: 200,
+						Stale:        true,
+					},
+					{
+						Pair:     "SOL-USD",
+						Weight:   100,
+						Price:    100,
+						Stale:    true,
+						Excluded: true,
+					},
+				},
+			},
+			expectedOutput: "Index \"I\": 600.000000 (divisor: 100.000000, " +
+				"constituents: {\"BTC-USD\": 40000.000000 x 1.000000 = " +
+				"400.000000, \"ETH-USD\": 2000.000000 x 10.000000 = " +
+				"200.000000 (stale), \"SOL-USD\": excluded})",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedOutput, tc.level.String(),
+			"For test %q, got wrong output", tc.desc)
+	}
+}
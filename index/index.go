@@ -0,0 +1,224 @@
+// Package index computes weighted basket indexes over the VWAPs of the trading
+// pairs, from snapshots of the calculation engine.
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ha2398/vwap/calc"
+)
+
+// StalePolicy defines how an index handles a constituent with no usable VWAP,
+// either because its trading pair is stale or because it has no data yet.
+type StalePolicy string
+
+// Supported stale policies.
+const (
+	// StaleLastPrice keeps using the last usable VWAP of the constituent.
+	// The level is halted if the constituent never had one.
+	StaleLastPrice StalePolicy = "last_price"
+
+	// StaleExclude leaves the constituent out of the index, adjusting the
+	// divisor so that the level is continuous. The divisor is adjusted again
+	// when the constituent comes back.
+	StaleExclude StalePolicy = "exclude"
+
+	// StaleHalt stops publishing new levels until every constituent has a
+	// usable VWAP again.
+	StaleHalt StalePolicy = "halt"
+)
+
+// isValid indicates if the policy is one of the supported ones.
+func (p StalePolicy) isValid() bool {
+	switch p {
+	case StaleLastPrice, StaleExclude, StaleHalt:
+		return true
+	default:
+		return false
+	}
+}
+
+// Constituent is a trading pair of an index, along with its weight, which is
+// the quantity of the base currency held in the basket.
+type Constituent struct {
+	Pair   string  `json:"pair"`
+	Weight float64 `json:"weight"`
+}
+
+// Rebalance replaces the constituents of an index at a scheduled time.
+type Rebalance struct {
+	At           time.Time     `json:"at"`
+	Constituents []Constituent `json:"constituents"`
+
+	// Divisor to use from the rebalance on. If zero, the divisor is adjusted
+	// so that the level is continuous across the rebalance.
+	Divisor float64 `json:"divisor"`
+}
+
+// Definition holds the parameters of an index, whose level is the weighted
+// sum of the VWAPs of its constituents, divided by its divisor.
+type Definition struct {
+	Name         string        `json:"name"`
+	Divisor      float64       `json:"divisor"`
+	Constituents []Constituent `json:"constituents"`
+
+	// Rebalances to apply, in chronological order.
+	Rebalances []Rebalance `json:"rebalances"`
+
+	// Policy for constituents with no usable VWAP. Defaults to
+	// StaleLastPrice if empty.
+	StalePolicy StalePolicy `json:"stale_policy"`
+}
+
+// LoadDefinitions reads a JSON list of index definitions from the given file,
+// and validates them, with the trading pairs of their constituents mapped to
+// their reported pairs through the given symbol mapping.
+func LoadDefinitions(
+	file string, symbols calc.SymbolMapping,
+) ([]Definition, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file %q: %v", file, err)
+	}
+
+	var definitions []Definition
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("error parsing index file %q: %v", file, err)
+	}
+
+	for i := range definitions {
+		if err := definitions[i].validate(); err != nil {
+			return nil, err
+		}
+
+		if _, err := mapDefinition(definitions[i], symbols); err != nil {
+			return nil, err
+		}
+	}
+	return definitions, nil
+}
+
+// Pairs returns the trading pairs of all constituents of the given indexes,
+// including the ones of their rebalances, in order and without duplicates.
+func Pairs(definitions []Definition) []string {
+	var pairs []string
+	seen := make(map[string]bool)
+	addPairs := func(constituents []Constituent) {
+		for _, constituent := range constituents {
+			if !seen[constituent.Pair] {
+				seen[constituent.Pair] = true
+				pairs = append(pairs, constituent.Pair)
+			}
+		}
+	}
+
+	for _, definition := range definitions {
+		addPairs(definition.Constituents)
+		for _, rebalance := range definition.Rebalances {
+			addPairs(rebalance.Constituents)
+		}
+	}
+	return pairs
+}
+
+// validate checks the parameters of the definition, setting the default stale
+// policy if none is set.
+func (d *Definition) validate() error {
+	if d.Name == "" {
+		return errors.New("empty index name")
+	}
+
+	if d.Divisor <= 0 {
+		return fmt.Errorf("invalid divisor %v for index %q, must be positive",
+			d.Divisor, d.Name)
+	}
+
+	if err := validateConstituents(d.Constituents); err != nil {
+		return fmt.Errorf("invalid constituents for index %q: %v", d.Name,
+			err)
+	}
+
+	for i, rebalance := range d.Rebalances {
+		if rebalance.At.IsZero() {
+			return fmt.Errorf("missing time for rebalance %d of index %q", i,
+				d.Name)
+		}
+
+		if i > 0 && !rebalance.At.After(d.Rebalances[i-1].At) {
+			return fmt.Errorf("rebalances of index %q are not in "+
+				"chronological order", d.Name)
+		}
+
+		if rebalance.Divisor < 0 {
+			return fmt.Errorf("invalid divisor %v for rebalance %d of index "+
+				"%q", rebalance.Divisor, i, d.Name)
+		}
+
+		if err := validateConstituents(rebalance.Constituents); err != nil {
+			return fmt.Errorf("invalid constituents for rebalance %d of "+
+				"index %q: %v", i, d.Name, err)
+		}
+	}
+
+	if d.StalePolicy == "" {
+		d.StalePolicy = StaleLastPrice
+	}
+
+	if !d.StalePolicy.isValid() {
+		return fmt.Errorf("invalid stale policy %q for index %q",
+			d.StalePolicy, d.Name)
+	}
+	return nil
+}
+
+// validateConstituents checks that there is at least one constituent, and that
+// each of them has a distinct trading pair and a positive weight.
+func validateConstituents(constituents []Constituent) error {
+	if len(constituents) == 0 {
+		return errors.New("no constituents")
+	}
+
+	pairs := make(map[string]bool, len(constituents))
+	for _, constituent := range constituents {
+		if constituent.Pair == "" {
+			return errors.New("empty trading pair")
+		}
+
+		if pairs[constituent.Pair] {
+			return fmt.Errorf("duplicate trading pair %q", constituent.Pair)
+		}
+		pairs[constituent.Pair] = true
+
+		if constituent.Weight <= 0 {
+			return fmt.Errorf("invalid weight %v for %q, must be positive",
+				constituent.Weight, constituent.Pair)
+		}
+	}
+	return nil
+}
+
+// validateQuoteCurrency checks that the given reported pairs of the
+// constituents of an index, including the ones of its rebalances, are all
+// quoted in the same currency, since their VWAPs are added up unconverted.
+func validateQuoteCurrency(pairs []string) error {
+	var quote, quotePair string
+	for _, pair := range pairs {
+		var pairQuote string
+		if parts := strings.SplitN(pair, "-", 2); len(parts) == 2 {
+			pairQuote = parts[1]
+		}
+
+		if quotePair == "" {
+			quote, quotePair = pairQuote, pair
+		} else if pairQuote != quote {
+			return fmt.Errorf("trading pairs %q and %q are quoted in "+
+				"different currencies", quotePair, pair)
+		}
+	}
+	return nil
+}
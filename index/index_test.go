@@ -0,0 +1,161 @@
+// +build unit
+
+package index
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ha2398/vwap/calc"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoadDefinitions(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		content        string
+		symbols        calc.SymbolMapping
+		expectedError  error
+		expectedOutput []Definition
+	}{
+		{
+			desc:          "invalid JSON",
+			content:       `{"name": "hello world"}`,
+			expectedError: errors.New("error parsing index file"),
+		},
+		{
+			desc:          "empty name",
+			content:       `[{"divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}]}]`,
+			expectedError: errors.New("empty index name"),
+		},
+		{
+			desc:          "invalid divisor",
+			content:       `[{"name": "I", "constituents": [{"pair": "BTC-USD", "weight": 1}]}]`,
+			expectedError: errors.New("invalid divisor 0 for index \"I\", must be positive"),
+		},
+		{
+			desc:          "no constituents",
+			content:       `[{"name": "I", "divisor": 1}]`,
+			expectedError: errors.New("invalid constituents for index \"I\": no constituents"),
+		},
+		{
+			desc:          "duplicate constituent",
+			content:       `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}, {"pair": "BTC-USD", "weight": 2}]}]`,
+			expectedError: errors.New("invalid constituents for index \"I\": duplicate trading pair \"BTC-USD\""),
+		},
+		{
+			desc:          "invalid weight",
+			content:       `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": -1}]}]`,
+			expectedError: errors.New("invalid constituents for index \"I\": invalid weight -1 for \"BTC-USD\", must be positive"),
+		},
+		{
+			desc:          "invalid stale policy",
+			content:       `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}], "stale_policy": "ignore"}]`,
+			expectedError: errors.New("invalid stale policy \"ignore\" for index \"I\""),
+		},
+		{
+			desc: "rebalances out of order",
+			content: `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}], "rebalances": [
+				{"at": "2022-06-01T00:00:00Z", "constituents": [{"pair": "ETH-USD", "weight": 1}]},
+				{"at": "2022-05-01T00:00:00Z", "constituents": [{"pair": "BTC-USD", "weight": 1}]}
+			]}]`,
+			expectedError: errors.New("rebalances of index \"I\" are not in chronological order"),
+		},
+		{
+			desc: "mixed quote currencies",
+			content: `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}], "rebalances": [
+				{"at": "2022-06-01T00:00:00Z", "constituents": [{"pair": "ETH-EUR", "weight": 1}]}
+			]}]`,
+			expectedError: errors.New("invalid constituents for index \"I\": trading pairs \"BTC-USD\" and \"ETH-EUR\" are quoted in different currencies"),
+		},
+		{
+			desc:    "quote currencies reported alike",
+			content: `[{"name": "I", "divisor": 1, "constituents": [{"pair": "BTC-USD", "weight": 1}, {"pair": "ETH-USDT", "weight": 1}]}]`,
+			symbols: calc.SymbolMapping{
+				QuoteEquivalents: map[string]string{"USDT": "USD"},
+			},
+			expectedOutput: []Definition{
+				{
+					Name:    "I",
+					Divisor: 1,
+					Constituents: []Constituent{
+						{Pair: "BTC-USD", Weight: 1},
+						{Pair: "ETH-USDT", Weight: 1},
+					},
+					StalePolicy: StaleLastPrice,
+				},
+			},
+		},
+		{
+			desc: "valid definitions",
+			content: `[{"name": "I", "divisor": 100, "constituents": [{"pair": "BTC-USD", "weight": 1}], "rebalances": [
+				{"at": "2022-06-01T00:00:00Z", "constituents": [{"pair": "ETH-USD", "weight": 10}]}
+			]}]`,
+			expectedOutput: []Definition{
+				{
+					Name:         "I",
+					Divisor:      100,
+					Constituents: []Constituent{{Pair: "BTC-USD", Weight: 1}},
+					Rebalances: []Rebalance{
+						{
+							At: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+							Constituents: []Constituent{
+								{Pair: "ETH-USD", Weight: 10},
+							},
+						},
+					},
+					StalePolicy: StaleLastPrice,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		file := filepath.Join(t.TempDir(), "indexes.json")
+		if err := ioutil.WriteFile(file, []byte(tc.content), 0600); err != nil {
+			t.Fatalf("Error writing test index file: %v", err)
+		}
+
+		output, err := LoadDefinitions(file, tc.symbols)
+		if tc.expectedError != nil {
+			if assert.NotNil(t, err, "For test %q, expected error", tc.desc) {
+				assert.Contains(t, err.Error(), tc.expectedError.Error(),
+					"For test %q, got unexpected error value", tc.desc)
+			}
+			continue
+		}
+
+		assert.Nil(t, err, "For test %q, got unexpected error", tc.desc)
+		assert.Equal(t, tc.expectedOutput, output,
+			"For test %q, got unexpected definitions", tc.desc)
+	}
+}
+
+func Test_LoadDefinitionsMissingFile(t *testing.T) {
+	_, err := LoadDefinitions(filepath.Join(t.TempDir(), "missing.json"),
+		calc.SymbolMapping{})
+	if assert.NotNil(t, err, "Expected error") {
+		assert.Contains(t, err.Error(), "error reading index file",
+			"Got unexpected error value")
+	}
+}
+
+func Test_Pairs(t *testing.T) {
+	definitions := []Definition{
+		{
+			Constituents: []Constituent{{Pair: "BTC-USD"}, {Pair: "ETH-USD"}},
+			Rebalances: []Rebalance{
+				{Constituents: []Constituent{{Pair: "SOL-USD"}}},
+			},
+		},
+		{
+			Constituents: []Constituent{{Pair: "ETH-USD"}, {Pair: "ETH-BTC"}},
+		},
+	}
+
+	assert.Equal(t, []string{"BTC-USD", "ETH-USD", "SOL-USD", "ETH-BTC"},
+		Pairs(definitions), "Got unexpected pairs")
+}
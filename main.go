@@ -2,11 +2,15 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
+	"github.com/ha2398/vwap/admin"
 	"github.com/ha2398/vwap/calc"
 	"github.com/ha2398/vwap/feed"
+	"github.com/ha2398/vwap/index"
 )
 
 // createInterruptChannel creates and returns a channel that notifies on
@@ -17,6 +21,91 @@ func createInterruptChannel() chan os.Signal {
 	return interrupt
 }
 
+// createVenue creates a connection to the feed with the given format, and
+// subscribes to the trading pairs of interest.
+func createVenue(format string) (calc.Venue, error) {
+	endpoint, err := getVenueEndpoint(format)
+	if err != nil {
+		return calc.Venue{}, err
+	}
+
+	feedConfig := feed.Config{
+		Endpoint:              endpoint,
+		Format:                format,
+		ProductIDs:            tradingPairs,
+		ConfirmationTimeout:   subscriptionTimeout,
+		AllowRejectedProducts: allowRejectedPairs,
+		PingInterval:          pingInterval,
+		ReadTimeout:           readTimeout,
+		WriteTimeout:          writeTimeout,
+		ProxyURL:              proxyURL,
+		CAFile:                caFile,
+		CertFile:              certFile,
+		KeyFile:               keyFile,
+		ServerName:            serverName,
+		HandshakeTimeout:      handshakeTimeout,
+		Headers:               http.Header(feedHeaders),
+	}
+
+	// Only the exchange format supports signed subscriptions.
+	if format == feed.ExchangeFormat {
+		feedConfig.Credentials = feedCredentials
+	}
+
+	feedConn, err := feed.CreateSubscription(feedConfig)
+	if err != nil {
+		return calc.Venue{}, err
+	}
+
+	venue := calc.Venue{
+		Conn:      feedConn,
+		Excluded:  excludedVenues.contains(format),
+		WeightCap: venueWeightCaps[format],
+	}
+
+	// Trading pairs added through the admin API are checked against the
+	// product catalog, as the initial ones. The catalog only lists the
+	// products of the exchange format.
+	if productCatalog != nil && format == feed.ExchangeFormat {
+		venue.ValidatePairs = productCatalog.Validate
+	}
+
+	// Reconnections subscribe to the trading pairs tracked at that time,
+	// which may have changed through the admin API.
+	if reconnect || reconnectOnStale {
+		venue.Reconnect = func(pairs []string) (*feed.Conn, error) {
+			config := feedConfig
+			config.ProductIDs = pairs
+			return feed.CreateSubscription(config)
+		}
+	}
+
+	return venue, nil
+}
+
+// logIndexes logs the levels of the given indexes at every interval, from
+// snapshots of the engine, until the done channel is closed.
+func logIndexes(
+	engine *calc.Engine,
+	calculator *index.Calculator,
+	interval time.Duration,
+	doneCh <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, level := range calculator.Update(engine.Snapshot()) {
+				log.Print(level.String())
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
 func main() {
 	log.SetFlags(0)
 	initFlags()
@@ -24,24 +113,78 @@ func main() {
 	// Create channel to detect interrupt signals.
 	interruptCh := createInterruptChannel()
 
-	// Create connection to feed and subscribe to channels of interest.
-	feedConn, err := feed.CreateSubscription(feedEndpoint, tradingPairs)
-	if err != nil {
-		log.Fatalf("Error creating feed subscription: %v", err)
-		return
+	// Create connections to the feed of each venue, and subscribe to
+	// channels of interest.
+	var venues []calc.Venue
+	for _, format := range venueFormats {
+		venue, err := createVenue(format)
+		if err != nil {
+			log.Fatalf("Error creating feed subscription for venue %q: %v",
+				format, err)
+			return
+		}
+		defer venue.Conn.Close()
+
+		venues = append(venues, venue)
+	}
+
+	// Create calculation engine, rounding VWAPs to each product's quote
+	// increment if the product catalog is available.
+	engineConfig := calc.Config{
+		TradingPairs:      tradingPairs,
+		WindowSize:        windowSize,
+		WindowType:        calc.WindowType(windowType),
+		HalfLife:          halfLife,
+		HalfLifeTrades:    halfLifeTrades,
+		LastMatchPolicy:   calc.LastMatchPolicy(lastMatchPolicy),
+		HeartbeatTimeout:  heartbeatTimeout,
+		TradeTimeout:      tradeTimeout,
+		ReconnectOnStale:  reconnectOnStale,
+		CrossRates:        getCrossRates(),
+		Series:            derivedSeries,
+		VWAPHorizons:      vwapHorizons,
+		TWAPPairs:         twapPairs,
+		VWAPBands:         vwapBands,
+		PriceStats:        priceStats,
+		VolumeProfiles:    volumeProfiles,
+		Bars:              bars,
+		ReportingCurrency: reportingCurrency,
+		SymbolMapping:     getSymbolMapping(),
+		PinnedPairs:       index.Pairs(indexDefinitions),
+	}
+	if productCatalog != nil {
+		engineConfig.RoundPrice = productCatalog.RoundPrice
 	}
-	defer feedConn.Close()
 
-	// Create calculation engine.
-	vwapEngine, err := calc.NewEngine(feedConn, tradingPairs, windowSize)
+	vwapEngine, err := calc.NewEngine(venues, engineConfig)
 	if err != nil {
 		log.Fatalf("Error creating new VWAP calculation engine: %v", err)
 		return
 	}
 
+	// Serve the admin API, used to change trading pairs at runtime.
+	if adminAddress != "" {
+		go func() {
+			err := http.ListenAndServe(adminAddress,
+				admin.NewHandler(vwapEngine, adminToken))
+			log.Printf("Admin API stopped: %v", err)
+		}()
+	}
+
 	// Start reading messages.
 	doneCh := vwapEngine.Run()
 
+	// Compute the indexes, if any, from the VWAPs.
+	if len(indexDefinitions) > 0 {
+		calculator, err := index.NewCalculator(indexDefinitions,
+			getSymbolMapping())
+		if err != nil {
+			log.Fatalf("Error creating index calculator: %v", err)
+			return
+		}
+		go logIndexes(vwapEngine, calculator, indexInterval, doneCh)
+	}
+
 	// Block until we are either done reading messages, or an interrupt signal
 	// is detected.
 	select {
@@ -0,0 +1,227 @@
+// Package products provides a catalog of product metadata, loaded from an
+// exchange REST endpoint and cached to disk.
+package products
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path of the product list, relative to the REST endpoint.
+const productsPath string = "/products"
+
+// Timeout for requests to the REST endpoint.
+const requestTimeout time.Duration = 10 * time.Second
+
+// Status of products that can be traded.
+const OnlineStatus string = "online"
+
+// Product holds the metadata of a product listed on the exchange.
+type Product struct {
+	ID             string
+	BaseCurrency   string
+	QuoteCurrency  string
+	BaseIncrement  float64
+	QuoteIncrement float64
+	Status         string
+}
+
+// rawProduct is the representation of a product in the REST API.
+type rawProduct struct {
+	ID             string `json:"id"`
+	BaseCurrency   string `json:"base_currency"`
+	QuoteCurrency  string `json:"quote_currency"`
+	BaseIncrement  string `json:"base_increment"`
+	QuoteIncrement string `json:"quote_increment"`
+	Status         string `json:"status"`
+}
+
+// Catalog holds the metadata for all products listed on the exchange.
+type Catalog struct {
+	products map[string]Product
+}
+
+// Load creates a catalog from the product list served by the given REST
+// endpoint, and stores the list in cacheFile. If the endpoint cannot be reached,
+// the catalog is loaded from cacheFile instead, so that startup works offline.
+// Caching is disabled if cacheFile is empty.
+func Load(endpoint, cacheFile string) (*Catalog, error) {
+	body, fetchErr := fetchProducts(endpoint)
+	if fetchErr == nil {
+		catalog, err := parseCatalog(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if cacheFile != "" {
+			if err := writeCache(cacheFile, body); err != nil {
+				log.Printf("Error caching product catalog: %v", err)
+			}
+		}
+		return catalog, nil
+	}
+
+	if cacheFile == "" {
+		return nil, fetchErr
+	}
+
+	body, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("%v, and error reading cache: %v", fetchErr,
+			err)
+	}
+
+	log.Printf("Warning: %v, using cached product catalog %q", fetchErr,
+		cacheFile)
+	return parseCatalog(body)
+}
+
+// fetchProducts returns the raw product list served by the REST endpoint.
+func fetchProducts(endpoint string) ([]byte, error) {
+	client := http.Client{Timeout: requestTimeout}
+	url := strings.TrimSuffix(endpoint, "/") + productsPath
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching products from %q: %v", url,
+			err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching products from %q: status %d",
+			url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading products from %q: %v", url,
+			err)
+	}
+	return body, nil
+}
+
+// writeCache atomically replaces the cache file with the given content.
+func writeCache(cacheFile string, body []byte) error {
+	dir := filepath.Dir(cacheFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(cacheFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(body); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), cacheFile)
+}
+
+// parseCatalog creates a catalog from a raw product list.
+func parseCatalog(body []byte) (*Catalog, error) {
+	var rawProducts []rawProduct
+	if err := json.Unmarshal(body, &rawProducts); err != nil {
+		return nil, fmt.Errorf("error parsing product list: %v", err)
+	}
+
+	catalog := &Catalog{products: make(map[string]Product)}
+	for _, raw := range rawProducts {
+		baseIncrement, err := parseIncrement(raw.BaseIncrement)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing base increment for %q: %v",
+				raw.ID, err)
+		}
+
+		quoteIncrement, err := parseIncrement(raw.QuoteIncrement)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing quote increment for %q: "+
+				"%v", raw.ID, err)
+		}
+
+		catalog.products[raw.ID] = Product{
+			ID:             raw.ID,
+			BaseCurrency:   raw.BaseCurrency,
+			QuoteCurrency:  raw.QuoteCurrency,
+			BaseIncrement:  baseIncrement,
+			QuoteIncrement: quoteIncrement,
+			Status:         raw.Status,
+		}
+	}
+
+	return catalog, nil
+}
+
+// parseIncrement parses an increment, which is 0 when absent.
+func parseIncrement(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Get returns the metadata for the given product ID, and a bool indicating if
+// the product is in the catalog.
+func (c *Catalog) Get(id string) (Product, bool) {
+	product, ok := c.products[id]
+	return product, ok
+}
+
+// Validate checks that all the given product IDs are in the catalog and
+// online, returning an error listing the ones that are not.
+func (c *Catalog) Validate(productIDs []string) error {
+	var unknown, offline []string
+	for _, id := range productIDs {
+		product, ok := c.products[id]
+		if !ok {
+			unknown = append(unknown, id)
+		} else if product.Status != OnlineStatus {
+			offline = append(offline, id)
+		}
+	}
+
+	var problems []string
+	if len(unknown) > 0 {
+		problems = append(problems, fmt.Sprintf("unknown products %v",
+			unknown))
+	}
+
+	if len(offline) > 0 {
+		problems = append(problems, fmt.Sprintf("products not online %v",
+			offline))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid trading pairs: %s",
+			strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// RoundPrice rounds the given price to the quote increment of the product. The
+// price is returned unchanged if the product is unknown or has no increment.
+func (c *Catalog) RoundPrice(id string, price float64) float64 {
+	product, ok := c.products[id]
+	if !ok || product.QuoteIncrement <= 0 {
+		return price
+	}
+
+	return math.Round(price/product.QuoteIncrement) * product.QuoteIncrement
+}
@@ -0,0 +1,240 @@
+// +build unit
+
+package products
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProductList string = `[
+	{
+		"id": "BTC-USD",
+		"base_currency": "BTC",
+		"quote_currency": "USD",
+		"base_increment": "0.00000001",
+		"quote_increment": "0.01000000",
+		"status": "online"
+	},
+	{
+		"id": "ETH-BTC",
+		"base_currency": "ETH",
+		"quote_currency": "BTC",
+		"base_increment": "0.00000001",
+		"quote_increment": "0.00001000",
+		"status": "online"
+	},
+	{
+		"id": "OLD-USD",
+		"base_currency": "OLD",
+		"quote_currency": "USD",
+		"base_increment": "1",
+		"quote_increment": "0.0001",
+		"status": "delisted"
+	}
+]`
+
+// newProductsServer spins up a test server that serves the given body and
+// status code for the product list.
+func newProductsServer(statusCode int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != productsPath {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.WriteHeader(statusCode)
+			w.Write([]byte(body))
+		}))
+}
+
+func Test_Load(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		statusCode      int
+		body            string
+		cachedBody      string
+		useCache        bool
+		expectedError   error
+		expectedProduct Product
+		expectedCache   string
+	}{
+		{
+			desc:          "endpoint error, no cache",
+			statusCode:    http.StatusInternalServerError,
+			expectedError: errors.New("status 500"),
+		},
+		{
+			desc:          "endpoint error, missing cache file",
+			statusCode:    http.StatusInternalServerError,
+			useCache:      true,
+			expectedError: errors.New("error reading cache"),
+		},
+		{
+			desc:          "invalid product list",
+			statusCode:    http.StatusOK,
+			body:          `{"message": "hello world"}`,
+			expectedError: errors.New("error parsing product list"),
+		},
+		{
+			desc:          "invalid increment",
+			statusCode:    http.StatusOK,
+			body:          `[{"id": "A-B", "quote_increment": "hello world"}]`,
+			expectedError: errors.New("error parsing quote increment for \"A-B\""),
+		},
+		{
+			desc:       "valid product list, cached",
+			statusCode: http.StatusOK,
+			body:       testProductList,
+			useCache:   true,
+			expectedProduct: Product{
+				ID:             "ETH-BTC",
+				BaseCurrency:   "ETH",
+				QuoteCurrency:  "BTC",
+				BaseIncrement:  0.00000001,
+				QuoteIncrement: 0.00001,
+				Status:         OnlineStatus,
+			},
+			expectedCache: testProductList,
+		},
+		{
+			desc:       "endpoint error, loaded from cache",
+			statusCode: http.StatusServiceUnavailable,
+			cachedBody: testProductList,
+			useCache:   true,
+			expectedProduct: Product{
+				ID:             "ETH-BTC",
+				BaseCurrency:   "ETH",
+				QuoteCurrency:  "BTC",
+				BaseIncrement:  0.00000001,
+				QuoteIncrement: 0.00001,
+				Status:         OnlineStatus,
+			},
+			expectedCache: testProductList,
+		},
+	}
+
+	for _, tc := range testCases {
+		server := newProductsServer(tc.statusCode, tc.body)
+
+		var cacheFile string
+		if tc.useCache {
+			cacheFile = filepath.Join(t.TempDir(), "cache", "products.json")
+		}
+
+		if tc.cachedBody != "" {
+			if err := writeCache(cacheFile, []byte(tc.cachedBody)); err != nil {
+				t.Fatalf("Error writing test cache file: %v", err)
+			}
+		}
+
+		catalog, err := Load(server.URL, cacheFile)
+		server.Close()
+
+		if tc.expectedError != nil {
+			if assert.NotNil(t, err, "For test %q, expected error", tc.desc) {
+				assert.Contains(t, err.Error(), tc.expectedError.Error(),
+					"For test %q, got unexpected error value", tc.desc)
+			}
+			continue
+		}
+
+		assert.Nil(t, err, "For test %q, got unexpected error", tc.desc)
+
+		product, ok := catalog.Get(tc.expectedProduct.ID)
+		assert.True(t, ok, "For test %q, product not found", tc.desc)
+		assert.Equal(t, tc.expectedProduct, product,
+			"For test %q, got unexpected product", tc.desc)
+
+		cached, err := ioutil.ReadFile(cacheFile)
+		assert.Nil(t, err, "For test %q, error reading cache", tc.desc)
+		assert.Equal(t, tc.expectedCache, string(cached),
+			"For test %q, got unexpected cache content", tc.desc)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	catalog, err := parseCatalog([]byte(testProductList))
+	if err != nil {
+		t.Fatalf("Error parsing test product list: %v", err)
+	}
+
+	testCases := []struct {
+		desc          string
+		productIDs    []string
+		expectedError error
+	}{
+		{
+			desc:          "no products",
+			productIDs:    []string{},
+			expectedError: nil,
+		},
+		{
+			desc:          "valid products",
+			productIDs:    []string{"BTC-USD", "ETH-BTC"},
+			expectedError: nil,
+		},
+		{
+			desc:          "unknown product",
+			productIDs:    []string{"BTC-USD", "BTC-UDS"},
+			expectedError: errors.New("invalid trading pairs: unknown products [BTC-UDS]"),
+		},
+		{
+			desc:          "unknown and offline products",
+			productIDs:    []string{"OLD-USD", "BTC-UDS", "A-B"},
+			expectedError: errors.New("invalid trading pairs: unknown products [BTC-UDS A-B], products not online [OLD-USD]"),
+		},
+	}
+
+	for _, tc := range testCases {
+		err := catalog.Validate(tc.productIDs)
+		assert.Equal(t, tc.expectedError, err,
+			"For test %q, got unexpected error value", tc.desc)
+	}
+}
+
+func Test_RoundPrice(t *testing.T) {
+	catalog, err := parseCatalog([]byte(testProductList))
+	if err != nil {
+		t.Fatalf("Error parsing test product list: %v", err)
+	}
+
+	testCases := []struct {
+		desc           string
+		productID      string
+		price          float64
+		expectedOutput float64
+	}{
+		{
+			desc:           "unknown product",
+			productID:      "A-B",
+			price:          1.23456,
+			expectedOutput: 1.23456,
+		},
+		{
+			desc:           "cent increment",
+			productID:      "BTC-USD",
+			price:          29123.456789,
+			expectedOutput: 29123.46,
+		},
+		{
+			desc:           "small increment",
+			productID:      "ETH-BTC",
+			price:          0.0712345,
+			expectedOutput: 0.07123,
+		},
+	}
+
+	for _, tc := range testCases {
+		output := catalog.RoundPrice(tc.productID, tc.price)
+		assert.InDelta(t, tc.expectedOutput, output, 1e-9,
+			"For test %q, got wrong output", tc.desc)
+	}
+}